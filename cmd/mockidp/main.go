@@ -0,0 +1,35 @@
+// Command mockidp is a minimal OpenID Connect provider for trying HopRun's
+// single sign-on locally. It signs in anyone without asking, as the address in
+// the login_hint parameter or MOCK_IDP_EMAIL, and must never be exposed.
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/cr34t1ve/hoprun/internal/sso/mockidp"
+)
+
+func main() {
+	addr := envOr("MOCK_IDP_ADDR", ":9000")
+	p, err := mockidp.New(
+		envOr("MOCK_IDP_ISSUER", "http://localhost:9000"),
+		envOr("MOCK_IDP_CLIENT_ID", "hoprun"),
+		os.Getenv("MOCK_IDP_CLIENT_SECRET"),
+		os.Getenv("MOCK_IDP_EMAIL"),
+	)
+	if err != nil {
+		log.Fatal("Failed to generate signing key:", err)
+	}
+
+	log.Printf("Mock identity provider %s is running on %s", p.Issuer(), addr)
+	log.Fatal(http.ListenAndServe(addr, p))
+}
+
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -1,21 +1,34 @@
 package main
 
 import (
-	"fmt"
+	"context"
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
 
 	"github.com/gorilla/mux"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 
 	"github.com/cr34t1ve/hoprun/internal/api"
+	apikey "github.com/cr34t1ve/hoprun/internal/api_key"
 	"github.com/cr34t1ve/hoprun/internal/auth"
+	connectionhealth "github.com/cr34t1ve/hoprun/internal/connection_health"
+	connectionmanager "github.com/cr34t1ve/hoprun/internal/connection_manager"
+	"github.com/cr34t1ve/hoprun/internal/costguard"
 	"github.com/cr34t1ve/hoprun/internal/database"
 	databaseconnection "github.com/cr34t1ve/hoprun/internal/database_connection"
+	"github.com/cr34t1ve/hoprun/internal/middleware"
 	"github.com/cr34t1ve/hoprun/internal/nlp"
+	projectcontext "github.com/cr34t1ve/hoprun/internal/project_context"
 	"github.com/cr34t1ve/hoprun/internal/query"
+	"github.com/cr34t1ve/hoprun/internal/secrets"
+	sshtunnel "github.com/cr34t1ve/hoprun/internal/ssh_tunnel"
+	"github.com/cr34t1ve/hoprun/internal/sso"
+	"github.com/cr34t1ve/hoprun/internal/team"
+	"github.com/cr34t1ve/hoprun/pkg/models"
 )
 
 func main() {
@@ -26,27 +39,147 @@ func main() {
 		log.Fatal("Failed to connect to database:", err)
 	}
 
-	fmt.Printf("open ai key: %s", os.Getenv("JWT_SECRET"))
+	if err := db.AutoMigrate(&models.User{}, &models.Project{}, &models.DatabaseConnection{},
+		&models.ContextDocument{}, &models.ContextDocumentVersion{}, &models.Session{}, &models.RotatedRefreshToken{}, &models.APIKey{},
+		&models.Organization{}, &models.OrganizationMember{}, &models.ProjectMember{}, &models.Invitation{}); err != nil {
+		log.Fatal("Failed to migrate database:", err)
+	}
+
+	providers, llmDefaults, err := nlp.ProvidersFromEnv()
+	if err != nil {
+		log.Fatal("Failed to configure LLM providers:", err)
+	}
+
+	keys, err := secrets.KeyProviderFromEnv()
+	if err != nil {
+		log.Fatal("Failed to load encryption keys:", err)
+	}
+
+	maxConnections := databaseconnection.DefaultMaxConnections
+	if value := os.Getenv("MAX_CONNECTIONS_PER_PROJECT"); value != "" {
+		if maxConnections, err = strconv.Atoi(value); err != nil {
+			log.Fatal("Invalid MAX_CONNECTIONS_PER_PROJECT:", err)
+		}
+	}
+
+	// sqlite and duckdb connections may only open files under this directory
+	fileDir := os.Getenv("FILE_DATABASE_DIR")
+	if fileDir != "" {
+		if fileDir, err = filepath.Abs(fileDir); err != nil {
+			log.Fatal("Invalid FILE_DATABASE_DIR:", err)
+		}
+	}
+
+	// tokens signed with an empty key could be forged by anyone
+	if os.Getenv("JWT_SECRET") == "" {
+		log.Fatal("JWT_SECRET must be set")
+	}
+
+	oidcConfig, err := sso.ConfigFromEnv()
+	if err != nil {
+		log.Fatal("Invalid single sign-on configuration:", err)
+	}
 
 	// Initialize services
 	dbService := database.NewService(db)
-	dbConnService := databaseconnection.NewService(db)
-	nlpService := nlp.NewService("<API Key>")
+	dbConnService := databaseconnection.NewService(db, secrets.NewService(keys), maxConnections, fileDir)
+
+	// "server reencrypt" rewraps stored credentials with the current key and exits
+	if len(os.Args) > 1 && os.Args[1] == "reencrypt" {
+		changed, err := dbConnService.ReencryptConnections(context.Background())
+		if err != nil {
+			log.Fatal("Failed to re-encrypt connections:", err)
+		}
+		log.Printf("Re-encrypted %d connections with key %s", changed, keys.CurrentKeyID())
+		return
+	}
+
+	nlpService := nlp.NewService(providers, llmDefaults)
 	queryService := query.NewService(dbService)
-	authService := auth.NewService(dbService)
+	teamService := team.NewService(db)
+	authService := auth.NewService(dbService, teamService)
+	schemaCache := database.NewSchemaCache()
+	projectContextService := projectcontext.NewService(db)
+	apiKeyService := apikey.NewService(db)
+	var ssoService sso.Service
+	if oidcConfig != nil {
+		if ssoService, err = sso.NewService(context.Background(), oidcConfig); err != nil {
+			log.Fatal("Failed to discover the OpenID Connect provider:", err)
+		}
+	}
+	confirmations := costguard.NewConfirmations()
+	tunnels := sshtunnel.NewManager(sshtunnel.DefaultIdleTimeout)
+	go tunnels.Run(context.Background())
+	connectionManager := connectionmanager.NewManager(connectionmanager.DefaultIdleTimeout, tunnels)
+	go connectionManager.Run(context.Background())
+	connectionHealth := connectionhealth.NewChecker(dbConnService, tunnels, connectionhealth.DefaultTimeout)
+	go connectionHealth.Run(context.Background(), connectionhealth.DefaultInterval)
 
 	// Initialize handler
-	handler := api.NewHandler(nlpService, queryService, dbService, authService, dbConnService)
+	handler := api.NewHandler(nlpService, queryService, dbService, authService, dbConnService, schemaCache, projectContextService, confirmations, connectionManager, connectionHealth, apiKeyService, teamService, ssoService)
 
 	// Set up router
 	r := mux.NewRouter()
 	r.HandleFunc("/register", handler.Register).Methods("POST")
 	r.HandleFunc("/login", handler.Login).Methods("POST")
-	r.HandleFunc("/project", handler.CreateProject).Methods("POST")
-	r.HandleFunc("/getproject", handler.ListUserProjects).Methods("POST")
-	r.HandleFunc("/addConnection", handler.AddConnection).Methods("POST")
-	r.HandleFunc("/getConnections", handler.ListDBConns).Methods("POST")
-	r.HandleFunc("/query", handler.HandleQuery).Methods("POST")
+	r.HandleFunc("/refresh", handler.Refresh).Methods("POST")
+	// single sign-on is only served when OIDC_ISSUER_URL is set
+	if ssoService != nil {
+		r.HandleFunc("/oidc/login", handler.OIDCLogin).Methods("GET")
+		r.HandleFunc("/oidc/callback", handler.OIDCCallback).Methods("GET")
+	}
+
+	// every other route needs an access token from /login or /refresh, or an
+	// API key; keys only reach the routes their scopes allow
+	protected := r.NewRoute().Subrouter()
+	protected.Use(middleware.AuthMiddleware(authService, apiKeyService))
+
+	userRoutes := protected.NewRoute().Subrouter()
+	userRoutes.Use(middleware.RequireUser)
+	userRoutes.HandleFunc("/logout", handler.Logout).Methods("POST")
+	userRoutes.HandleFunc("/logoutAll", handler.LogoutAll).Methods("POST")
+	userRoutes.HandleFunc("/project", handler.CreateProject).Methods("POST")
+	userRoutes.HandleFunc("/getproject", handler.ListUserProjects).Methods("POST")
+	userRoutes.HandleFunc("/addAPIKey", handler.AddAPIKey).Methods("POST")
+	userRoutes.HandleFunc("/getAPIKeys", handler.ListAPIKeys).Methods("POST")
+	userRoutes.HandleFunc("/updateAPIKey", handler.UpdateAPIKey).Methods("POST")
+	userRoutes.HandleFunc("/deleteAPIKey", handler.DeleteAPIKey).Methods("POST")
+	userRoutes.HandleFunc("/organization", handler.CreateOrganization).Methods("POST")
+	userRoutes.HandleFunc("/getOrganizations", handler.ListOrganizations).Methods("POST")
+	userRoutes.HandleFunc("/getOrganizationMembers", handler.ListOrganizationMembers).Methods("POST")
+	userRoutes.HandleFunc("/updateOrganizationMember", handler.UpdateOrganizationMember).Methods("POST")
+	userRoutes.HandleFunc("/removeOrganizationMember", handler.RemoveOrganizationMember).Methods("POST")
+	userRoutes.HandleFunc("/getProjectMembers", handler.ListProjectMembers).Methods("POST")
+	userRoutes.HandleFunc("/updateProjectMember", handler.UpdateProjectMember).Methods("POST")
+	userRoutes.HandleFunc("/removeProjectMember", handler.RemoveProjectMember).Methods("POST")
+	userRoutes.HandleFunc("/invite", handler.Invite).Methods("POST")
+	userRoutes.HandleFunc("/getInvitations", handler.ListInvitations).Methods("POST")
+	userRoutes.HandleFunc("/revokeInvitation", handler.RevokeInvitation).Methods("POST")
+	userRoutes.HandleFunc("/acceptInvitation", handler.AcceptInvitation).Methods("POST")
+
+	queryRoutes := protected.NewRoute().Subrouter()
+	queryRoutes.Use(middleware.RequireScope(models.APIKeyScopeQuery))
+	queryRoutes.HandleFunc("/query", handler.HandleQuery).Methods("POST")
+
+	metadataRoutes := protected.NewRoute().Subrouter()
+	metadataRoutes.Use(middleware.RequireScope(models.APIKeyScopeReadMetadata))
+	metadataRoutes.HandleFunc("/getConnections", handler.ListDBConns).Methods("POST")
+	metadataRoutes.HandleFunc("/getPoolStats", handler.GetPoolStats).Methods("POST")
+	metadataRoutes.HandleFunc("/getSchemaCache", handler.GetSchemaCacheStats).Methods("POST")
+	metadataRoutes.HandleFunc("/getContexts", handler.ListContextDocuments).Methods("POST")
+	metadataRoutes.HandleFunc("/getContextVersions", handler.ListContextDocumentVersions).Methods("POST")
+
+	adminRoutes := protected.NewRoute().Subrouter()
+	adminRoutes.Use(middleware.RequireScope(models.APIKeyScopeAdmin))
+	adminRoutes.HandleFunc("/updateProject", handler.UpdateProject).Methods("POST")
+	adminRoutes.HandleFunc("/addConnection", handler.AddConnection).Methods("POST")
+	adminRoutes.HandleFunc("/updateConnection", handler.UpdateConnection).Methods("POST")
+	adminRoutes.HandleFunc("/deleteConnection", handler.DeleteConnection).Methods("POST")
+	adminRoutes.HandleFunc("/setDefaultConnection", handler.SetDefaultConnection).Methods("POST")
+	adminRoutes.HandleFunc("/testConnection", handler.TestConnection).Methods("POST")
+	adminRoutes.HandleFunc("/addContext", handler.AddContextDocument).Methods("POST")
+	adminRoutes.HandleFunc("/updateContext", handler.UpdateContextDocument).Methods("POST")
+	adminRoutes.HandleFunc("/deleteContext", handler.DeleteContextDocument).Methods("POST")
 
 	// Start server
 	log.Println("Server is running on http://localhost:8080")
@@ -0,0 +1,152 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+
+	apikey "github.com/cr34t1ve/hoprun/internal/api_key"
+	"github.com/cr34t1ve/hoprun/internal/middleware"
+	"github.com/cr34t1ve/hoprun/pkg/models"
+)
+
+type apiKeyInput struct {
+	ID        int        `json:"id"`
+	ProjectID int        `json:"project_id"`
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+func (input *apiKeyInput) validate() error {
+	if input.Name == "" {
+		return errors.New("name is required")
+	}
+	if input.ExpiresAt != nil && !input.ExpiresAt.After(time.Now()) {
+		return errors.New("expires_at must be in the future")
+	}
+	return apikey.ValidateScopes(input.Scopes)
+}
+
+// AddAPIKey creates a project API key. The key itself is only returned in
+// this response.
+func (h *Handler) AddAPIKey(w http.ResponseWriter, r *http.Request) {
+	var input apiKeyInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := input.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, _, err := h.authorizeProject(r.Context(), input.ProjectID, models.RoleAdmin); err != nil {
+		writeAuthorizeError(w, err, "Project not found")
+		return
+	}
+
+	userID, _ := middleware.UserID(r.Context())
+	key, secret, err := h.apiKeys.CreateKey(r.Context(), &models.APIKey{
+		ProjectID: input.ProjectID,
+		Name:      input.Name,
+		Scopes:    input.Scopes,
+		CreatedBy: userID,
+		ExpiresAt: input.ExpiresAt,
+	})
+	if err != nil {
+		http.Error(w, "Failed to create API key: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(struct {
+		*models.APIKey
+		Key string `json:"key"`
+	}{key, secret})
+}
+
+func (h *Handler) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		ProjectID int `json:"project_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, _, err := h.authorizeProject(r.Context(), input.ProjectID, models.RoleAdmin); err != nil {
+		writeAuthorizeError(w, err, "Project not found")
+		return
+	}
+
+	keys, err := h.apiKeys.ListKeys(r.Context(), input.ProjectID)
+	if err != nil {
+		http.Error(w, "Failed to list API keys: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(keys)
+}
+
+// UpdateAPIKey renames a key and replaces its scopes and expiry. The key
+// itself does not change.
+func (h *Handler) UpdateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var input apiKeyInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := input.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := h.authorizeAPIKey(r.Context(), input.ID, models.RoleAdmin); err != nil {
+		writeAuthorizeError(w, err, "API key not found")
+		return
+	}
+
+	key, err := h.apiKeys.UpdateKey(r.Context(), &models.APIKey{
+		ID:        input.ID,
+		Name:      input.Name,
+		Scopes:    input.Scopes,
+		ExpiresAt: input.ExpiresAt,
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, "API key not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to update API key: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(key)
+}
+
+// DeleteAPIKey revokes a key immediately.
+func (h *Handler) DeleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		ID int `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := h.authorizeAPIKey(r.Context(), input.ID, models.RoleAdmin); err != nil {
+		writeAuthorizeError(w, err, "API key not found")
+		return
+	}
+
+	if err := h.apiKeys.DeleteKey(r.Context(), input.ID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, "API key not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to delete API key: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
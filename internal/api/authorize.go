@@ -0,0 +1,110 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"gorm.io/gorm"
+
+	"github.com/cr34t1ve/hoprun/internal/auth"
+	"github.com/cr34t1ve/hoprun/internal/middleware"
+	"github.com/cr34t1ve/hoprun/pkg/models"
+)
+
+// Every lookup below is scoped to the authenticated user's role, and for API
+// keys to the key's project. Projects, connections and documents the caller
+// cannot reach are reported as gorm.ErrRecordNotFound so their IDs cannot be
+// probed; a role below minRole is auth.ErrForbidden.
+
+func (h *Handler) authorizeProject(ctx context.Context, projectID int, minRole string) (*models.Project, string, error) {
+	userID, ok := middleware.UserID(ctx)
+	if !ok {
+		return nil, "", gorm.ErrRecordNotFound
+	}
+	if key, ok := middleware.APIKey(ctx); ok && key.ProjectID != projectID {
+		return nil, "", gorm.ErrRecordNotFound
+	}
+	return h.authService.AuthorizeProject(ctx, userID, projectID, minRole)
+}
+
+func (h *Handler) authorizeConnection(ctx context.Context, connectionID int, minRole string) error {
+	projectID, err := h.databaseconnection.GetConnectionProjectID(ctx, connectionID)
+	if err != nil {
+		return err
+	}
+	_, _, err = h.authorizeProject(ctx, projectID, minRole)
+	return err
+}
+
+func (h *Handler) authorizeDocument(ctx context.Context, documentID int, minRole string) error {
+	document, err := h.projectContext.GetDocument(ctx, documentID)
+	if err != nil {
+		return err
+	}
+	_, _, err = h.authorizeProject(ctx, document.ProjectID, minRole)
+	return err
+}
+
+// authorizeDocumentHistory checks access to a document's versions through
+// the project they were written in, which still holds once the document is
+// deleted.
+func (h *Handler) authorizeDocumentHistory(ctx context.Context, documentID int, minRole string) error {
+	projectID, err := h.projectContext.GetDocumentProjectID(ctx, documentID)
+	if err != nil {
+		return err
+	}
+	_, _, err = h.authorizeProject(ctx, projectID, minRole)
+	return err
+}
+
+func (h *Handler) authorizeAPIKey(ctx context.Context, keyID int, minRole string) error {
+	key, err := h.apiKeys.GetKey(ctx, keyID)
+	if err != nil {
+		return err
+	}
+	_, _, err = h.authorizeProject(ctx, key.ProjectID, minRole)
+	return err
+}
+
+// authorizeOrganization returns the user's role in an organization, provided
+// it is at least minRole.
+func (h *Handler) authorizeOrganization(ctx context.Context, organizationID int, minRole string) (string, error) {
+	userID, _ := middleware.UserID(ctx)
+	role, err := h.teams.OrganizationRole(ctx, userID, organizationID)
+	if err != nil {
+		return "", err
+	}
+	if role == "" {
+		return "", gorm.ErrRecordNotFound
+	}
+	if !models.OrgRoleAtLeast(role, minRole) {
+		return "", auth.ErrForbidden
+	}
+	return role, nil
+}
+
+// caller identifies who made a request: the API key for requests made with
+// one, the user otherwise.
+func caller(ctx context.Context) string {
+	if key, ok := middleware.APIKey(ctx); ok {
+		return "key:" + strconv.Itoa(key.ID)
+	}
+	userID, _ := middleware.UserID(ctx)
+	return "user:" + strconv.Itoa(userID)
+}
+
+// writeAuthorizeError reports a failed access check, using notFound as the
+// message when the record is missing or out of the user's reach.
+func writeAuthorizeError(w http.ResponseWriter, err error, notFound string) {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, notFound, http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, auth.ErrForbidden) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	http.Error(w, "Failed to authorize request: "+err.Error(), http.StatusInternalServerError)
+}
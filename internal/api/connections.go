@@ -0,0 +1,405 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"gorm.io/gorm"
+
+	databaseconnection "github.com/cr34t1ve/hoprun/internal/database_connection"
+	"github.com/cr34t1ve/hoprun/internal/dsn"
+	sshtunnel "github.com/cr34t1ve/hoprun/internal/ssh_tunnel"
+	"github.com/cr34t1ve/hoprun/pkg/models"
+)
+
+type connectionInput struct {
+	ID         int    `json:"id"`
+	ProjectID  int    `json:"project_id"`
+	Name       string `json:"name"`
+	Dialect    string `json:"dialect"`
+	FilePath   string `json:"file_path"`
+	DBName     string `json:"db_name"`
+	DBUser     string `json:"db_user"`
+	DBPassword string `json:"db_password"`
+	DBHost     string `json:"db_host"`
+	DBPort     string `json:"db_port"`
+
+	SSLMode         string            `json:"sslmode"`
+	SSLRootCert     string            `json:"sslrootcert"`
+	SSLCert         string            `json:"sslcert"`
+	SSLKey          string            `json:"sslkey"`
+	ApplicationName string            `json:"application_name"`
+	Params          map[string]string `json:"params"`
+
+	SSHHost       string `json:"ssh_host"`
+	SSHPort       string `json:"ssh_port"`
+	SSHUser       string `json:"ssh_user"`
+	SSHPrivateKey string `json:"ssh_private_key"`
+	SSHHostKey    string `json:"ssh_host_key"`
+
+	IncludeSchemas []string `json:"include_schemas"`
+	ExcludeSchemas []string `json:"exclude_schemas"`
+
+	SampleColumnValues bool `json:"sample_column_values"`
+	StatementTimeoutMs int  `json:"statement_timeout_ms"`
+	MaxRows            int  `json:"max_rows"`
+	MaxOpenConns       int  `json:"max_open_conns"`
+	MaxIdleConns       int  `json:"max_idle_conns"`
+
+	// LegacyProjectID is the misspelt key connections used for project_id
+	// until it was renamed; it is still accepted from older clients
+	LegacyProjectID int `json:"projecct_id"`
+}
+
+func (input *connectionInput) validate() error {
+	if input.ProjectID == 0 {
+		input.ProjectID = input.LegacyProjectID
+	}
+	if input.StatementTimeoutMs < 0 || input.MaxRows < 0 {
+		return errors.New("statement_timeout_ms and max_rows must not be negative")
+	}
+	if input.MaxOpenConns < 0 || input.MaxIdleConns < 0 {
+		return errors.New("max_open_conns and max_idle_conns must not be negative")
+	}
+	conn := input.toModel()
+	if err := dsn.Validate(conn); err != nil {
+		return err
+	}
+	return sshtunnel.Validate(conn)
+}
+
+func (input *connectionInput) toModel() *models.DatabaseConnection {
+	return &models.DatabaseConnection{
+		ID:         input.ID,
+		ProjectID:  input.ProjectID,
+		Name:       input.Name,
+		Dialect:    input.Dialect,
+		FilePath:   input.FilePath,
+		DBName:     input.DBName,
+		DBUser:     input.DBUser,
+		DBPassword: input.DBPassword,
+		DBHost:     input.DBHost,
+		DBPort:     input.DBPort,
+
+		SSLMode:         input.SSLMode,
+		SSLRootCert:     input.SSLRootCert,
+		SSLCert:         input.SSLCert,
+		SSLKey:          input.SSLKey,
+		ApplicationName: input.ApplicationName,
+		Params:          input.Params,
+
+		SSHHost:       input.SSHHost,
+		SSHPort:       input.SSHPort,
+		SSHUser:       input.SSHUser,
+		SSHPrivateKey: input.SSHPrivateKey,
+		SSHHostKey:    input.SSHHostKey,
+
+		IncludeSchemas: input.IncludeSchemas,
+		ExcludeSchemas: input.ExcludeSchemas,
+
+		SampleColumnValues: input.SampleColumnValues,
+		StatementTimeoutMs: input.StatementTimeoutMs,
+		MaxRows:            input.MaxRows,
+		MaxOpenConns:       input.MaxOpenConns,
+		MaxIdleConns:       input.MaxIdleConns,
+	}
+}
+
+// connectionInputFrom returns a stored connection's settings, leaving out its
+// credentials.
+func connectionInputFrom(connection *models.DatabaseConnection) connectionInput {
+	return connectionInput{
+		ID:        connection.ID,
+		ProjectID: connection.ProjectID,
+		Name:      connection.Name,
+		Dialect:   connection.Dialect,
+		FilePath:  connection.FilePath,
+		DBName:    connection.DBName,
+		DBUser:    connection.DBUser,
+		DBHost:    connection.DBHost,
+		DBPort:    connection.DBPort,
+
+		SSLMode:         connection.SSLMode,
+		SSLRootCert:     connection.SSLRootCert,
+		SSLCert:         connection.SSLCert,
+		ApplicationName: connection.ApplicationName,
+		Params:          connection.Params,
+
+		SSHHost:    connection.SSHHost,
+		SSHPort:    connection.SSHPort,
+		SSHUser:    connection.SSHUser,
+		SSHHostKey: connection.SSHHostKey,
+
+		IncludeSchemas: connection.IncludeSchemas,
+		ExcludeSchemas: connection.ExcludeSchemas,
+
+		SampleColumnValues: connection.SampleColumnValues,
+		StatementTimeoutMs: connection.StatementTimeoutMs,
+		MaxRows:            connection.MaxRows,
+		MaxOpenConns:       connection.MaxOpenConns,
+		MaxIdleConns:       connection.MaxIdleConns,
+	}
+}
+
+func (h *Handler) AddConnection(w http.ResponseWriter, r *http.Request) {
+	var input connectionInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := input.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	input.ID = 0
+	if _, _, err := h.authorizeProject(r.Context(), input.ProjectID, models.RoleAdmin); err != nil {
+		writeAuthorizeError(w, err, "Project not found")
+		return
+	}
+
+	connection, err := h.databaseconnection.AddConnection(r.Context(), input.toModel())
+	if err != nil {
+		if errors.Is(err, databaseconnection.ErrConnectionLimit) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if isSettingsError(err) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			http.Error(w, "A connection with this name already exists in the project", http.StatusConflict)
+			return
+		}
+		if errors.Is(err, gorm.ErrForeignKeyViolated) {
+			http.Error(w, "Failed to add database connection", http.StatusInternalServerError)
+			return
+		}
+		http.Error(w, "Failed to add connection: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(connection)
+}
+
+// UpdateConnection changes the settings sent with the request. Settings left
+// out keep their stored values, and an empty db_password keeps the stored
+// one. The connection's pool and cached schema are dropped so the next query
+// uses the new settings.
+func (h *Handler) UpdateConnection(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var target struct {
+		ID int `json:"id"`
+	}
+	if err := json.Unmarshal(body, &target); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.authorizeConnection(r.Context(), target.ID, models.RoleAdmin); err != nil {
+		writeAuthorizeError(w, err, "Connection not found")
+		return
+	}
+	existing, err := h.databaseconnection.GetStoredConnection(r.Context(), target.ID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, "Connection not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to get connection: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// the request is decoded over the stored settings, so only the ones it
+	// names change
+	input := connectionInputFrom(existing)
+	if err := json.Unmarshal(body, &input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	input.ID = existing.ID
+	if err := input.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	connection, err := h.databaseconnection.UpdateConnection(r.Context(), input.toModel())
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, "Connection not found", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			http.Error(w, "A connection with this name already exists in the project", http.StatusConflict)
+			return
+		}
+		if isSettingsError(err) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "Failed to update connection: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.releaseConnection(connection.ID)
+
+	json.NewEncoder(w).Encode(connection)
+}
+
+// isSettingsError reports whether a connection was refused because of its
+// file path or missing keys.
+func isSettingsError(err error) bool {
+	return errors.Is(err, databaseconnection.ErrFileNotFound) || errors.Is(err, databaseconnection.ErrFileDatabasesDisabled) ||
+		errors.Is(err, databaseconnection.ErrSSLKeyRequired) || errors.Is(err, databaseconnection.ErrSSHKeyRequired)
+}
+
+func (h *Handler) DeleteConnection(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		ID int `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.authorizeConnection(r.Context(), input.ID, models.RoleAdmin); err != nil {
+		writeAuthorizeError(w, err, "Connection not found")
+		return
+	}
+
+	if err := h.databaseconnection.DeleteConnection(r.Context(), input.ID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, "Connection not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to delete connection: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.releaseConnection(input.ID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SetDefaultConnection picks the connection queried when a request names
+// none.
+func (h *Handler) SetDefaultConnection(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		ProjectID    int `json:"project_id"`
+		ConnectionID int `json:"connection_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, _, err := h.authorizeProject(r.Context(), input.ProjectID, models.RoleAdmin); err != nil {
+		writeAuthorizeError(w, err, "Project not found")
+		return
+	}
+
+	if err := h.databaseconnection.SetDefaultConnection(r.Context(), input.ProjectID, input.ConnectionID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, "Connection not found in project", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to set default connection: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) ListDBConns(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		ProjectID int `json:"project_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	_, role, err := h.authorizeProject(r.Context(), input.ProjectID, models.RoleViewer)
+	if err != nil {
+		writeAuthorizeError(w, err, "Project not found")
+		return
+	}
+
+	projects, err := h.databaseconnection.ListProjectConnections(r.Context(), input.ProjectID, role)
+	if err != nil {
+		http.Error(w, "Failed to list connections: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(projects)
+}
+
+func (h *Handler) GetPoolStats(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		ConnectionID int `json:"connection_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.authorizeConnection(r.Context(), input.ConnectionID, models.RoleViewer); err != nil {
+		writeAuthorizeError(w, err, "Connection not found")
+		return
+	}
+
+	stats, ok := h.connections.Stats(input.ConnectionID)
+	if !ok {
+		http.Error(w, "No open pool for connection", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// TestConnection connects to a stored connection with a short timeout,
+// checks that the role can read the catalog and reports whether it is
+// read-only. The result is also recorded on the connection.
+func (h *Handler) TestConnection(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		ID int `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.authorizeConnection(r.Context(), input.ID, models.RoleEditor); err != nil {
+		writeAuthorizeError(w, err, "Connection not found")
+		return
+	}
+
+	check, err := h.connectionHealth.Check(r.Context(), input.ID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, "Connection not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to test connection: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(check)
+}
+
+// releaseConnection drops everything held in memory for a connection that
+// was changed or removed.
+func (h *Handler) releaseConnection(connectionID int) {
+	if err := h.connections.Close(connectionID); err != nil {
+		log.Printf("Failed to close pool for connection %d: %v", connectionID, err)
+	}
+	h.schemaCache.Invalidate(connectionID)
+}
@@ -5,34 +5,61 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 
+	apikey "github.com/cr34t1ve/hoprun/internal/api_key"
 	"github.com/cr34t1ve/hoprun/internal/auth"
+	connectionhealth "github.com/cr34t1ve/hoprun/internal/connection_health"
+	connectionmanager "github.com/cr34t1ve/hoprun/internal/connection_manager"
+	"github.com/cr34t1ve/hoprun/internal/costguard"
 	"github.com/cr34t1ve/hoprun/internal/database"
 	databaseconnection "github.com/cr34t1ve/hoprun/internal/database_connection"
+	"github.com/cr34t1ve/hoprun/internal/middleware"
 	"github.com/cr34t1ve/hoprun/internal/nlp"
+	projectcontext "github.com/cr34t1ve/hoprun/internal/project_context"
 	"github.com/cr34t1ve/hoprun/internal/query"
+	"github.com/cr34t1ve/hoprun/internal/schemaformat"
+	"github.com/cr34t1ve/hoprun/internal/sso"
+	"github.com/cr34t1ve/hoprun/internal/team"
 	"github.com/cr34t1ve/hoprun/pkg/models"
-	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
 
+// maxRepairAttempts caps a project's repair loop, since every attempt is
+// another model call and another query against the user's database.
+const maxRepairAttempts = 5
+
 type Handler struct {
 	nlpService         nlp.Service
 	queryService       query.Service
 	dbService          database.Service
 	authService        auth.Service
 	databaseconnection databaseconnection.Service
+	schemaCache        database.SchemaCache
+	projectContext     projectcontext.Service
+	confirmations      costguard.Confirmations
+	connections        connectionmanager.Manager
+	connectionHealth   connectionhealth.Checker
+	apiKeys            apikey.Service
+	teams              team.Service
+	sso                sso.Service
 }
 
-func NewHandler(nlpService nlp.Service, queryService query.Service, dbService database.Service, authService auth.Service, databaseconnection databaseconnection.Service) *Handler {
+func NewHandler(nlpService nlp.Service, queryService query.Service, dbService database.Service, authService auth.Service, databaseconnection databaseconnection.Service, schemaCache database.SchemaCache, projectContext projectcontext.Service, confirmations costguard.Confirmations, connections connectionmanager.Manager, connectionHealth connectionhealth.Checker, apiKeys apikey.Service, teams team.Service, sso sso.Service) *Handler {
 	return &Handler{
 		nlpService:         nlpService,
 		queryService:       queryService,
 		dbService:          dbService,
 		authService:        authService,
 		databaseconnection: databaseconnection,
+		schemaCache:        schemaCache,
+		projectContext:     projectContext,
+		confirmations:      confirmations,
+		connections:        connections,
+		connectionHealth:   connectionHealth,
+		apiKeys:            apiKeys,
+		teams:              teams,
+		sso:                sso,
 	}
 }
 
@@ -70,154 +97,402 @@ func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, err := h.authService.LoginUser(r.Context(), input.Email, input.Password)
+	tokens, err := h.authService.LoginUser(r.Context(), input.Email, input.Password)
 	if err != nil {
 		http.Error(w, "Invalid email or password", http.StatusUnauthorized)
 		return
 	}
 
-	json.NewEncoder(w).Encode(map[string]string{"token": token})
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// Refresh exchanges a refresh token for a new access and refresh token. Each
+// refresh token can be used once.
+func (h *Handler) Refresh(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tokens, err := h.authService.RefreshToken(r.Context(), input.RefreshToken)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidToken) {
+			http.Error(w, "Invalid or expired refresh token", http.StatusUnauthorized)
+			return
+		}
+		http.Error(w, "Failed to refresh token: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// Logout revokes the session the request's access token belongs to, along
+// with its refresh token.
+func (h *Handler) Logout(w http.ResponseWriter, r *http.Request) {
+	tokenID, _ := middleware.TokenID(r.Context())
+	if err := h.authService.Logout(r.Context(), tokenID); err != nil {
+		http.Error(w, "Failed to log out: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// LogoutAll revokes every session of the authenticated user, e.g. after a
+// device was lost.
+func (h *Handler) LogoutAll(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.UserID(r.Context())
+	if err := h.authService.LogoutAll(r.Context(), userID); err != nil {
+		http.Error(w, "Failed to log out: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// projectInput holds the settings /project and /updateProject accept.
+type projectInput struct {
+	Name              string `json:"name"`
+	SchemaFormat      string `json:"schema_format"`
+	PromptMaxTables   int    `json:"prompt_max_tables"`
+	PromptTokenBudget int    `json:"prompt_token_budget"`
+
+	LLMProvider    string   `json:"llm_provider"`
+	LLMModel       string   `json:"llm_model"`
+	LLMTemperature *float32 `json:"llm_temperature"`
+	LLMMaxTokens   int      `json:"llm_max_tokens"`
+	RepairAttempts int      `json:"repair_attempts"`
+
+	MaxQueryCost    float64 `json:"max_query_cost"`
+	MaxQueryRows    float64 `json:"max_query_rows"`
+	CostGuardAction string  `json:"cost_guard_action"`
+	CostGuardLimit  int     `json:"cost_guard_limit"`
+}
+
+// projectInputFrom returns project's current settings.
+func projectInputFrom(project *models.Project) projectInput {
+	return projectInput{
+		Name:              project.Name,
+		SchemaFormat:      project.SchemaFormat,
+		PromptMaxTables:   project.PromptMaxTables,
+		PromptTokenBudget: project.PromptTokenBudget,
+		LLMProvider:       project.LLMProvider,
+		LLMModel:          project.LLMModel,
+		LLMTemperature:    project.LLMTemperature,
+		LLMMaxTokens:      project.LLMMaxTokens,
+		RepairAttempts:    project.RepairAttempts,
+		MaxQueryCost:      project.MaxQueryCost,
+		MaxQueryRows:      project.MaxQueryRows,
+		CostGuardAction:   project.CostGuardAction,
+		CostGuardLimit:    project.CostGuardLimit,
+	}
+}
+
+// applyProjectInput checks the settings and copies them onto project.
+func (h *Handler) applyProjectInput(project *models.Project, input projectInput) error {
+	if !schemaformat.IsValid(input.SchemaFormat) {
+		return errors.New("unknown schema format: " + input.SchemaFormat)
+	}
+	if input.SchemaFormat == "" {
+		input.SchemaFormat = schemaformat.FormatText
+	}
+	if input.CostGuardAction == "" {
+		input.CostGuardAction = costguard.ActionRefuse
+	}
+
+	project.Name = input.Name
+	project.SchemaFormat = input.SchemaFormat
+	project.PromptMaxTables = input.PromptMaxTables
+	project.PromptTokenBudget = input.PromptTokenBudget
+	project.LLMProvider = input.LLMProvider
+	project.LLMModel = input.LLMModel
+	project.LLMTemperature = input.LLMTemperature
+	project.LLMMaxTokens = input.LLMMaxTokens
+	project.RepairAttempts = input.RepairAttempts
+	project.MaxQueryCost = input.MaxQueryCost
+	project.MaxQueryRows = input.MaxQueryRows
+	project.CostGuardAction = input.CostGuardAction
+	project.CostGuardLimit = input.CostGuardLimit
+
+	if err := costGuardThresholds(project).Validate(); err != nil {
+		return err
+	}
+	if input.RepairAttempts < 0 || input.RepairAttempts > maxRepairAttempts {
+		return fmt.Errorf("repair_attempts must be between 0 and %d", maxRepairAttempts)
+	}
+	if err := h.nlpService.ValidateSettings(llmSettings(project)); err != nil {
+		return errors.New("invalid LLM settings: " + err.Error())
+	}
+	return nil
 }
 
 func (h *Handler) CreateProject(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		UserID int    `json:"user_id"`
-		Name   string `json:"name"`
+		projectInput
+		OrganizationID *int `json:"organization_id"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	project, err := h.authService.AddProject(r.Context(), input.UserID, input.Name)
+	userID, _ := middleware.UserID(r.Context())
+	project := &models.Project{
+		UserID:         userID,
+		OrganizationID: input.OrganizationID,
+	}
+	if err := h.applyProjectInput(project, input.projectInput); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	project, err := h.authService.AddProject(r.Context(), project)
 	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) || errors.Is(err, auth.ErrForbidden) {
+			writeAuthorizeError(w, err, "Organization not found")
+			return
+		}
 		http.Error(w, "Failed to create project: "+err.Error(), http.StatusInternalServerError)
+		return
 	}
 
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(project)
 }
 
-func (h *Handler) ListUserProjects(w http.ResponseWriter, r *http.Request) {
-	var input struct {
-		UserID int `json:"user_id"`
+// UpdateProject changes the settings sent with the request, which takes the
+// same settings as /project. Settings left out keep their current values.
+func (h *Handler) UpdateProject(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var sent map[string]json.RawMessage
+	if err := json.Unmarshal(body, &sent); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var target struct {
+		ProjectID int `json:"project_id"`
+	}
+	if err := json.Unmarshal(body, &target); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-	checkDecoding(w, r.Body, &input)
 
-	projects, err := h.authService.ListProjects(r.Context(), input.UserID)
+	project, _, err := h.authorizeProject(r.Context(), target.ProjectID, models.RoleAdmin)
 	if err != nil {
-		http.Error(w, "Failed to create project: "+err.Error(), http.StatusInternalServerError)
+		writeAuthorizeError(w, err, "Project not found")
+		return
+	}
+
+	// the request is decoded over the current settings, so only the ones
+	// it names change
+	input := projectInputFrom(project)
+	if err := json.Unmarshal(body, &input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if input.Name == "" {
+		http.Error(w, "name must not be empty", http.StatusBadRequest)
+		return
+	}
+	if err := h.applyProjectInput(project, input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	settings := make([]string, 0, len(sent))
+	for key := range sent {
+		settings = append(settings, key)
+	}
+	project, err = h.dbService.UpdateProject(r.Context(), project, settings)
+	if err != nil {
+		http.Error(w, "Failed to update project: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(project)
+}
+
+// ListUserProjects lists the authenticated user's projects.
+func (h *Handler) ListUserProjects(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.UserID(r.Context())
+	projects, err := h.authService.ListProjects(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "Failed to list projects: "+err.Error(), http.StatusInternalServerError)
+		return
 	}
 
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(projects)
 }
 
-func (h *Handler) AddConnection(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) AddContextDocument(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		ProjectID  int    `json:"projecct_id"`
-		DBName     string `json:"db_name"`
-		DBUser     string `json:"db_user"`
-		DBPassword string `json:"db_password"`
-		DBHost     string `json:"db_host"`
-		DBPort     string `json:"db_port"`
+		ProjectID int    `json:"project_id"`
+		Title     string `json:"title"`
+		Content   string `json:"content"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, _, err := h.authorizeProject(r.Context(), input.ProjectID, models.RoleEditor); err != nil {
+		writeAuthorizeError(w, err, "Project not found")
+		return
 	}
-	checkDecoding(w, r.Body, &input)
 
-	connection, err := h.databaseconnection.AddConnection(r.Context(), input.ProjectID, input.DBName, input.DBUser, input.DBPassword, input.DBHost, input.DBPort)
+	document, err := h.projectContext.AddDocument(r.Context(), input.ProjectID, input.Title, input.Content)
 	if err != nil {
+		if projectcontext.IsLimitError(err) {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
 		if errors.Is(err, gorm.ErrForeignKeyViolated) {
-			http.Error(w, "Failed to add database connection", http.StatusInternalServerError)
+			http.Error(w, "Project not found", http.StatusNotFound)
 			return
 		}
-		http.Error(w, "Failed to add connection: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Failed to add context document: "+err.Error(), http.StatusInternalServerError)
+		return
 	}
 
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(connection)
+	json.NewEncoder(w).Encode(document)
 }
 
-func (h *Handler) ListDBConns(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) ListContextDocuments(w http.ResponseWriter, r *http.Request) {
 	var input struct {
 		ProjectID int `json:"project_id"`
 	}
-	checkDecoding(w, r.Body, &input)
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	projects, err := h.databaseconnection.ListProjectConnections(r.Context(), input.ProjectID)
+	if _, _, err := h.authorizeProject(r.Context(), input.ProjectID, models.RoleViewer); err != nil {
+		writeAuthorizeError(w, err, "Project not found")
+		return
+	}
+
+	documents, err := h.projectContext.ListDocuments(r.Context(), input.ProjectID)
 	if err != nil {
-		http.Error(w, "Failed to create project: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Failed to list context documents: "+err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(projects)
+	json.NewEncoder(w).Encode(documents)
 }
 
-func (h *Handler) HandleQuery(w http.ResponseWriter, r *http.Request) {
-	var input models.QueryInput
+func (h *Handler) UpdateContextDocument(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		ID      int    `json:"id"`
+		Title   string `json:"title"`
+		Content string `json:"content"`
+	}
 	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// get database connection settings for project
-	dbConn, err := h.databaseconnection.GetProjectConnection(r.Context(), input.ProjectID)
+	if err := h.authorizeDocument(r.Context(), input.ID, models.RoleEditor); err != nil {
+		writeAuthorizeError(w, err, "Context document not found")
+		return
+	}
+
+	document, err := h.projectContext.UpdateDocument(r.Context(), input.ID, input.Title, input.Content)
 	if err != nil {
-		http.Error(w, "Failed to get database schema: "+err.Error(), http.StatusInternalServerError)
+		if projectcontext.IsLimitError(err) {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, "Context document not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to update context document: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// TODO: decrpyt db connection password
+	json.NewEncoder(w).Encode(document)
+}
+
+func (h *Handler) DeleteContextDocument(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		ID int `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	// get db schema from db call
-	// NB: Ideally this would be saved in our db but here lies the case where the database structure could
-	// be changed by the user during calls. To cut out this entire process of getting the schema on each call,
-	// there would be have to be a notifier from either the backend, migration tool or database (preferably)
-	// anytime there is a change in the schema so a job is started to update the schema on our end and proceed
-	// accordingly
+	if err := h.authorizeDocument(r.Context(), input.ID, models.RoleEditor); err != nil {
+		writeAuthorizeError(w, err, "Context document not found")
+		return
+	}
 
-	// start db connection
-	dsn := fmt.Sprintf("host=%s user=%s dbname=%s password=%s sslmode=disable", dbConn.DBHost, dbConn.DBUser, dbConn.DBName, dbConn.DBPassword)
-	log.Printf("db connection gotten: %s", dsn)
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{TranslateError: true})
-	if err != nil {
-		http.Error(w, "Failed to connect to user PostgreSQL connection"+err.Error(), http.StatusInternalServerError)
+	if err := h.projectContext.DeleteDocument(r.Context(), input.ID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, "Context document not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to delete context document: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// TODO: work on this to be able to close a specific connection
-	// https://stackoverflow.com/a/74299379
-	// defer func () {
-	// 	dbInstance, _ := db.DB()
-	// 	_ = dbInstance.Close()
-	// }()
+	w.WriteHeader(http.StatusNoContent)
+}
 
-	userDBService := database.NewService(db)
-	userQueryService := query.NewService(userDBService)
-	dbSchema, err := userDBService.GetDatabaseSchema()
-	if err != nil {
-		http.Error(w, "Failed to get database schema: "+err.Error(), http.StatusInternalServerError)
+func (h *Handler) ListContextDocumentVersions(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		ID int `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// pass schema to Natural language converter
-	sqlQuery, err := h.nlpService.NaturalLanguageToSQL(input.Query, dbSchema)
+	if err := h.authorizeDocumentHistory(r.Context(), input.ID, models.RoleViewer); err != nil {
+		writeAuthorizeError(w, err, "Context document not found")
+		return
+	}
+
+	versions, err := h.projectContext.ListDocumentVersions(r.Context(), input.ID)
 	if err != nil {
-		http.Error(w, "Failed to generate SQL query"+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Failed to list context document versions: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	log.Printf("Generated SQL query: %s", sqlQuery)
+	json.NewEncoder(w).Encode(versions)
+}
 
-	results, err := userQueryService.ExecuteQuery(sqlQuery)
-	if err != nil {
-		http.Error(w, "Failed to execute query"+err.Error(), http.StatusInternalServerError)
+func (h *Handler) GetSchemaCacheStats(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		ConnectionID int `json:"connection_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	formattedResults := h.queryService.FormatResults(results, input.Visualization)
+	if err := h.authorizeConnection(r.Context(), input.ConnectionID, models.RoleViewer); err != nil {
+		writeAuthorizeError(w, err, "Connection not found")
+		return
+	}
+
+	stats, ok := h.schemaCache.Stats(input.ConnectionID)
+	if !ok {
+		http.Error(w, "No cached schema for connection", http.StatusNotFound)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(formattedResults)
+	json.NewEncoder(w).Encode(stats)
 }
 
 func checkDuplicateKeyError(err error, w http.ResponseWriter, message string) {
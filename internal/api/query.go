@@ -0,0 +1,286 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/cr34t1ve/hoprun/internal/costguard"
+	"github.com/cr34t1ve/hoprun/internal/database"
+	databaseconnection "github.com/cr34t1ve/hoprun/internal/database_connection"
+	"github.com/cr34t1ve/hoprun/internal/nlp"
+	"github.com/cr34t1ve/hoprun/internal/query"
+	"github.com/cr34t1ve/hoprun/internal/schemaformat"
+	"github.com/cr34t1ve/hoprun/internal/schemaprune"
+	"github.com/cr34t1ve/hoprun/internal/sqlguard"
+	"github.com/cr34t1ve/hoprun/pkg/models"
+	"gorm.io/gorm"
+)
+
+func (h *Handler) HandleQuery(w http.ResponseWriter, r *http.Request) {
+	var input models.QueryInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	project, _, err := h.authorizeProject(r.Context(), input.ProjectID, models.RoleViewer)
+	if err != nil {
+		writeAuthorizeError(w, err, "Project not found")
+		return
+	}
+
+	// a query held back by the cost guard runs exactly as it was shown to
+	// the user, on the connection it was generated for
+	var confirmedSQL string
+	if input.ConfirmationToken != "" {
+		connectionID, sqlQuery, ok := h.confirmations.Take(input.ConfirmationToken, input.ProjectID, caller(r.Context()))
+		if !ok {
+			http.Error(w, "Unknown or expired confirmation token", http.StatusNotFound)
+			return
+		}
+		input.ConnectionID, input.Connection = connectionID, ""
+		confirmedSQL = sqlQuery
+	}
+
+	dbConn, err := h.databaseconnection.GetProjectConnection(r.Context(), project, input.ConnectionID, input.Connection)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, "Connection not found in project", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, databaseconnection.ErrNoDefaultConnection) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "Failed to get database connection: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// pools are kept per connection and reused across requests
+	db, release, err := h.connections.Get(dbConn)
+	if err != nil {
+		http.Error(w, "Failed to connect to database: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer release()
+
+	userDBService := database.NewService(db)
+	userQueryService := query.NewService(userDBService)
+
+	queryOptions := database.QueryOptions{
+		StatementTimeout: time.Duration(dbConn.StatementTimeoutMs) * time.Millisecond,
+		MaxRows:          dbConn.MaxRows,
+	}
+
+	if confirmedSQL != "" {
+		response := models.QueryResponse{
+			ConnectionID: dbConn.ID,
+			SQL:          confirmedSQL,
+			Attempts:     []models.QueryAttempt{{SQL: confirmedSQL}},
+		}
+		results, err := h.executeQuery(r.Context(), userQueryService, confirmedSQL, queryOptions, costguard.Thresholds{}, &response)
+		if err != nil {
+			response.Attempts[0].Error = query.DescribeError(err)
+			response.Error = "Failed to execute query: " + err.Error()
+			writeQueryResponse(w, http.StatusUnprocessableEntity, response)
+			return
+		}
+		response.Results = h.queryService.FormatResults(results, input.Visualization)
+		writeQueryResponse(w, http.StatusOK, response)
+		return
+	}
+
+	// the schema is only re-introspected when the database's schema fingerprint
+	// has changed since the last call for this connection
+	schema, err := h.schemaCache.GetSchema(dbConn.ID, userDBService, database.SchemaOptions{
+		IncludeSchemas: dbConn.IncludeSchemas,
+		ExcludeSchemas: dbConn.ExcludeSchemas,
+		SampleValues:   dbConn.SampleColumnValues,
+	})
+	if err != nil {
+		http.Error(w, "Failed to get database schema: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// only the tables most relevant to the question are sent to the model
+	schema = schemaprune.Prune(schema, input.Query, schemaprune.Options{
+		MaxTables:   project.PromptMaxTables,
+		TokenBudget: project.PromptTokenBudget,
+		Format:      project.SchemaFormat,
+	})
+
+	dbSchema, err := schemaformat.Render(schema, project.SchemaFormat)
+	if err != nil {
+		http.Error(w, "Failed to render database schema: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	businessContext, contextRefs, err := h.projectContext.GetPromptContext(r.Context(), input.ProjectID)
+	if err != nil {
+		http.Error(w, "Failed to get project context: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// pass schema to Natural language converter
+	sqlQuery, err := h.nlpService.NaturalLanguageToSQL(r.Context(), input.Query, userDBService.Dialect(), dbSchema, businessContext, llmSettings(project))
+	if err != nil {
+		http.Error(w, "Failed to generate SQL query"+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Generated SQL query: %s (context: %v)", sqlQuery, contextRefs)
+
+	response := models.QueryResponse{
+		ConnectionID: dbConn.ID,
+		Tables:       schemaprune.TableNames(schema),
+		Context:      contextRefs,
+	}
+	thresholds := costGuardThresholds(project)
+
+	// failing queries are sent back to the model with the database error
+	// until one succeeds or the project's repair attempts are used up
+	var results []map[string]interface{}
+	for {
+		response.SQL = sqlQuery
+		results, err = h.executeQuery(r.Context(), userQueryService, sqlQuery, queryOptions, thresholds, &response)
+		if err == nil {
+			response.Attempts = append(response.Attempts, models.QueryAttempt{SQL: sqlQuery})
+			break
+		}
+
+		response.Attempts = append(response.Attempts, models.QueryAttempt{SQL: sqlQuery, Error: query.DescribeError(err)})
+
+		// blocked statements are not repaired: a question that produced a
+		// write should not be coaxed into a different query
+		var guardErr *sqlguard.Error
+		if errors.As(err, &guardErr) {
+			response.Error = guardErr.Error()
+			writeQueryResponse(w, http.StatusForbidden, response)
+			return
+		}
+
+		var costErr *costguard.Error
+		if errors.As(err, &costErr) {
+			response.Error = costErr.Error()
+			response.Plan = costErr.Plan
+			if !costErr.NeedsConfirmation {
+				writeQueryResponse(w, http.StatusUnprocessableEntity, response)
+				return
+			}
+
+			token, err := h.confirmations.Add(input.ProjectID, dbConn.ID, caller(r.Context()), sqlQuery)
+			if err != nil {
+				http.Error(w, "Failed to hold query for confirmation: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			response.ConfirmationToken = token
+			writeQueryResponse(w, http.StatusPreconditionRequired, response)
+			return
+		}
+
+		// the client has gone away, so there is nobody to repair the query for
+		if r.Context().Err() != nil {
+			return
+		}
+
+		if len(response.Attempts) > project.RepairAttempts {
+			response.Error = "Failed to execute query: " + err.Error()
+			writeQueryResponse(w, http.StatusUnprocessableEntity, response)
+			return
+		}
+
+		sqlQuery, err = h.nlpService.RepairSQL(r.Context(), input.Query, userDBService.Dialect(), dbSchema, businessContext, response.Attempts, llmSettings(project))
+		if err != nil {
+			http.Error(w, "Failed to repair SQL query: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Repaired SQL query (attempt %d): %s", len(response.Attempts)+1, sqlQuery)
+	}
+
+	response.Results = h.queryService.FormatResults(results, input.Visualization)
+	writeQueryResponse(w, http.StatusOK, response)
+}
+
+// executeQuery plans sqlQuery, applies the cost guard and runs it, recording
+// the plan summary and any row limit it applied on response. Planning errors
+// are returned like execution errors, so a query that references a missing
+// column fails before it runs. The guard stops a query with a
+// *costguard.Error.
+func (h *Handler) executeQuery(ctx context.Context, queryService query.Service, sqlQuery string, opts database.QueryOptions, thresholds costguard.Thresholds, response *models.QueryResponse) ([]map[string]interface{}, error) {
+	plan, err := queryService.ExplainQuery(ctx, sqlQuery, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if reason, exceeded := costguard.Exceeded(plan, thresholds); exceeded {
+		switch thresholds.Action {
+		case costguard.ActionLimit:
+			// the limit only tightens the connection's own row cap
+			opts.MaxRows = minRowCap(opts.MaxRows, thresholds.Limit)
+			plan, err = queryService.ExplainQuery(ctx, sqlQuery, opts)
+			if err != nil {
+				return nil, err
+			}
+			if reason, exceeded := costguard.ExceededWithLimit(plan, thresholds); exceeded {
+				return nil, &costguard.Error{Reason: reason + " even when limited to " + fmt.Sprint(opts.MaxRows) + " rows", Plan: plan}
+			}
+			response.LimitApplied = opts.MaxRows
+		case costguard.ActionConfirm:
+			return nil, &costguard.Error{Reason: reason, Plan: plan, NeedsConfirmation: true}
+		default:
+			return nil, &costguard.Error{Reason: reason, Plan: plan}
+		}
+	}
+
+	// the full plan is only returned when the user has to review it
+	plan.Plan = nil
+	response.Plan = plan
+
+	results, truncated, err := queryService.ExecuteQuery(ctx, sqlQuery, opts)
+	if err != nil {
+		return nil, err
+	}
+	response.Truncated = truncated
+	return results, nil
+}
+
+// minRowCap returns the smaller of two row caps, where 0 means no cap.
+func minRowCap(a, b int) int {
+	if a <= 0 || (b > 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+func llmSettings(project *models.Project) nlp.Settings {
+	return nlp.Settings{
+		Provider:    project.LLMProvider,
+		Model:       project.LLMModel,
+		Temperature: project.LLMTemperature,
+		MaxTokens:   project.LLMMaxTokens,
+	}
+}
+
+func costGuardThresholds(project *models.Project) costguard.Thresholds {
+	thresholds := costguard.Thresholds{
+		MaxCost: project.MaxQueryCost,
+		MaxRows: project.MaxQueryRows,
+		Action:  project.CostGuardAction,
+		Limit:   project.CostGuardLimit,
+	}
+	if thresholds.Limit <= 0 {
+		thresholds.Limit = costguard.DefaultLimit
+	}
+	return thresholds
+}
+
+func writeQueryResponse(w http.ResponseWriter, status int, response models.QueryResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/cr34t1ve/hoprun/internal/auth"
+	"github.com/cr34t1ve/hoprun/internal/sso"
+)
+
+// oidcStateCookie ties a sign-in to the browser that started it, so a callback
+// URL cannot be used to sign someone else in
+const oidcStateCookie = "hoprun_oidc_state"
+
+// OIDCLogin sends the browser to the identity provider. An optional
+// login_hint query parameter is passed on to it.
+func (h *Handler) OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	state, url, err := h.sso.AuthCodeURL(r.URL.Query().Get("login_hint"))
+	if err != nil {
+		http.Error(w, "Failed to start sign-in: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/oidc",
+		MaxAge:   600,
+		HttpOnly: true,
+		Secure:   h.sso.SecureCookie(),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+// OIDCCallback is where the identity provider sends the browser back to. It
+// returns the same tokens as /login.
+func (h *Handler) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if e := query.Get("error"); e != "" {
+		http.Error(w, "Sign-in failed: "+e+" "+query.Get("error_description"), http.StatusUnauthorized)
+		return
+	}
+
+	state := query.Get("state")
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || state == "" || cookie.Value != state {
+		http.Error(w, "Sign-in was not started from this browser", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: "/oidc", MaxAge: -1, Secure: h.sso.SecureCookie()})
+
+	identity, err := h.sso.Exchange(r.Context(), state, query.Get("code"))
+	if err != nil {
+		if errors.Is(err, sso.ErrInvalidLogin) {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		http.Error(w, "Failed to sign in: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	tokens, err := h.authService.LoginOIDC(r.Context(), identity)
+	if err != nil {
+		if errors.Is(err, auth.ErrUnverifiedEmail) || errors.Is(err, auth.ErrAccountLinked) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		http.Error(w, "Failed to sign in: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(tokens)
+}
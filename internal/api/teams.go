@@ -0,0 +1,433 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"gorm.io/gorm"
+
+	"github.com/cr34t1ve/hoprun/internal/middleware"
+	"github.com/cr34t1ve/hoprun/internal/team"
+	"github.com/cr34t1ve/hoprun/pkg/models"
+)
+
+// outranks reports whether a project member with role may give or take away
+// target: only roles below their own, except that owners manage everyone.
+func outranks(role, target string) bool {
+	return role == models.RoleOwner || (models.RoleAtLeast(role, target) && !models.RoleAtLeast(target, role))
+}
+
+// orgOutranks is outranks for organization roles.
+func orgOutranks(role, target string) bool {
+	return role == models.OrgRoleOwner || (models.OrgRoleAtLeast(role, target) && !models.OrgRoleAtLeast(target, role))
+}
+
+type memberInput struct {
+	OrganizationID int    `json:"organization_id"`
+	ProjectID      int    `json:"project_id"`
+	UserID         int    `json:"user_id"`
+	Role           string `json:"role"`
+}
+
+func (h *Handler) CreateOrganization(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if input.Name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+
+	userID, _ := middleware.UserID(r.Context())
+	organization, err := h.teams.CreateOrganization(r.Context(), input.Name, userID)
+	if err != nil {
+		http.Error(w, "Failed to create organization: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(organization)
+}
+
+// ListOrganizations lists the organizations the authenticated user belongs
+// to.
+func (h *Handler) ListOrganizations(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.UserID(r.Context())
+	organizations, err := h.teams.ListOrganizations(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "Failed to list organizations: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(organizations)
+}
+
+func (h *Handler) ListOrganizationMembers(w http.ResponseWriter, r *http.Request) {
+	var input memberInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err := h.authorizeOrganization(r.Context(), input.OrganizationID, models.OrgRoleMember); err != nil {
+		writeAuthorizeError(w, err, "Organization not found")
+		return
+	}
+
+	members, err := h.teams.ListOrganizationMembers(r.Context(), input.OrganizationID)
+	if err != nil {
+		http.Error(w, "Failed to list organization members: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(members)
+}
+
+func (h *Handler) UpdateOrganizationMember(w http.ResponseWriter, r *http.Request) {
+	var input memberInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !models.IsValidOrgRole(input.Role) {
+		http.Error(w, "Unknown organization role: "+input.Role, http.StatusBadRequest)
+		return
+	}
+	role, err := h.authorizeOrganization(r.Context(), input.OrganizationID, models.OrgRoleAdmin)
+	if err != nil {
+		writeAuthorizeError(w, err, "Organization not found")
+		return
+	}
+	current, err := h.teams.OrganizationRole(r.Context(), input.UserID, input.OrganizationID)
+	if err != nil {
+		http.Error(w, "Failed to get member: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if current == "" {
+		http.Error(w, "Member not found", http.StatusNotFound)
+		return
+	}
+	if !orgOutranks(role, current) || !orgOutranks(role, input.Role) {
+		http.Error(w, "Only owners can change the role of admins or make admins", http.StatusForbidden)
+		return
+	}
+
+	if err := h.teams.SetOrganizationRole(r.Context(), input.OrganizationID, input.UserID, input.Role); err != nil {
+		writeMemberError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RemoveOrganizationMember removes a member from an organization. Members can
+// always remove themselves.
+func (h *Handler) RemoveOrganizationMember(w http.ResponseWriter, r *http.Request) {
+	var input memberInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	userID, _ := middleware.UserID(r.Context())
+	if input.UserID != userID {
+		role, err := h.authorizeOrganization(r.Context(), input.OrganizationID, models.OrgRoleAdmin)
+		if err != nil {
+			writeAuthorizeError(w, err, "Organization not found")
+			return
+		}
+		current, err := h.teams.OrganizationRole(r.Context(), input.UserID, input.OrganizationID)
+		if err != nil {
+			http.Error(w, "Failed to get member: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if current != "" && !orgOutranks(role, current) {
+			http.Error(w, "Only owners can remove admins", http.StatusForbidden)
+			return
+		}
+	}
+
+	if err := h.teams.RemoveOrganizationMember(r.Context(), input.OrganizationID, input.UserID); err != nil {
+		writeMemberError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) ListProjectMembers(w http.ResponseWriter, r *http.Request) {
+	var input memberInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, _, err := h.authorizeProject(r.Context(), input.ProjectID, models.RoleViewer); err != nil {
+		writeAuthorizeError(w, err, "Project not found")
+		return
+	}
+
+	members, err := h.teams.ListProjectMembers(r.Context(), input.ProjectID)
+	if err != nil {
+		http.Error(w, "Failed to list project members: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(members)
+}
+
+func (h *Handler) UpdateProjectMember(w http.ResponseWriter, r *http.Request) {
+	var input memberInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !models.IsValidRole(input.Role) {
+		http.Error(w, "Unknown project role: "+input.Role, http.StatusBadRequest)
+		return
+	}
+	_, role, err := h.authorizeProject(r.Context(), input.ProjectID, models.RoleAdmin)
+	if err != nil {
+		writeAuthorizeError(w, err, "Project not found")
+		return
+	}
+	current, err := h.teams.MemberRole(r.Context(), input.ProjectID, input.UserID)
+	if err != nil {
+		http.Error(w, "Failed to get member: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if current == "" {
+		http.Error(w, "Member not found", http.StatusNotFound)
+		return
+	}
+	if !outranks(role, current) || !outranks(role, input.Role) {
+		http.Error(w, "Only the owner can change the role of admins or make admins", http.StatusForbidden)
+		return
+	}
+
+	if err := h.teams.SetProjectRole(r.Context(), input.ProjectID, input.UserID, input.Role); err != nil {
+		writeMemberError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RemoveProjectMember removes a member from a project. Members can always
+// remove themselves. Access through the project's organization is not
+// affected.
+func (h *Handler) RemoveProjectMember(w http.ResponseWriter, r *http.Request) {
+	var input memberInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	userID, _ := middleware.UserID(r.Context())
+	if input.UserID != userID {
+		_, role, err := h.authorizeProject(r.Context(), input.ProjectID, models.RoleAdmin)
+		if err != nil {
+			writeAuthorizeError(w, err, "Project not found")
+			return
+		}
+		current, err := h.teams.MemberRole(r.Context(), input.ProjectID, input.UserID)
+		if err != nil {
+			http.Error(w, "Failed to get member: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if current != "" && !outranks(role, current) {
+			http.Error(w, "Only the owner can remove admins", http.StatusForbidden)
+			return
+		}
+	}
+
+	if err := h.teams.RemoveProjectMember(r.Context(), input.ProjectID, input.UserID); err != nil {
+		writeMemberError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type invitationInput struct {
+	ID             int    `json:"id"`
+	OrganizationID *int   `json:"organization_id"`
+	ProjectID      *int   `json:"project_id"`
+	Email          string `json:"email"`
+	Role           string `json:"role"`
+}
+
+// authorizeInvitations checks that the user may manage invitations to the
+// organization or project named by input, and may hand out role there. An
+// empty role skips that check.
+func (h *Handler) authorizeInvitations(w http.ResponseWriter, r *http.Request, input *invitationInput, role string) bool {
+	if (input.OrganizationID == nil) == (input.ProjectID == nil) {
+		http.Error(w, "Set exactly one of organization_id and project_id", http.StatusBadRequest)
+		return false
+	}
+
+	if input.OrganizationID != nil {
+		if role != "" && !models.IsValidOrgRole(role) {
+			http.Error(w, "Unknown organization role: "+role, http.StatusBadRequest)
+			return false
+		}
+		callerRole, err := h.authorizeOrganization(r.Context(), *input.OrganizationID, models.OrgRoleAdmin)
+		if err != nil {
+			writeAuthorizeError(w, err, "Organization not found")
+			return false
+		}
+		if role != "" && !orgOutranks(callerRole, role) {
+			http.Error(w, "Only owners can invite admins and owners", http.StatusForbidden)
+			return false
+		}
+		return true
+	}
+
+	if role != "" && !models.IsValidRole(role) {
+		http.Error(w, "Unknown project role: "+role, http.StatusBadRequest)
+		return false
+	}
+	_, callerRole, err := h.authorizeProject(r.Context(), *input.ProjectID, models.RoleAdmin)
+	if err != nil {
+		writeAuthorizeError(w, err, "Project not found")
+		return false
+	}
+	if role != "" && !outranks(callerRole, role) {
+		http.Error(w, "Only the owner can invite admins", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// Invite creates an invitation for an email address to join an organization
+// or a project, as a viewer or member unless a role is given. HopRun does
+// not send email: the invitation token is only returned in this response,
+// for the inviter to pass on.
+func (h *Handler) Invite(w http.ResponseWriter, r *http.Request) {
+	var input invitationInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if input.Email == "" {
+		http.Error(w, "email is required", http.StatusBadRequest)
+		return
+	}
+	// an empty role would be stored on the membership and grant nothing
+	if input.Role == "" {
+		input.Role = models.RoleViewer
+		if input.OrganizationID != nil {
+			input.Role = models.OrgRoleMember
+		}
+	}
+	if !h.authorizeInvitations(w, r, &input, input.Role) {
+		return
+	}
+
+	userID, _ := middleware.UserID(r.Context())
+	invitation, token, err := h.teams.CreateInvitation(r.Context(), &models.Invitation{
+		OrganizationID: input.OrganizationID,
+		ProjectID:      input.ProjectID,
+		Email:          input.Email,
+		Role:           input.Role,
+		InvitedBy:      userID,
+	})
+	if err != nil {
+		http.Error(w, "Failed to create invitation: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(struct {
+		*models.Invitation
+		Token string `json:"token"`
+	}{invitation, token})
+}
+
+// ListInvitations lists the pending invitations to an organization or a
+// project.
+func (h *Handler) ListInvitations(w http.ResponseWriter, r *http.Request) {
+	var input invitationInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !h.authorizeInvitations(w, r, &input, "") {
+		return
+	}
+
+	invitations, err := h.teams.ListInvitations(r.Context(), input.OrganizationID, input.ProjectID)
+	if err != nil {
+		http.Error(w, "Failed to list invitations: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(invitations)
+}
+
+func (h *Handler) RevokeInvitation(w http.ResponseWriter, r *http.Request) {
+	var input invitationInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	invitation, err := h.teams.GetInvitation(r.Context(), input.ID)
+	if err != nil {
+		writeAuthorizeError(w, err, "Invitation not found")
+		return
+	}
+	target := invitationInput{OrganizationID: invitation.OrganizationID, ProjectID: invitation.ProjectID}
+	if !h.authorizeInvitations(w, r, &target, "") {
+		return
+	}
+
+	if err := h.teams.DeleteInvitation(r.Context(), input.ID); err != nil {
+		writeAuthorizeError(w, err, "Invitation not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AcceptInvitation adds the authenticated user to the organization or project
+// of an invitation sent to their email address.
+func (h *Handler) AcceptInvitation(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	userID, _ := middleware.UserID(r.Context())
+	invitation, err := h.teams.AcceptInvitation(r.Context(), input.Token, userID)
+	if err != nil {
+		if errors.Is(err, team.ErrInvalidInvitation) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, team.ErrInvitationEmail) || errors.Is(err, team.ErrNotOrganizationMember) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		http.Error(w, "Failed to accept invitation: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(invitation)
+}
+
+func writeMemberError(w http.ResponseWriter, err error) {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, "Member not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, team.ErrLastOwner) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	http.Error(w, "Failed to update member: "+err.Error(), http.StatusInternalServerError)
+}
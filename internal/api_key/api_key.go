@@ -0,0 +1,175 @@
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/cr34t1ve/hoprun/pkg/models"
+)
+
+// KeyPrefix starts every API key, which is how they are told apart from
+// JWTs in the Authorization header.
+const KeyPrefix = "hopr_"
+
+// displayPrefixLength is how much of a key is stored in the clear
+const displayPrefixLength = len(KeyPrefix) + 8
+
+// lastUsedInterval bounds how often a key's last_used_at is written, so a busy
+// key does not cost a write per request
+const lastUsedInterval = time.Minute
+
+var (
+	// ErrInvalidKey is returned for keys that are unknown or expired.
+	ErrInvalidKey = errors.New("invalid API key")
+	ErrNoScopes   = errors.New("an API key needs at least one scope")
+)
+
+var validScopes = map[string]bool{
+	models.APIKeyScopeQuery:        true,
+	models.APIKeyScopeReadMetadata: true,
+	models.APIKeyScopeAdmin:        true,
+}
+
+type Service interface {
+	// CreateKey stores a new key for key.ProjectID and returns it along with
+	// the secret, which is not stored and cannot be shown again.
+	CreateKey(ctx context.Context, key *models.APIKey) (*models.APIKey, string, error)
+	GetKey(ctx context.Context, keyID int) (*models.APIKey, error)
+	ListKeys(ctx context.Context, projectID int) (*[]models.APIKey, error)
+	// UpdateKey changes a key's name, scopes and expiry.
+	UpdateKey(ctx context.Context, key *models.APIKey) (*models.APIKey, error)
+	DeleteKey(ctx context.Context, keyID int) error
+	// ValidateKey returns the unexpired key matching secret and records that
+	// it was used.
+	ValidateKey(ctx context.Context, secret string) (*models.APIKey, error)
+}
+
+type service struct {
+	db *gorm.DB
+}
+
+func NewService(db *gorm.DB) Service {
+	return &service{db: db}
+}
+
+// ValidateScopes checks that scopes is non-empty and only names known
+// scopes.
+func ValidateScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return ErrNoScopes
+	}
+	for _, scope := range scopes {
+		if !validScopes[scope] {
+			return fmt.Errorf("unknown API key scope %q", scope)
+		}
+	}
+	return nil
+}
+
+func (s *service) CreateKey(ctx context.Context, key *models.APIKey) (*models.APIKey, string, error) {
+	if err := ValidateScopes(key.Scopes); err != nil {
+		return nil, "", err
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, "", err
+	}
+	secret := KeyPrefix + base64.RawURLEncoding.EncodeToString(b)
+
+	key.Prefix = secret[:displayPrefixLength]
+	key.KeyHash = hashKey(secret)
+	key.LastUsedAt = nil
+	if err := s.db.WithContext(ctx).Create(key).Error; err != nil {
+		return nil, "", err
+	}
+	return key, secret, nil
+}
+
+func (s *service) GetKey(ctx context.Context, keyID int) (*models.APIKey, error) {
+	var key models.APIKey
+	if err := s.db.WithContext(ctx).First(&key, keyID).Error; err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (s *service) ListKeys(ctx context.Context, projectID int) (*[]models.APIKey, error) {
+	var keys []models.APIKey
+	results := s.db.WithContext(ctx).Where("project_id = ?", projectID).Order("id").Find(&keys)
+	if results.Error != nil {
+		return nil, results.Error
+	}
+	return &keys, nil
+}
+
+func (s *service) UpdateKey(ctx context.Context, key *models.APIKey) (*models.APIKey, error) {
+	if err := ValidateScopes(key.Scopes); err != nil {
+		return nil, err
+	}
+
+	existing, err := s.GetKey(ctx, key.ID)
+	if err != nil {
+		return nil, err
+	}
+	existing.Name = key.Name
+	existing.Scopes = key.Scopes
+	existing.ExpiresAt = key.ExpiresAt
+	if err := s.db.WithContext(ctx).Select("name", "scopes", "expires_at").Updates(existing).Error; err != nil {
+		return nil, err
+	}
+	return existing, nil
+}
+
+func (s *service) DeleteKey(ctx context.Context, keyID int) error {
+	result := s.db.WithContext(ctx).Delete(&models.APIKey{}, keyID)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (s *service) ValidateKey(ctx context.Context, secret string) (*models.APIKey, error) {
+	if !strings.HasPrefix(secret, KeyPrefix) {
+		return nil, ErrInvalidKey
+	}
+
+	now := time.Now()
+	var key models.APIKey
+	err := s.db.WithContext(ctx).
+		Where("key_hash = ? AND (expires_at IS NULL OR expires_at > ?)", hashKey(secret), now).
+		First(&key).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidKey
+		}
+		return nil, err
+	}
+
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) > lastUsedInterval {
+		err := s.db.WithContext(ctx).Model(&key).UpdateColumn("last_used_at", now).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &key, nil
+}
+
+// hashKey is what is stored and looked up. Keys carry 256 random bits, so
+// unlike passwords they need no salt or slow hash.
+func hashKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
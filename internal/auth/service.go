@@ -3,27 +3,85 @@ package auth
 import (
 	"context"
 	"errors"
+	"fmt"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 
 	"github.com/cr34t1ve/hoprun/internal/database"
+	"github.com/cr34t1ve/hoprun/internal/sso"
+	"github.com/cr34t1ve/hoprun/internal/team"
 	"github.com/cr34t1ve/hoprun/pkg/models"
 )
 
 type Service interface {
 	RegisterUser(ctx context.Context, email, password string) (*models.User, error)
-	LoginUser(ctx context.Context, email, password string) (string, error)
-	ValidateToken(tokenString string) (*Claims, error)
-	AddProject(ctx context.Context, userID int, name string) (*models.Project, error)
+	// LoginUser starts a session and returns its first access and refresh
+	// tokens.
+	LoginUser(ctx context.Context, email, password string) (*Tokens, error)
+	// LoginOIDC starts a session for the user linked to a single sign-on
+	// identity. An identity seen for the first time is linked to the user
+	// with its email address, which loses its password, or to a new user,
+	// provided the provider has verified the address.
+	LoginOIDC(ctx context.Context, identity *sso.Identity) (*Tokens, error)
+	// RefreshToken exchanges a refresh token for a new pair. The old refresh
+	// token and the session's previous access token stop working, and using
+	// the old refresh token again revokes the session. Refreshing does not
+	// extend the session past RefreshTokenTTL from sign-in.
+	RefreshToken(ctx context.Context, refreshToken string) (*Tokens, error)
+	// ValidateToken checks an access token's signature, expiry, issuer and
+	// audience, and that its session has not been revoked or refreshed.
+	ValidateToken(ctx context.Context, tokenString string) (*Claims, error)
+	// Logout revokes the session of the access token with the given jti.
+	Logout(ctx context.Context, tokenID string) error
+	// LogoutAll revokes every session of a user.
+	LogoutAll(ctx context.Context, userID int) error
+	// AddProject creates a project owned by project.UserID. Projects can only
+	// be added to organizations the owner is an owner or admin of.
+	AddProject(ctx context.Context, project *models.Project) (*models.Project, error)
+	// AuthorizeProject returns a project and the user's role in it, provided
+	// the role is at least minRole. Projects the user cannot reach are
+	// reported as gorm.ErrRecordNotFound, like missing ones, and too low a
+	// role as ErrForbidden.
+	AuthorizeProject(ctx context.Context, userID, projectID int, minRole string) (*models.Project, string, error)
+	// ListProjects returns the projects the user owns, is a member of, or
+	// reaches through an organization.
 	ListProjects(ctx context.Context, userID int) (*[]models.Project, error)
 }
 
+const (
+	AccessTokenTTL  = 15 * time.Minute
+	RefreshTokenTTL = 30 * 24 * time.Hour
+
+	defaultIssuer   = "hoprun"
+	defaultAudience = "hoprun-api"
+)
+
+var (
+	// ErrInvalidToken is returned for access and refresh tokens that are
+	// malformed, expired or revoked.
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrForbidden is returned when the user's role does not allow an
+	// action.
+	ErrForbidden = errors.New("your role does not allow this")
+	// ErrUnverifiedEmail is returned when single sign-on would link or
+	// create a user by an email address the provider has not verified.
+	ErrUnverifiedEmail = errors.New("the identity provider has not verified this email address")
+	// ErrAccountLinked is returned when a user is already linked to a
+	// different single sign-on account.
+	ErrAccountLinked = errors.New("this email address is linked to a different single sign-on account")
+)
+
 type service struct {
 	dbService database.Service
+	teams     team.Service
 	jwtKey    []byte
+	issuer    string
+	audience  string
 }
 
 type Claims struct {
@@ -31,10 +89,23 @@ type Claims struct {
 	jwt.RegisteredClaims
 }
 
-func NewService(dbService database.Service) Service {
+// Tokens is the pair returned on login and refresh. ExpiresAt is when the
+// access token expires.
+type Tokens struct {
+	AccessToken  string    `json:"token"`
+	RefreshToken string    `json:"refresh_token"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// NewService reads the signing key from JWT_SECRET and the issuer and
+// audience of access tokens from JWT_ISSUER and JWT_AUDIENCE.
+func NewService(dbService database.Service, teams team.Service) Service {
 	return &service{
 		dbService: dbService,
+		teams:     teams,
 		jwtKey:    []byte(os.Getenv("JWT_SECRET")),
+		issuer:    envOr("JWT_ISSUER", defaultIssuer),
+		audience:  envOr("JWT_AUDIENCE", defaultAudience),
 	}
 }
 
@@ -52,63 +123,192 @@ func (s *service) RegisterUser(ctx context.Context, email, password string) (*mo
 	return user, nil
 }
 
-func (s *service) LoginUser(ctx context.Context, email, password string) (string, error) {
+func (s *service) LoginUser(ctx context.Context, email, password string) (*Tokens, error) {
 	user, err := s.dbService.GetUserByEmail(ctx, email)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
-		return "", errors.New("invalid password")
+		return nil, errors.New("invalid password")
+	}
+
+	return s.startSession(ctx, user.ID)
+}
+
+func (s *service) LoginOIDC(ctx context.Context, identity *sso.Identity) (*Tokens, error) {
+	user, err := s.dbService.GetUserByOIDCSubject(ctx, identity.Issuer, identity.Subject)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// an unverified address could claim someone else's account or
+		// invitations
+		if !identity.EmailVerified {
+			return nil, ErrUnverifiedEmail
+		}
+		user, err = s.dbService.LinkOIDCUser(ctx, identity.Issuer, identity.Subject, identity.Email)
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			// a concurrent first sign-in may have linked this identity
+			user, err = s.dbService.GetUserByOIDCSubject(ctx, identity.Issuer, identity.Subject)
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, ErrAccountLinked
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return s.startSession(ctx, user.ID)
+}
+
+// startSession creates a session for userID and issues its first tokens.
+func (s *service) startSession(ctx context.Context, userID int) (*Tokens, error) {
+	refreshToken, err := newRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+	tokenID, err := newTokenID()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.dbService.CreateSession(ctx, &models.Session{
+		UserID:           userID,
+		RefreshTokenHash: hashToken(refreshToken),
+		AccessTokenID:    tokenID,
+		ExpiresAt:        time.Now().Add(RefreshTokenTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.issueTokens(userID, tokenID, refreshToken)
+}
+
+func (s *service) RefreshToken(ctx context.Context, refreshToken string) (*Tokens, error) {
+	newRefresh, err := newRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+	tokenID, err := newTokenID()
+	if err != nil {
+		return nil, err
 	}
 
-	token, err := s.generateToken(user.ID)
+	session, err := s.dbService.RotateSession(ctx, hashToken(refreshToken), hashToken(newRefresh), tokenID)
 	if err != nil {
-		return "", err
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
 	}
 
-	return token, nil
+	return s.issueTokens(session.UserID, tokenID, newRefresh)
 }
 
-func (s *service) ValidateToken(tokenString string) (*Claims, error) {
+func (s *service) Logout(ctx context.Context, tokenID string) error {
+	return s.dbService.RevokeSession(ctx, tokenID)
+}
+
+func (s *service) LogoutAll(ctx context.Context, userID int) error {
+	return s.dbService.RevokeUserSessions(ctx, userID)
+}
+
+func (s *service) ValidateToken(ctx context.Context, tokenString string) (*Claims, error) {
 	claims := &Claims{}
 
 	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
 		return s.jwtKey, nil
 	})
 
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
 	}
 
-	if !token.Valid {
-		return nil, errors.New("invalid token")
+	if !token.Valid || !claims.VerifyIssuer(s.issuer, true) || !claims.VerifyAudience(s.audience, true) {
+		return nil, ErrInvalidToken
+	}
+
+	// a token is only accepted while it is the latest one of a live session
+	session, err := s.dbService.GetSessionByAccessTokenID(ctx, claims.ID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+	if session.UserID != claims.UserID {
+		return nil, ErrInvalidToken
 	}
 
 	return claims, nil
 }
 
-func (s *service) generateToken(userID int) (string, error) {
-	expirationTime := time.Now().Add(24 * time.Hour)
+// issueTokens signs an access token with the given jti and pairs it with
+// refreshToken.
+func (s *service) issueTokens(userID int, tokenID, refreshToken string) (*Tokens, error) {
+	now := time.Now()
+	expirationTime := now.Add(AccessTokenTTL)
 	claims := &Claims{
 		UserID: userID,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenID,
+			Subject:   strconv.Itoa(userID),
+			Issuer:    s.issuer,
+			Audience:  jwt.ClaimStrings{s.audience},
+			IssuedAt:  jwt.NewNumericDate(now),
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(s.jwtKey)
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.jwtKey)
+	if err != nil {
+		return nil, err
+	}
+	return &Tokens{AccessToken: token, RefreshToken: refreshToken, ExpiresAt: expirationTime}, nil
 }
 
-func (s *service) AddProject(ctx context.Context, userID int, name string) (*models.Project, error) {
-	project, err := s.dbService.CreateProject(ctx, userID, name)
+func (s *service) AddProject(ctx context.Context, project *models.Project) (*models.Project, error) {
+	if project.OrganizationID != nil {
+		role, err := s.teams.OrganizationRole(ctx, project.UserID, *project.OrganizationID)
+		if err != nil {
+			return nil, err
+		}
+		if role == "" {
+			return nil, gorm.ErrRecordNotFound
+		}
+		if !models.OrgRoleAtLeast(role, models.OrgRoleAdmin) {
+			return nil, ErrForbidden
+		}
+	}
+
+	project, err := s.dbService.CreateProject(ctx, project)
 	if err != nil {
 		return nil, err
 	}
 	return project, err
 }
 
+func (s *service) AuthorizeProject(ctx context.Context, userID, projectID int, minRole string) (*models.Project, string, error) {
+	project, err := s.dbService.GetProject(ctx, projectID)
+	if err != nil {
+		return nil, "", err
+	}
+	role, err := s.teams.ProjectRole(ctx, userID, project)
+	if err != nil {
+		return nil, "", err
+	}
+	if role == "" {
+		return nil, "", gorm.ErrRecordNotFound
+	}
+	if !models.RoleAtLeast(role, minRole) {
+		return nil, "", ErrForbidden
+	}
+	return project, role, nil
+}
+
 func (s *service) ListProjects(ctx context.Context, userID int) (*[]models.Project, error) {
 	projects, err := s.dbService.ListProjects(ctx, userID)
 	if err != nil {
@@ -0,0 +1,283 @@
+package connectionhealth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	_ "github.com/glebarez/go-sqlite"
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5"
+	_ "github.com/marcboeker/go-duckdb"
+
+	databaseconnection "github.com/cr34t1ve/hoprun/internal/database_connection"
+	"github.com/cr34t1ve/hoprun/internal/dsn"
+	sshtunnel "github.com/cr34t1ve/hoprun/internal/ssh_tunnel"
+	"github.com/cr34t1ve/hoprun/pkg/models"
+)
+
+const (
+	// DefaultTimeout bounds a single check, including connecting
+	DefaultTimeout = 5 * time.Second
+	// DefaultInterval is how often Run re-checks every connection
+	DefaultInterval = 15 * time.Minute
+)
+
+// Checker tests whether HopRun can reach and introspect user databases and
+// records the outcome on each connection.
+type Checker interface {
+	// Check tests a single connection and stores the result. The returned
+	// check describes connection failures; the error is only set when the
+	// connection cannot be loaded or the result cannot be stored.
+	Check(ctx context.Context, connectionID int) (*models.ConnectionCheck, error)
+	// Run re-checks every connection each interval until ctx is cancelled.
+	Run(ctx context.Context, interval time.Duration)
+}
+
+type checker struct {
+	connections databaseconnection.Service
+	tunnels     sshtunnel.Manager
+	timeout     time.Duration
+}
+
+func NewChecker(connections databaseconnection.Service, tunnels sshtunnel.Manager, timeout time.Duration) Checker {
+	return &checker{connections: connections, tunnels: tunnels, timeout: timeout}
+}
+
+func (c *checker) Check(ctx context.Context, connectionID int) (*models.ConnectionCheck, error) {
+	conn, err := c.connections.GetConnection(ctx, connectionID)
+	if err != nil {
+		return nil, err
+	}
+	check := c.check(ctx, conn)
+	if err := c.connections.RecordCheck(ctx, check); err != nil {
+		return nil, err
+	}
+	return check, nil
+}
+
+func (c *checker) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.checkAll(ctx)
+		}
+	}
+}
+
+func (c *checker) checkAll(ctx context.Context) {
+	conns, err := c.connections.ListAllConnections(ctx)
+	if err != nil {
+		log.Printf("Failed to list connections for health check: %v", err)
+		return
+	}
+	for i := range conns {
+		check := c.check(ctx, &conns[i])
+		if err := c.connections.RecordCheck(ctx, check); err != nil {
+			log.Printf("Failed to record health check for connection %d: %v", conns[i].ID, err)
+		}
+	}
+}
+
+// check connects with a dedicated connection rather than the shared pool, so
+// a broken pool cannot hide a working configuration or the other way round.
+func (c *checker) check(ctx context.Context, conn *models.DatabaseConnection) *models.ConnectionCheck {
+	check := &models.ConnectionCheck{
+		ConnectionID: conn.ID,
+		Status:       models.ConnectionStatusError,
+		CheckedAt:    time.Now(),
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	var err error
+	switch conn.Dialect {
+	case models.DialectMySQL:
+		err = c.checkMySQL(ctx, conn, check)
+	case models.DialectSQLite, models.DialectDuckDB:
+		err = checkFile(ctx, conn, check)
+	default:
+		err = c.checkPostgres(ctx, conn, check)
+	}
+	if err != nil {
+		check.Error = err.Error()
+		return check
+	}
+	check.Status = models.ConnectionStatusOK
+	return check
+}
+
+func (c *checker) checkPostgres(ctx context.Context, conn *models.DatabaseConnection, check *models.ConnectionCheck) error {
+	var dial sshtunnel.DialFunc
+	if conn.SSHHost != "" {
+		dial = c.tunnels.Dialer(conn)
+	}
+	config, err := dsn.PostgresConfig(conn, dial)
+	if err != nil {
+		return err
+	}
+	db, err := pgx.ConnectConfig(ctx, config)
+	if err != nil {
+		return err
+	}
+	defer db.Close(context.Background())
+
+	err = db.QueryRow(ctx, "SELECT current_setting('server_version'), current_user").Scan(&check.ServerVersion, &check.CurrentUser)
+	if err != nil {
+		return err
+	}
+	check.LatencyMs = time.Since(check.CheckedAt).Milliseconds()
+
+	var columns int
+	if err := db.QueryRow(ctx, "SELECT count(*) FROM information_schema.columns").Scan(&columns); err != nil {
+		return errors.New("cannot read information_schema: " + err.Error())
+	}
+	check.CanReadCatalog = true
+
+	var superuser, canWrite, readOnlyDefault bool
+	err = db.QueryRow(ctx, `
+		SELECT r.rolsuper,
+		       EXISTS (
+		           SELECT 1
+		           FROM pg_class c
+		           JOIN pg_namespace n ON n.oid = c.relnamespace
+		           WHERE c.relkind IN ('r', 'p')
+		             AND n.nspname NOT IN ('pg_catalog', 'information_schema')
+		             AND n.nspname NOT LIKE 'pg_toast%'
+		             AND has_table_privilege(c.oid, 'INSERT, UPDATE, DELETE, TRUNCATE')
+		       ),
+		       current_setting('default_transaction_read_only') = 'on'
+		FROM pg_roles r
+		WHERE r.rolname = current_user
+	`).Scan(&superuser, &canWrite, &readOnlyDefault)
+	if err != nil {
+		return errors.New("cannot read role privileges: " + err.Error())
+	}
+	// superusers can turn default_transaction_read_only off again
+	check.ReadOnly = !superuser && (readOnlyDefault || !canWrite)
+	addPrivilegeWarnings(check, superuser)
+	return nil
+}
+
+// checkMySQL reads the account's privileges from SHOW GRANTS, since MySQL
+// has no per-table privilege function. Privileges granted through roles are
+// not expanded.
+func (c *checker) checkMySQL(ctx context.Context, conn *models.DatabaseConnection, check *models.ConnectionCheck) error {
+	var dial sshtunnel.DialFunc
+	if conn.SSHHost != "" {
+		dial = c.tunnels.Dialer(conn)
+	}
+	config, err := dsn.MySQLConfig(conn, dial)
+	if err != nil {
+		return err
+	}
+	connector, err := mysql.NewConnector(config)
+	if err != nil {
+		return err
+	}
+	db := sql.OpenDB(connector)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	err = db.QueryRowContext(ctx, "SELECT VERSION(), CURRENT_USER()").Scan(&check.ServerVersion, &check.CurrentUser)
+	if err != nil {
+		return err
+	}
+	check.LatencyMs = time.Since(check.CheckedAt).Milliseconds()
+
+	var columns int
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM information_schema.COLUMNS").Scan(&columns); err != nil {
+		return errors.New("cannot read information_schema: " + err.Error())
+	}
+	check.CanReadCatalog = true
+
+	rows, err := db.QueryContext(ctx, "SHOW GRANTS")
+	if err != nil {
+		return errors.New("cannot read account privileges: " + err.Error())
+	}
+	defer rows.Close()
+	var superuser, canWrite bool
+	for rows.Next() {
+		var grant string
+		if err := rows.Scan(&grant); err != nil {
+			return err
+		}
+		privileges, target, ok := strings.Cut(strings.ToUpper(grant), " ON ")
+		if !ok {
+			continue
+		}
+		global := strings.HasPrefix(strings.TrimSpace(target), "*.*")
+		for _, privilege := range strings.Split(strings.TrimPrefix(privileges, "GRANT "), ",") {
+			switch strings.TrimSpace(privilege) {
+			case "ALL", "ALL PRIVILEGES":
+				canWrite = true
+				superuser = superuser || global
+			case "SUPER":
+				superuser = true
+			case "INSERT", "UPDATE", "DELETE", "DROP", "ALTER", "CREATE":
+				canWrite = true
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var readOnlyServer bool
+	if err := db.QueryRowContext(ctx, "SELECT @@read_only").Scan(&readOnlyServer); err != nil {
+		return err
+	}
+	// read_only does not apply to accounts with SUPER
+	check.ReadOnly = !superuser && (readOnlyServer || !canWrite)
+	addPrivilegeWarnings(check, superuser)
+	return nil
+}
+
+// checkFile opens a sqlite or duckdb database the way queries do. Both are
+// opened read-only, so there are no privileges to inspect.
+func checkFile(ctx context.Context, conn *models.DatabaseConnection, check *models.ConnectionCheck) error {
+	driverName, source := "sqlite", dsn.SQLite(conn.FilePath)
+	versionQuery, catalogQuery := "SELECT 'SQLite ' || sqlite_version()", "SELECT COUNT(*) FROM sqlite_master"
+	if conn.Dialect == models.DialectDuckDB {
+		var err error
+		driverName = "duckdb"
+		if source, err = dsn.DuckDB(conn.FilePath); err != nil {
+			return err
+		}
+		versionQuery, catalogQuery = "SELECT 'DuckDB ' || version()", "SELECT COUNT(*) FROM duckdb_columns()"
+	}
+
+	db, err := sql.Open(driverName, source)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := db.QueryRowContext(ctx, versionQuery).Scan(&check.ServerVersion); err != nil {
+		return err
+	}
+	check.LatencyMs = time.Since(check.CheckedAt).Milliseconds()
+
+	var objects int
+	if err := db.QueryRowContext(ctx, catalogQuery).Scan(&objects); err != nil {
+		return errors.New("cannot read the catalog: " + err.Error())
+	}
+	check.CanReadCatalog = true
+	check.ReadOnly = true
+	return nil
+}
+
+func addPrivilegeWarnings(check *models.ConnectionCheck, superuser bool) {
+	if superuser {
+		check.Warnings = append(check.Warnings, "connected as a superuser; use a dedicated read-only role")
+	} else if !check.ReadOnly {
+		check.Warnings = append(check.Warnings, "role can modify tables; queries run read-only, but a read-only role is recommended")
+	}
+}
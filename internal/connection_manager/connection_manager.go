@@ -0,0 +1,370 @@
+package connectionmanager
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/marcboeker/go-duckdb"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+
+	"github.com/cr34t1ve/hoprun/internal/dsn"
+	sshtunnel "github.com/cr34t1ve/hoprun/internal/ssh_tunnel"
+	"github.com/cr34t1ve/hoprun/pkg/models"
+)
+
+const (
+	DefaultMaxOpenConns = 5
+	DefaultMaxIdleConns = 2
+	// DefaultIdleTimeout is how long a pool may go unused before it is closed
+	DefaultIdleTimeout = 10 * time.Minute
+	connMaxIdleTime    = 5 * time.Minute
+	// pingTimeout bounds the first connection to a database, so an
+	// unreachable host or jump host fails fast
+	pingTimeout = 10 * time.Second
+)
+
+type PoolStats struct {
+	ConnectionID      int           `json:"connection_id"`
+	MaxOpen           int           `json:"max_open"`
+	Open              int           `json:"open"`
+	InUse             int           `json:"in_use"`
+	Idle              int           `json:"idle"`
+	WaitCount         int64         `json:"wait_count"`
+	WaitDuration      time.Duration `json:"wait_duration"`
+	MaxIdleClosed     int64         `json:"max_idle_closed"`
+	MaxIdleTimeClosed int64         `json:"max_idle_time_closed"`
+	LastUsed          time.Time     `json:"last_used"`
+}
+
+// Manager keeps one connection pool per user database connection, keyed by
+// DatabaseConnection.ID, so requests reuse connections instead of opening a
+// new pool each time.
+type Manager interface {
+	// Get returns the pool for conn, opening it on first use, and a release
+	// function the caller must call once it is done with the pool. A pool
+	// opened for an older version of the connection is replaced.
+	Get(conn *models.DatabaseConnection) (*gorm.DB, func(), error)
+	// Close retires the pool and SSH tunnel for a connection, if open. It
+	// must be called when a connection is updated or deleted. Requests
+	// still using the pool finish first; it is closed on the last release.
+	Close(connectionID int) error
+	Stats(connectionID int) (*PoolStats, bool)
+	// Run closes pools that have been idle for longer than the idle timeout
+	// until ctx is cancelled.
+	Run(ctx context.Context)
+}
+
+type pool struct {
+	// mu serialises opening and closing the pool so concurrent requests for
+	// a new connection open it once, without holding up other connections
+	mu        sync.Mutex
+	db        *handle
+	updatedAt time.Time
+	closed    bool
+	lastUsed  time.Time
+}
+
+// handle is an opened *gorm.DB and the number of requests using it. Once
+// retired it is closed as soon as none are.
+type handle struct {
+	mu      sync.Mutex
+	db      *gorm.DB
+	active  int
+	retired bool
+}
+
+type manager struct {
+	mu          sync.Mutex
+	pools       map[int]*pool
+	idleTimeout time.Duration
+	tunnels     sshtunnel.Manager
+}
+
+func NewManager(idleTimeout time.Duration, tunnels sshtunnel.Manager) Manager {
+	if idleTimeout <= 0 {
+		idleTimeout = DefaultIdleTimeout
+	}
+	return &manager{
+		pools:       make(map[int]*pool),
+		idleTimeout: idleTimeout,
+		tunnels:     tunnels,
+	}
+}
+
+func (m *manager) Get(conn *models.DatabaseConnection) (*gorm.DB, func(), error) {
+	for {
+		m.mu.Lock()
+		p, ok := m.pools[conn.ID]
+		if !ok {
+			p = &pool{}
+			m.pools[conn.ID] = p
+		}
+		p.lastUsed = time.Now()
+		m.mu.Unlock()
+
+		p.mu.Lock()
+		if p.closed {
+			// closed while we were waiting; pick up the replacement
+			p.mu.Unlock()
+			continue
+		}
+		if p.db != nil && p.updatedAt.Equal(conn.UpdatedAt) {
+			h := p.db
+			release := h.acquire()
+			p.mu.Unlock()
+			return h.db, release, nil
+		}
+
+		if p.db != nil {
+			p.db.retire()
+			p.db = nil
+		}
+		db, err := open(conn, m.tunnels)
+		if err != nil {
+			p.mu.Unlock()
+			return nil, nil, err
+		}
+		h := &handle{db: db}
+		p.db = h
+		p.updatedAt = conn.UpdatedAt
+		release := h.acquire()
+		p.mu.Unlock()
+		return db, release, nil
+	}
+}
+
+func (m *manager) Close(connectionID int) error {
+	m.mu.Lock()
+	p, ok := m.pools[connectionID]
+	delete(m.pools, connectionID)
+	m.mu.Unlock()
+
+	var err error
+	if ok {
+		err = p.close()
+	}
+	if tunnelErr := m.tunnels.Close(connectionID); err == nil {
+		err = tunnelErr
+	}
+	return err
+}
+
+func (m *manager) Stats(connectionID int) (*PoolStats, bool) {
+	m.mu.Lock()
+	p, ok := m.pools[connectionID]
+	var lastUsed time.Time
+	if ok {
+		lastUsed = p.lastUsed
+	}
+	m.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.db == nil {
+		return nil, false
+	}
+	sqlDB, err := p.db.db.DB()
+	if err != nil {
+		return nil, false
+	}
+	stats := sqlDB.Stats()
+	return &PoolStats{
+		ConnectionID:      connectionID,
+		MaxOpen:           stats.MaxOpenConnections,
+		Open:              stats.OpenConnections,
+		InUse:             stats.InUse,
+		Idle:              stats.Idle,
+		WaitCount:         stats.WaitCount,
+		WaitDuration:      stats.WaitDuration,
+		MaxIdleClosed:     stats.MaxIdleClosed,
+		MaxIdleTimeClosed: stats.MaxIdleTimeClosed,
+		LastUsed:          lastUsed,
+	}, true
+}
+
+func (m *manager) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.idleTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.evictIdle()
+		}
+	}
+}
+
+func (m *manager) evictIdle() {
+	cutoff := time.Now().Add(-m.idleTimeout)
+
+	m.mu.Lock()
+	var idle []*pool
+	for id, p := range m.pools {
+		// lastUsed is only set when a request picks up the pool, so a long
+		// query can outlive the cutoff; retiring it lets that query finish
+		if p.lastUsed.Before(cutoff) {
+			idle = append(idle, p)
+			delete(m.pools, id)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, p := range idle {
+		p.close()
+	}
+}
+
+func (p *pool) close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	if p.db == nil {
+		return nil
+	}
+	err := p.db.retire()
+	p.db = nil
+	return err
+}
+
+// acquire counts the caller as a user of the handle and returns the function
+// that releases it. The caller holds the pool's mu, so a retired handle is
+// never acquired.
+func (h *handle) acquire() func() {
+	h.mu.Lock()
+	h.active++
+	h.mu.Unlock()
+	var once sync.Once
+	return func() { once.Do(h.release) }
+}
+
+func (h *handle) release() {
+	h.mu.Lock()
+	h.active--
+	closeNow := h.retired && h.active == 0
+	h.mu.Unlock()
+	if closeNow {
+		closeDB(h.db)
+	}
+}
+
+// retire closes the handle now if nobody is using it, or else on the last
+// release.
+func (h *handle) retire() error {
+	h.mu.Lock()
+	h.retired = true
+	closeNow := h.active == 0
+	h.mu.Unlock()
+	if !closeNow {
+		return nil
+	}
+	return closeDB(h.db)
+}
+
+func open(conn *models.DatabaseConnection, tunnels sshtunnel.Manager) (*gorm.DB, error) {
+	dialector, err := newDialector(conn, tunnels)
+	if err != nil {
+		return nil, err
+	}
+	// gorm's own ping has no deadline and leaves the pool open when it fails
+	db, err := gorm.Open(dialector, &gorm.Config{TranslateError: true, DisableAutomaticPing: true})
+	if err != nil {
+		if db != nil {
+			closeDB(db)
+		}
+		return nil, err
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+	defer cancel()
+	if err := sqlDB.PingContext(ctx); err != nil {
+		sqlDB.Close()
+		return nil, err
+	}
+	maxOpen, maxIdle := conn.MaxOpenConns, conn.MaxIdleConns
+	if maxOpen <= 0 {
+		maxOpen = DefaultMaxOpenConns
+	}
+	if maxIdle <= 0 {
+		maxIdle = DefaultMaxIdleConns
+	}
+	if maxIdle > maxOpen {
+		maxIdle = maxOpen
+	}
+	sqlDB.SetMaxOpenConns(maxOpen)
+	sqlDB.SetMaxIdleConns(maxIdle)
+	sqlDB.SetConnMaxIdleTime(connMaxIdleTime)
+
+	return db, nil
+}
+
+func newDialector(conn *models.DatabaseConnection, tunnels sshtunnel.Manager) (gorm.Dialector, error) {
+	var dial sshtunnel.DialFunc
+	if conn.SSHHost != "" {
+		dial = tunnels.Dialer(conn)
+	}
+
+	switch conn.Dialect {
+	case models.DialectMySQL:
+		config, err := dsn.MySQLConfig(conn, dial)
+		if err != nil {
+			return nil, err
+		}
+		connector, err := mysqldriver.NewConnector(config)
+		if err != nil {
+			return nil, err
+		}
+		return mysql.New(mysql.Config{Conn: sql.OpenDB(connector)}), nil
+	case models.DialectSQLite:
+		return sqlite.Open(dsn.SQLite(conn.FilePath)), nil
+	case models.DialectDuckDB:
+		source, err := dsn.DuckDB(conn.FilePath)
+		if err != nil {
+			return nil, err
+		}
+		connector, err := duckdb.NewConnector(source, nil)
+		if err != nil {
+			return nil, err
+		}
+		return duckDBDialector{postgres.New(postgres.Config{Conn: sql.OpenDB(connector)})}, nil
+	default:
+		config, err := dsn.PostgresConfig(conn, dial)
+		if err != nil {
+			return nil, err
+		}
+		return postgres.New(postgres.Config{Conn: stdlib.OpenDB(*config)}), nil
+	}
+}
+
+// duckDBDialector runs DuckDB through the Postgres dialector, whose SQL
+// generation DuckDB understands, under its own name so the database service
+// can tell the two apart. HopRun only sends raw queries to user databases.
+type duckDBDialector struct {
+	gorm.Dialector
+}
+
+func (duckDBDialector) Name() string {
+	return models.DialectDuckDB
+}
+
+func closeDB(db *gorm.DB) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
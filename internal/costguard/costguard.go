@@ -0,0 +1,345 @@
+package costguard
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cr34t1ve/hoprun/pkg/models"
+)
+
+// Actions taken when a plan exceeds a project's thresholds.
+const (
+	ActionRefuse  = "refuse"
+	ActionConfirm = "confirm"
+	ActionLimit   = "limit"
+)
+
+const (
+	DefaultLimit = 1000
+	// confirmationTTL is how long a plan awaiting confirmation can be run
+	confirmationTTL = 10 * time.Minute
+)
+
+// Thresholds are a project's cost guard settings. A zero MaxCost or MaxRows
+// disables that check.
+type Thresholds struct {
+	MaxCost float64
+	MaxRows float64
+	Action  string
+	Limit   int
+}
+
+func (t Thresholds) Enabled() bool {
+	return t.MaxCost > 0 || t.MaxRows > 0
+}
+
+func IsValidAction(action string) bool {
+	switch action {
+	case "", ActionRefuse, ActionConfirm, ActionLimit:
+		return true
+	}
+	return false
+}
+
+// Exceeded reports whether plan is over the thresholds and why. Rows are
+// compared against the largest estimate of any plan node, so a full scan of
+// a large table is caught even when the query only returns a few rows.
+func Exceeded(plan *models.QueryPlan, t Thresholds) (string, bool) {
+	if t.MaxCost > 0 && plan.TotalCost > t.MaxCost {
+		return fmt.Sprintf("estimated cost %.0f exceeds the project limit of %.0f", plan.TotalCost, t.MaxCost), true
+	}
+	if t.MaxRows > 0 && plan.MaxNodeRows > t.MaxRows {
+		return fmt.Sprintf("estimated %.0f rows exceeds the project limit of %.0f", plan.MaxNodeRows, t.MaxRows), true
+	}
+	return "", false
+}
+
+// ExceededWithLimit is Exceeded for the plan of a query that the limit
+// action has wrapped in a LIMIT. Only the cost is compared: the scans under
+// the limit keep their full row estimates in every dialect's plan, so the
+// row check would refuse nearly every query the limit was meant to allow.
+// Without a MaxCost the rows are compared after all, since a LIMIT does not
+// shorten the scan under an aggregate and nothing else would catch it.
+func ExceededWithLimit(plan *models.QueryPlan, t Thresholds) (string, bool) {
+	if t.MaxCost <= 0 {
+		return Exceeded(plan, t)
+	}
+	return Exceeded(plan, Thresholds{MaxCost: t.MaxCost})
+}
+
+// ErrLimitNeedsCost is returned by Validate for the limit action without a
+// MaxCost, the only threshold the limited plan can be judged by.
+var ErrLimitNeedsCost = errors.New("cost_guard_action limit requires max_query_cost")
+
+// Validate checks a project's cost guard settings.
+func (t Thresholds) Validate() error {
+	if !IsValidAction(t.Action) {
+		return fmt.Errorf("unknown cost guard action: %s", t.Action)
+	}
+	if t.Action == ActionLimit && t.MaxCost <= 0 {
+		return ErrLimitNeedsCost
+	}
+	return nil
+}
+
+// Error is returned when a plan exceeds the thresholds and the project's
+// action is to refuse the query or to ask for confirmation.
+type Error struct {
+	Reason            string
+	Plan              *models.QueryPlan
+	NeedsConfirmation bool
+}
+
+func (e *Error) Error() string {
+	return "query stopped by cost guard: " + e.Reason
+}
+
+type planNode struct {
+	NodeType     string     `json:"Node Type"`
+	RelationName string     `json:"Relation Name"`
+	TotalCost    float64    `json:"Total Cost"`
+	PlanRows     float64    `json:"Plan Rows"`
+	Plans        []planNode `json:"Plans"`
+}
+
+// Summarize reads the output of EXPLAIN (FORMAT JSON). The full plan is kept
+// on the summary so it can be shown when confirmation is needed.
+func Summarize(explain []byte) (*models.QueryPlan, error) {
+	var output []struct {
+		Plan planNode `json:"Plan"`
+	}
+	if err := json.Unmarshal(explain, &output); err != nil {
+		return nil, err
+	}
+	if len(output) == 0 {
+		return nil, errors.New("empty query plan")
+	}
+
+	root := output[0].Plan
+	plan := &models.QueryPlan{
+		NodeType:      root.NodeType,
+		TotalCost:     root.TotalCost,
+		EstimatedRows: root.PlanRows,
+		Plan:          explain,
+	}
+
+	var walk func(node planNode)
+	walk = func(node planNode) {
+		if node.PlanRows > plan.MaxNodeRows {
+			plan.MaxNodeRows = node.PlanRows
+		}
+		if node.NodeType == "Seq Scan" && node.RelationName != "" {
+			plan.SeqScans = append(plan.SeqScans, node.RelationName)
+		}
+		for _, child := range node.Plans {
+			walk(child)
+		}
+	}
+	walk(root)
+
+	return plan, nil
+}
+
+// SummarizeMySQL reads the output of MySQL's EXPLAIN FORMAT=JSON. Tables
+// appear at varying depths under nested_loop, ordering_operation and
+// subquery keys, so the whole document is searched for them. MariaDB
+// reports no cost, which leaves TotalCost at zero.
+func SummarizeMySQL(explain []byte) (*models.QueryPlan, error) {
+	var output struct {
+		QueryBlock map[string]interface{} `json:"query_block"`
+	}
+	if err := json.Unmarshal(explain, &output); err != nil {
+		return nil, err
+	}
+	if output.QueryBlock == nil {
+		return nil, errors.New("empty query plan")
+	}
+
+	plan := &models.QueryPlan{NodeType: "query_block", Plan: explain}
+	if costInfo, ok := output.QueryBlock["cost_info"].(map[string]interface{}); ok {
+		plan.TotalCost = jsonNumber(costInfo["query_cost"])
+	}
+
+	var walk func(value interface{})
+	walk = func(value interface{}) {
+		switch v := value.(type) {
+		case []interface{}:
+			for _, child := range v {
+				walk(child)
+			}
+		case map[string]interface{}:
+			if table, ok := v["table_name"].(string); ok {
+				rows := jsonNumber(v["rows_examined_per_scan"])
+				if rows == 0 {
+					// MariaDB
+					rows = jsonNumber(v["rows"])
+				}
+				if rows > plan.MaxNodeRows {
+					plan.MaxNodeRows = rows
+				}
+				if produced := jsonNumber(v["rows_produced_per_join"]); produced > plan.EstimatedRows {
+					plan.EstimatedRows = produced
+				}
+				if v["access_type"] == "ALL" {
+					plan.SeqScans = append(plan.SeqScans, table)
+				}
+			}
+			for _, child := range v {
+				walk(child)
+			}
+		}
+	}
+	walk(output.QueryBlock)
+
+	return plan, nil
+}
+
+// SummarizeSQLite reads EXPLAIN QUERY PLAN rows as returned by the database
+// service. SQLite estimates neither cost nor rows, so only full table scans
+// are reported and thresholds never trigger.
+func SummarizeSQLite(explain []byte) (*models.QueryPlan, error) {
+	var steps []struct {
+		Detail string `json:"detail"`
+	}
+	if err := json.Unmarshal(explain, &steps); err != nil {
+		return nil, err
+	}
+	if len(steps) == 0 {
+		return nil, errors.New("empty query plan")
+	}
+
+	plan := &models.QueryPlan{NodeType: steps[0].Detail, Plan: explain}
+	for _, step := range steps {
+		// "SCAN orders", or "SCAN TABLE orders" before SQLite 3.36; scans
+		// through an index say USING. Subqueries are "SCAN (subquery-1)",
+		// formerly "SCAN SUBQUERY 1"
+		fields := strings.Fields(step.Detail)
+		if len(fields) < 2 || fields[0] != "SCAN" || strings.Contains(step.Detail, " USING ") {
+			continue
+		}
+		table := fields[1]
+		if table == "TABLE" && len(fields) > 2 {
+			table = fields[2]
+		}
+		if table != "CONSTANT" && table != "SUBQUERY" && !strings.HasPrefix(table, "(") {
+			plan.SeqScans = append(plan.SeqScans, table)
+		}
+	}
+	return plan, nil
+}
+
+// duckDBRows matches the estimated cardinality DuckDB prints in each plan
+// box: "EC: 120" up to 1.0, "~120 Rows" since.
+var duckDBRows = regexp.MustCompile(`EC: (\d+)|~(\d+) Rows?`)
+
+// SummarizeDuckDB reads the text plan DuckDB prints for EXPLAIN, which has
+// no cost; the largest row estimate of any operator is used for MaxNodeRows.
+func SummarizeDuckDB(explain []byte) (*models.QueryPlan, error) {
+	text := string(explain)
+	if strings.TrimSpace(text) == "" {
+		return nil, errors.New("empty query plan")
+	}
+	raw, err := json.Marshal(text)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &models.QueryPlan{Plan: raw}
+	for _, line := range strings.Split(text, "\n") {
+		// the first line inside the top box names the root operator
+		name := strings.TrimSpace(strings.Trim(strings.TrimSpace(line), "│┌┐└┘─"))
+		if name != "" {
+			plan.NodeType = strings.Fields(name)[0]
+			break
+		}
+	}
+	for _, match := range duckDBRows.FindAllStringSubmatch(text, -1) {
+		rows, _ := strconv.ParseFloat(match[1]+match[2], 64)
+		if rows > plan.MaxNodeRows {
+			plan.MaxNodeRows = rows
+		}
+	}
+	return plan, nil
+}
+
+// jsonNumber reads a number that MySQL may print either as a JSON number or
+// as a string.
+func jsonNumber(value interface{}) float64 {
+	switch v := value.(type) {
+	case float64:
+		return v
+	case string:
+		n, _ := strconv.ParseFloat(v, 64)
+		return n
+	}
+	return 0
+}
+
+// Confirmations holds generated queries whose plans exceeded a project's
+// thresholds until the user confirms them. Confirming runs the stored SQL
+// rather than regenerating it, so the user runs exactly the plan they saw.
+// Caller identifies who the query was generated for, such as a user or an
+// API key, and only they can confirm it.
+type Confirmations interface {
+	Add(projectID, connectionID int, caller, sql string) (string, error)
+	Take(token string, projectID int, caller string) (connectionID int, sql string, ok bool)
+}
+
+type pendingQuery struct {
+	projectID    int
+	connectionID int
+	caller       string
+	sql          string
+	expiresAt    time.Time
+}
+
+type confirmations struct {
+	mu      sync.Mutex
+	pending map[string]pendingQuery
+}
+
+func NewConfirmations() Confirmations {
+	return &confirmations{pending: make(map[string]pendingQuery)}
+}
+
+func (c *confirmations) Add(projectID, connectionID int, caller, sql string) (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(buf)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for t, p := range c.pending {
+		if now.After(p.expiresAt) {
+			delete(c.pending, t)
+		}
+	}
+	c.pending[token] = pendingQuery{projectID: projectID, connectionID: connectionID, caller: caller, sql: sql, expiresAt: now.Add(confirmationTTL)}
+	return token, nil
+}
+
+// Take returns the SQL stored under token and the connection it was
+// generated for, and forgets it. Tokens are single use and only valid for the
+// project and caller they were issued to; presenting one elsewhere leaves it
+// for its owner.
+func (c *confirmations) Take(token string, projectID int, caller string) (int, string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, ok := c.pending[token]
+	if !ok || p.projectID != projectID || p.caller != caller || time.Now().After(p.expiresAt) {
+		return 0, "", false
+	}
+	delete(c.pending, token)
+	return p.connectionID, p.sql, true
+}
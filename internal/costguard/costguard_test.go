@@ -0,0 +1,196 @@
+package costguard
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/cr34t1ve/hoprun/pkg/models"
+)
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestSummarize(t *testing.T) {
+	tests := []struct {
+		fixture       string
+		summarize     func([]byte) (*models.QueryPlan, error)
+		nodeType      string
+		totalCost     float64
+		estimatedRows float64
+		maxNodeRows   float64
+		seqScans      []string
+	}{
+		{"postgres.json", Summarize, "Limit", 1834.12, 1001, 500000, []string{"orders"}},
+		{"mysql.json", SummarizeMySQL, "query_block", 50672.10, 49821, 498213, []string{"o"}},
+		// MariaDB reports rows instead of rows_examined_per_scan and no cost
+		{"mariadb.json", SummarizeMySQL, "query_block", 0, 0, 120000, []string{"orders"}},
+		{"sqlite.json", SummarizeSQLite, "CO-ROUTINE hoprun_result", 0, 0, 0, []string{"orders"}},
+		// before 3.36 SQLite printed SCAN TABLE and SCAN SUBQUERY
+		{"sqlite_legacy.json", SummarizeSQLite, "SCAN TABLE orders AS o", 0, 0, 0, []string{"orders"}},
+		{"duckdb.txt", SummarizeDuckDB, "LIMIT", 0, 0, 500000, nil},
+		// before 1.1 DuckDB printed the estimate as EC:
+		{"duckdb_legacy.txt", SummarizeDuckDB, "PROJECTION", 0, 0, 120000, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			plan, err := tt.summarize(readFixture(t, tt.fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if plan.NodeType != tt.nodeType {
+				t.Errorf("NodeType = %q, want %q", plan.NodeType, tt.nodeType)
+			}
+			if plan.TotalCost != tt.totalCost {
+				t.Errorf("TotalCost = %v, want %v", plan.TotalCost, tt.totalCost)
+			}
+			if plan.EstimatedRows != tt.estimatedRows {
+				t.Errorf("EstimatedRows = %v, want %v", plan.EstimatedRows, tt.estimatedRows)
+			}
+			if plan.MaxNodeRows != tt.maxNodeRows {
+				t.Errorf("MaxNodeRows = %v, want %v", plan.MaxNodeRows, tt.maxNodeRows)
+			}
+			sort.Strings(plan.SeqScans)
+			if !reflect.DeepEqual(plan.SeqScans, tt.seqScans) {
+				t.Errorf("SeqScans = %v, want %v", plan.SeqScans, tt.seqScans)
+			}
+			if len(plan.Plan) == 0 {
+				t.Error("full plan not kept")
+			}
+		})
+	}
+}
+
+func TestSummarizeEmpty(t *testing.T) {
+	summarizers := map[string]func([]byte) (*models.QueryPlan, error){
+		"postgres": Summarize,
+		"mysql":    SummarizeMySQL,
+		"sqlite":   SummarizeSQLite,
+		"duckdb":   SummarizeDuckDB,
+	}
+	inputs := map[string]string{
+		"postgres": "[]",
+		"mysql":    "{}",
+		"sqlite":   "[]",
+		"duckdb":   " \n",
+	}
+	for name, summarize := range summarizers {
+		if _, err := summarize([]byte(inputs[name])); err == nil {
+			t.Errorf("%s: empty plan accepted", name)
+		}
+	}
+}
+
+func TestExceeded(t *testing.T) {
+	plan := &models.QueryPlan{TotalCost: 5000, EstimatedRows: 10, MaxNodeRows: 200000}
+	tests := []struct {
+		name       string
+		thresholds Thresholds
+		exceeded   bool
+		withLimit  bool
+	}{
+		{"disabled", Thresholds{}, false, false},
+		{"under both", Thresholds{MaxCost: 10000, MaxRows: 500000}, false, false},
+		{"over cost", Thresholds{MaxCost: 1000}, true, true},
+		// the rows under a LIMIT keep their full estimates, so only the cost
+		// is compared once the limit applies
+		{"over rows", Thresholds{MaxCost: 10000, MaxRows: 1000}, true, false},
+		// without a cost there is nothing else to judge the limited plan by
+		{"over rows without cost", Thresholds{MaxRows: 1000}, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if reason, exceeded := Exceeded(plan, tt.thresholds); exceeded != tt.exceeded || exceeded == (reason == "") {
+				t.Errorf("Exceeded() = %q, %v; want exceeded %v", reason, exceeded, tt.exceeded)
+			}
+			if reason, exceeded := ExceededWithLimit(plan, tt.thresholds); exceeded != tt.withLimit || exceeded == (reason == "") {
+				t.Errorf("ExceededWithLimit() = %q, %v; want exceeded %v", reason, exceeded, tt.withLimit)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		thresholds Thresholds
+		valid      bool
+	}{
+		{Thresholds{}, true},
+		{Thresholds{Action: ActionConfirm, MaxRows: 1000}, true},
+		{Thresholds{Action: ActionLimit, MaxCost: 1000}, true},
+		{Thresholds{Action: ActionLimit, MaxRows: 1000}, false},
+		{Thresholds{Action: "ask"}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.thresholds.Validate(); (err == nil) != tt.valid {
+			t.Errorf("%+v.Validate() = %v, want valid %v", tt.thresholds, err, tt.valid)
+		}
+	}
+}
+
+func TestConfirmations(t *testing.T) {
+	c := NewConfirmations()
+
+	token, err := c.Add(1, 7, "user:3", "SELECT * FROM orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok := c.Take(token, 2, "user:3"); ok {
+		t.Fatal("token taken for another project")
+	}
+	// a teammate, or an API key of the same user, cannot confirm the query
+	if _, _, ok := c.Take(token, 1, "user:4"); ok {
+		t.Fatal("token taken by another user")
+	}
+	if _, _, ok := c.Take(token, 1, "key:3"); ok {
+		t.Fatal("token taken by an API key")
+	}
+	connectionID, sql, ok := c.Take(token, 1, "user:3")
+	if !ok || connectionID != 7 || sql != "SELECT * FROM orders" {
+		t.Fatalf("Take() = %d, %q, %v; want the stored query", connectionID, sql, ok)
+	}
+	if _, _, ok := c.Take(token, 1, "user:3"); ok {
+		t.Fatal("token taken twice")
+	}
+	if _, _, ok := c.Take("unknown", 1, "user:3"); ok {
+		t.Fatal("unknown token taken")
+	}
+}
+
+func TestConfirmationsExpire(t *testing.T) {
+	c := NewConfirmations().(*confirmations)
+
+	expired, err := c.Add(1, 7, "user:3", "SELECT 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.mu.Lock()
+	p := c.pending[expired]
+	p.expiresAt = time.Now().Add(-time.Second)
+	c.pending[expired] = p
+	c.mu.Unlock()
+
+	if _, _, ok := c.Take(expired, 1, "user:3"); ok {
+		t.Fatal("expired token taken")
+	}
+
+	// expired tokens are forgotten when the next one is added
+	if _, err := c.Add(1, 7, "user:3", "SELECT 2"); err != nil {
+		t.Fatal(err)
+	}
+	c.mu.Lock()
+	_, kept := c.pending[expired]
+	c.mu.Unlock()
+	if kept {
+		t.Fatal("expired token still stored")
+	}
+}
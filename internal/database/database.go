@@ -2,19 +2,49 @@ package database
 
 import (
 	"context"
-	"fmt"
+	"strings"
+	"time"
 
 	"github.com/cr34t1ve/hoprun/pkg/models"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type Service interface {
+	// Dialect names the kind of database behind the service, one of the
+	// models.Dialect constants.
+	Dialect() string
 	ExecuteRawQuery(query string) ([]map[string]interface{}, error)
-	GetDatabaseSchema() (string, error)
+	ExecuteReadOnlyQuery(ctx context.Context, query string, opts QueryOptions) ([]map[string]interface{}, bool, error)
+	ExplainReadOnlyQuery(ctx context.Context, query string, opts QueryOptions) ([]byte, error)
+	GetDatabaseSchema(opts SchemaOptions) (*models.Schema, error)
+	GetSchemaFingerprint(opts SchemaOptions) (string, error)
 	CreateUser(ctx context.Context, email, passwordHash string) (*models.User, error)
 	GetUserByEmail(ctx context.Context, email string) (*models.User, error)
-	CreateProject(ctx context.Context, userID int, name string) (*models.Project, error)
+	// GetUserByOIDCSubject returns the user linked to a single sign-on
+	// account.
+	GetUserByOIDCSubject(ctx context.Context, issuer, subject string) (*models.User, error)
+	// LinkOIDCUser links the user with the given email address to a single
+	// sign-on account, creating a user without a password if there is none.
+	// Linking removes the user's password and ends their sessions, since
+	// nothing proved that whoever registered the address owns it. A user
+	// already linked to another account is reported as gorm.ErrDuplicatedKey.
+	LinkOIDCUser(ctx context.Context, issuer, subject, email string) (*models.User, error)
+	CreateProject(ctx context.Context, project *models.Project) (*models.Project, error)
+	GetProject(ctx context.Context, projectID int) (*models.Project, error)
+	// UpdateProject saves the named settings of a project; any other than
+	// ProjectSettings are ignored, so its owner, organization and default
+	// connection are left alone.
+	UpdateProject(ctx context.Context, project *models.Project, settings []string) (*models.Project, error)
 	ListProjects(ctx context.Context, userID int) (*[]models.Project, error)
+	CreateSession(ctx context.Context, session *models.Session) (*models.Session, error)
+	GetSessionByAccessTokenID(ctx context.Context, accessTokenID string) (*models.Session, error)
+	// RotateSession exchanges a session's refresh token for a new one. A
+	// refresh token the session has already exchanged revokes the session,
+	// since only a copy of the token can be presented twice.
+	RotateSession(ctx context.Context, refreshTokenHash, newRefreshTokenHash, accessTokenID string) (*models.Session, error)
+	RevokeSession(ctx context.Context, accessTokenID string) error
+	RevokeUserSessions(ctx context.Context, userID int) error
 }
 
 type service struct {
@@ -25,48 +55,16 @@ func NewService(db *gorm.DB) Service {
 	return &service{db: db}
 }
 
+func (s *service) Dialect() string {
+	return s.db.Dialector.Name()
+}
+
 func (s *service) ExecuteRawQuery(query string) ([]map[string]interface{}, error) {
 	var results []map[string]interface{}
 	err := s.db.Raw(query).Scan(&results).Error
 	return results, err
 }
 
-func (s *service) GetDatabaseSchema() (string, error) {
-	var tables []string
-	err := s.db.Raw(`
-	SELECT table_name 
-    FROM information_schema.tables 
-    WHERE table_schema = 'public'
-	`).Scan(&tables).Error
-	if err != nil {
-		return "", err
-	}
-
-	var schema string
-	for _, table := range tables {
-		var columns []struct {
-			ColumnName string `gorm:"column:column_name"`
-			DataType   string `gorm:"column:data_type"`
-		}
-		err := s.db.Raw(`
-			SELECT column_name, data_type
-			FROM information_schema.columns
-			WHERE table_schema = 'public' AND table_name = ?
-		`, table).Scan(&columns).Error
-		if err != nil {
-			return "", err
-		}
-
-		schema += fmt.Sprintf("Table %s:\n", table)
-		for _, col := range columns {
-			schema += fmt.Sprintf("  %s (%s)\n", col.ColumnName, col.DataType)
-		}
-		schema += "\n"
-	}
-
-	return schema, nil
-}
-
 func (s *service) CreateUser(ctx context.Context, email, passwordHash string) (*models.User, error) {
 	user := &models.User{
 		Email:        email,
@@ -88,11 +86,50 @@ func (s *service) GetUserByEmail(ctx context.Context, email string) (*models.Use
 	return &user, nil
 }
 
-func (s *service) CreateProject(ctx context.Context, userID int, name string) (*models.Project, error) {
-	project := &models.Project{
-		Name:   name,
-		UserID: userID,
+func (s *service) GetUserByOIDCSubject(ctx context.Context, issuer, subject string) (*models.User, error) {
+	var user models.User
+	result := s.db.WithContext(ctx).Where("oidc_issuer = ? AND oidc_subject = ?", issuer, subject).First(&user)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &user, nil
+}
+
+func (s *service) LinkOIDCUser(ctx context.Context, issuer, subject, email string) (*models.User, error) {
+	var user models.User
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var users []models.User
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("LOWER(email) = ?", strings.ToLower(email)).
+			Limit(1).Find(&users).Error
+		if err != nil {
+			return err
+		}
+		if len(users) == 0 {
+			user = models.User{Email: email, OIDCIssuer: &issuer, OIDCSubject: &subject}
+			return tx.Create(&user).Error
+		}
+
+		user = users[0]
+		if user.OIDCSubject != nil {
+			return gorm.ErrDuplicatedKey
+		}
+		user.OIDCIssuer, user.OIDCSubject, user.PasswordHash = &issuer, &subject, ""
+		err = tx.Model(&user).Select("oidc_issuer", "oidc_subject", "password_hash").Updates(&user).Error
+		if err != nil {
+			return err
+		}
+		return tx.Model(&models.Session{}).
+			Where("user_id = ? AND revoked_at IS NULL", user.ID).
+			Update("revoked_at", time.Now()).Error
+	})
+	if err != nil {
+		return nil, err
 	}
+	return &user, nil
+}
+
+func (s *service) CreateProject(ctx context.Context, project *models.Project) (*models.Project, error) {
 	result := s.db.WithContext(ctx).Create(project)
 	if result.Error != nil {
 		return nil, result.Error
@@ -100,9 +137,51 @@ func (s *service) CreateProject(ctx context.Context, userID int, name string) (*
 	return project, nil
 }
 
+func (s *service) GetProject(ctx context.Context, projectID int) (*models.Project, error) {
+	var project models.Project
+	result := s.db.WithContext(ctx).First(&project, projectID)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &project, nil
+}
+
+// ProjectSettings are the columns of a project that UpdateProject changes.
+var ProjectSettings = []string{
+	"name", "schema_format", "prompt_max_tables", "prompt_token_budget",
+	"llm_provider", "llm_model", "llm_temperature", "llm_max_tokens", "repair_attempts",
+	"max_query_cost", "max_query_rows", "cost_guard_action", "cost_guard_limit",
+}
+
+func (s *service) UpdateProject(ctx context.Context, project *models.Project, settings []string) (*models.Project, error) {
+	var columns []string
+	for _, column := range ProjectSettings {
+		for _, setting := range settings {
+			if setting == column {
+				columns = append(columns, column)
+				break
+			}
+		}
+	}
+	if len(columns) == 0 {
+		return project, nil
+	}
+
+	result := s.db.WithContext(ctx).Model(project).Select(columns).Updates(project)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return project, nil
+}
+
 func (s *service) ListProjects(ctx context.Context, userID int) (*[]models.Project, error) {
 	var projects []models.Project
-	results := s.db.WithContext(ctx).Where("user_id = ?", userID).Limit(10).Find(&projects)
+	// projects in an organization are only listed for its members
+	results := s.db.WithContext(ctx).
+		Where("organization_id IS NULL AND (user_id = ? OR id IN (?))", userID,
+			s.db.Model(&models.ProjectMember{}).Select("project_id").Where("user_id = ?", userID)).
+		Or("organization_id IN (?)", s.db.Model(&models.OrganizationMember{}).Select("organization_id").Where("user_id = ?", userID)).
+		Order("id").Limit(10).Find(&projects)
 	if results.Error != nil {
 		return nil, results.Error
 	}
@@ -0,0 +1,198 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+
+	"github.com/cr34t1ve/hoprun/pkg/models"
+)
+
+const (
+	DefaultStatementTimeout = 30 * time.Second
+	DefaultMaxRows          = 10000
+)
+
+// QueryOptions bounds a user query. Zero values use the defaults.
+type QueryOptions struct {
+	StatementTimeout time.Duration
+	MaxRows          int
+}
+
+func (o QueryOptions) withDefaults() QueryOptions {
+	if o.StatementTimeout <= 0 {
+		o.StatementTimeout = DefaultStatementTimeout
+	}
+	if o.MaxRows <= 0 {
+		o.MaxRows = DefaultMaxRows
+	}
+	return o
+}
+
+const (
+	limitPrefix   = "SELECT * FROM (\n"
+	explainPrefix = "EXPLAIN (FORMAT JSON) "
+)
+
+// limitQuery wraps query in a LIMIT one row past maxRows so the database
+// stops producing rows early; the extra row only signals that the results
+// were truncated. The newline keeps a trailing line comment from swallowing
+// the closing parenthesis.
+func limitQuery(query string, maxRows int) string {
+	return fmt.Sprintf("%s%s\n) AS hoprun_result LIMIT %d", limitPrefix, query, maxRows+1)
+}
+
+// unwrapPosition makes the error position Postgres reports, counted in
+// characters of the statement that was sent, relative to the user query
+// again by taking off the prefix that was added in front of it. A position
+// inside the prefix is dropped.
+func unwrapPosition(err error, prefix string) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Position <= 0 {
+		return err
+	}
+	pgErr.Position -= int32(utf8.RuneCountInString(prefix))
+	if pgErr.Position < 0 {
+		pgErr.Position = 0
+	}
+	return err
+}
+
+// readOnly runs fn inside a READ ONLY transaction with the statement timeout
+// from opts. The transaction is always rolled back, so even a statement that
+// slips past validation cannot change data. SQLite and DuckDB files are
+// opened read-only as a whole instead.
+func (s *service) readOnly(ctx context.Context, opts QueryOptions, fn func(tx *gorm.DB) error) error {
+	dialect := s.Dialect()
+
+	// the server-side timeout is the one that should fire; the context
+	// deadline only covers a server that stops responding. Embedded
+	// databases have no server-side timeout and are interrupted through
+	// the context.
+	timeout := opts.StatementTimeout
+	if !models.IsFileDialect(dialect) {
+		timeout += 5 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	txOptions := &sql.TxOptions{ReadOnly: true}
+	if dialect == models.DialectDuckDB {
+		// the driver refuses read-only transactions
+		txOptions = nil
+	}
+	tx := s.db.WithContext(ctx).Begin(txOptions)
+	if tx.Error != nil {
+		return tx.Error
+	}
+	defer tx.Rollback()
+
+	var err error
+	switch dialect {
+	case models.DialectMySQL:
+		err = prepareMySQLSession(tx, opts)
+	case models.DialectPostgres:
+		err = tx.Exec("SELECT set_config('statement_timeout', ?, true)", strconv.FormatInt(opts.StatementTimeout.Milliseconds(), 10)).Error
+	}
+	if err != nil {
+		return err
+	}
+	return fn(tx)
+}
+
+// prepareMySQLSession sets the statement timeout, which MySQL and MariaDB
+// name differently, and restores the default quoting rules sqlguard
+// tokenizes with. Session variables outlive the transaction, so they are set
+// again for every query.
+func prepareMySQLSession(tx *gorm.DB, opts QueryOptions) error {
+	var sqlMode, version string
+	if err := tx.Raw("SELECT @@SESSION.sql_mode, VERSION()").Row().Scan(&sqlMode, &version); err != nil {
+		return err
+	}
+
+	var modes []string
+	for _, mode := range strings.Split(sqlMode, ",") {
+		if mode != "" && mode != "NO_BACKSLASH_ESCAPES" && mode != "ANSI_QUOTES" && mode != "ANSI" {
+			modes = append(modes, mode)
+		}
+	}
+	if err := tx.Exec("SET SESSION sql_mode = ?", strings.Join(modes, ",")).Error; err != nil {
+		return err
+	}
+
+	// max_execution_time only applies to SELECT statements, which is all
+	// that is allowed through
+	if strings.Contains(version, "MariaDB") {
+		return tx.Exec("SET SESSION max_statement_time = ?", opts.StatementTimeout.Seconds()).Error
+	}
+	return tx.Exec("SET SESSION max_execution_time = ?", opts.StatementTimeout.Milliseconds()).Error
+}
+
+// ExecuteReadOnlyQuery runs a user query and reports whether its results were
+// truncated at opts.MaxRows. query must be a single statement without a
+// trailing semicolon.
+func (s *service) ExecuteReadOnlyQuery(ctx context.Context, query string, opts QueryOptions) ([]map[string]interface{}, bool, error) {
+	opts = opts.withDefaults()
+
+	var results []map[string]interface{}
+	err := s.readOnly(ctx, opts, func(tx *gorm.DB) error {
+		return tx.Raw(limitQuery(query, opts.MaxRows)).Scan(&results).Error
+	})
+	if err != nil {
+		return nil, false, unwrapPosition(err, limitPrefix)
+	}
+	if len(results) > opts.MaxRows {
+		return results[:opts.MaxRows], true, nil
+	}
+	return results, false, nil
+}
+
+// ExplainReadOnlyQuery returns the plan of a user query exactly as
+// ExecuteReadOnlyQuery would run it, row limit included. The query is
+// planned but not executed. Postgres and MySQL plans are JSON; SQLite's
+// EXPLAIN QUERY PLAN rows are returned as a JSON array and DuckDB's plan is
+// the text it prints.
+func (s *service) ExplainReadOnlyQuery(ctx context.Context, query string, opts QueryOptions) ([]byte, error) {
+	opts = opts.withDefaults()
+	query = limitQuery(query, opts.MaxRows)
+
+	var plan []byte
+	err := s.readOnly(ctx, opts, func(tx *gorm.DB) error {
+		var err error
+		switch s.Dialect() {
+		case models.DialectMySQL:
+			err = tx.Raw("EXPLAIN FORMAT=JSON " + query).Row().Scan(&plan)
+		case models.DialectSQLite:
+			var steps []sqlitePlanStep
+			if err = tx.Raw("EXPLAIN QUERY PLAN " + query).Scan(&steps).Error; err == nil {
+				plan, err = json.Marshal(steps)
+			}
+		case models.DialectDuckDB:
+			var key string
+			err = tx.Raw("EXPLAIN "+query).Row().Scan(&key, &plan)
+		default:
+			err = tx.Raw(explainPrefix + query).Row().Scan(&plan)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, unwrapPosition(err, explainPrefix+limitPrefix)
+	}
+	return plan, nil
+}
+
+// sqlitePlanStep is a row of SQLite's EXPLAIN QUERY PLAN output.
+type sqlitePlanStep struct {
+	ID     int    `json:"id" gorm:"column:id"`
+	Parent int    `json:"parent" gorm:"column:parent"`
+	Detail string `json:"detail" gorm:"column:detail"`
+}
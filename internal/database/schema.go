@@ -0,0 +1,345 @@
+package database
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/cr34t1ve/hoprun/pkg/models"
+)
+
+// SchemaOptions controls which parts of a database are introspected.
+// IncludeSchemas is an allow-list of schema names; when empty every
+// non-system schema is included. ExcludeSchemas is applied afterwards.
+// SampleValues enables collecting common values of low-cardinality text
+// columns from the planner statistics; it only applies to Postgres. SQLite
+// ignores the schema lists, as only the main database is introspected.
+type SchemaOptions struct {
+	IncludeSchemas []string
+	ExcludeSchemas []string
+	SampleValues   bool
+}
+
+const (
+	// sampleMaxDistinct is the largest estimated number of distinct values a
+	// text column may have to be considered low-cardinality
+	sampleMaxDistinct = 20
+	sampleValueLimit  = 10
+	sampleValueMaxLen = 64
+)
+
+// relkinds covers ordinary and partitioned tables, views, materialized views
+// and foreign tables. Materialized views only show up in pg_class/pg_matviews,
+// not information_schema, which is why introspection reads pg_catalog.
+const relkinds = "('r', 'p', 'v', 'm', 'f')"
+
+var tableKinds = map[string]string{
+	"r": models.TableKindTable,
+	"p": models.TableKindTable,
+	"v": models.TableKindView,
+	"m": models.TableKindMaterializedView,
+	"f": models.TableKindForeignTable,
+}
+
+// schemaFilter returns a SQL condition restricting the namespace column to the
+// schemas selected by opts, along with its bind parameters.
+func schemaFilter(column string, opts SchemaOptions) (string, []interface{}) {
+	conditions := []string{
+		column + " NOT IN ('pg_catalog', 'information_schema')",
+		column + " NOT LIKE 'pg\\_%'",
+	}
+	var args []interface{}
+	if len(opts.IncludeSchemas) > 0 {
+		conditions = append(conditions, column+" IN ?")
+		args = append(args, opts.IncludeSchemas)
+	}
+	if len(opts.ExcludeSchemas) > 0 {
+		conditions = append(conditions, column+" NOT IN ?")
+		args = append(args, opts.ExcludeSchemas)
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+func (s *service) GetDatabaseSchema(opts SchemaOptions) (*models.Schema, error) {
+	switch s.Dialect() {
+	case models.DialectMySQL:
+		return s.getMySQLSchema(opts)
+	case models.DialectSQLite:
+		return s.getSQLiteSchema()
+	case models.DialectDuckDB:
+		return s.getDuckDBSchema(opts)
+	}
+
+	filter, args := schemaFilter("n.nspname", opts)
+
+	var tables []struct {
+		TableSchema string `gorm:"column:table_schema"`
+		TableName   string `gorm:"column:table_name"`
+		TableKind   string `gorm:"column:table_kind"`
+		Comment     string `gorm:"column:comment"`
+	}
+	err := s.db.Raw(`
+	SELECT n.nspname AS table_schema, c.relname AS table_name, c.relkind::text AS table_kind,
+		COALESCE(obj_description(c.oid, 'pg_class'), '') AS comment
+	FROM pg_catalog.pg_class c
+	JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+	WHERE c.relkind IN `+relkinds+` AND NOT c.relispartition AND `+filter+`
+	ORDER BY n.nspname, c.relname
+	`, args...).Scan(&tables).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var columns []struct {
+		TableSchema string `gorm:"column:table_schema"`
+		TableName   string `gorm:"column:table_name"`
+		ColumnName  string `gorm:"column:column_name"`
+		DataType    string `gorm:"column:data_type"`
+		NotNull     bool   `gorm:"column:not_null"`
+		Comment     string `gorm:"column:comment"`
+		EnumValues  string `gorm:"column:enum_values"`
+	}
+	err = s.db.Raw(`
+	SELECT n.nspname AS table_schema, c.relname AS table_name, a.attname AS column_name,
+		format_type(a.atttypid, a.atttypmod) AS data_type, a.attnotnull AS not_null,
+		COALESCE(col_description(c.oid, a.attnum), '') AS comment,
+		COALESCE((
+			SELECT json_agg(e.enumlabel ORDER BY e.enumsortorder)
+			FROM pg_catalog.pg_enum e WHERE e.enumtypid = a.atttypid
+		)::text, '') AS enum_values
+	FROM pg_catalog.pg_attribute a
+	JOIN pg_catalog.pg_class c ON c.oid = a.attrelid
+	JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+	WHERE a.attnum > 0 AND NOT a.attisdropped AND c.relkind IN `+relkinds+` AND `+filter+`
+	ORDER BY n.nspname, c.relname, a.attnum
+	`, args...).Scan(&columns).Error
+	if err != nil {
+		return nil, err
+	}
+
+	constraints, err := s.getTableConstraints(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	schema := &models.Schema{Tables: make([]models.Table, len(tables))}
+	byName := make(map[string]*models.Table, len(tables))
+	for i, t := range tables {
+		schema.Tables[i] = models.Table{
+			Schema:  t.TableSchema,
+			Name:    t.TableName,
+			Kind:    tableKinds[t.TableKind],
+			Comment: t.Comment,
+		}
+		byName[schema.Tables[i].QualifiedName()] = &schema.Tables[i]
+	}
+
+	for _, col := range columns {
+		table, ok := byName[qualifiedName(col.TableSchema, col.TableName)]
+		if !ok {
+			continue
+		}
+		column := models.Column{
+			Name:     col.ColumnName,
+			DataType: col.DataType,
+			Nullable: !col.NotNull,
+			Comment:  col.Comment,
+		}
+		if col.EnumValues != "" {
+			if err := json.Unmarshal([]byte(col.EnumValues), &column.EnumValues); err != nil {
+				return nil, err
+			}
+		}
+		table.Columns = append(table.Columns, column)
+	}
+
+	for _, con := range constraints {
+		table, ok := byName[qualifiedName(con.TableSchema, con.TableName)]
+		if !ok {
+			continue
+		}
+		switch con.ConstraintType {
+		case "p":
+			table.PrimaryKey = splitColumns(con.Columns)
+		case "u":
+			table.UniqueKeys = append(table.UniqueKeys, splitColumns(con.Columns))
+		case "f":
+			table.ForeignKeys = append(table.ForeignKeys, models.ForeignKey{
+				Columns:           splitColumns(con.Columns),
+				ReferencedSchema:  con.ForeignSchema,
+				ReferencedTable:   con.ForeignTable,
+				ReferencedColumns: splitColumns(con.ForeignColumns),
+			})
+		}
+	}
+
+	if opts.SampleValues {
+		if err := s.addSampleValues(schema, byName, opts); err != nil {
+			return nil, err
+		}
+	}
+
+	return schema, nil
+}
+
+// addSampleValues fills in the most common values of low-cardinality text
+// columns. Values come from pg_stats rather than scanning the tables, so
+// columns of tables that have never been analyzed get no samples.
+func (s *service) addSampleValues(schema *models.Schema, byName map[string]*models.Table, opts SchemaOptions) error {
+	filter, args := schemaFilter("n.nspname", opts)
+
+	var samples []struct {
+		TableSchema  string `gorm:"column:table_schema"`
+		TableName    string `gorm:"column:table_name"`
+		ColumnName   string `gorm:"column:column_name"`
+		SampleValues string `gorm:"column:sample_values"`
+	}
+	err := s.db.Raw(`
+	SELECT st.schemaname AS table_schema, st.tablename AS table_name, st.attname AS column_name,
+		array_to_json(st.most_common_vals::text::text[])::text AS sample_values
+	FROM pg_catalog.pg_stats st
+	JOIN pg_catalog.pg_namespace n ON n.nspname = st.schemaname
+	JOIN pg_catalog.pg_class c ON c.relnamespace = n.oid AND c.relname = st.tablename
+	JOIN pg_catalog.pg_attribute a ON a.attrelid = c.oid AND a.attname = st.attname
+	JOIN pg_catalog.pg_type t ON t.oid = a.atttypid
+	WHERE NOT st.inherited AND t.typcategory = 'S' AND st.most_common_vals IS NOT NULL
+		AND st.n_distinct > 0 AND st.n_distinct <= ? AND `+filter+`
+	`, append([]interface{}{sampleMaxDistinct}, args...)...).Scan(&samples).Error
+	if err != nil {
+		return err
+	}
+
+	for _, sample := range samples {
+		table, ok := byName[qualifiedName(sample.TableSchema, sample.TableName)]
+		if !ok {
+			continue
+		}
+		var values []string
+		if err := json.Unmarshal([]byte(sample.SampleValues), &values); err != nil {
+			return err
+		}
+		for i := range table.Columns {
+			if table.Columns[i].Name != sample.ColumnName {
+				continue
+			}
+			for _, value := range values {
+				if len(table.Columns[i].SampleValues) == sampleValueLimit {
+					break
+				}
+				if len(value) <= sampleValueMaxLen {
+					table.Columns[i].SampleValues = append(table.Columns[i].SampleValues, value)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func qualifiedName(schema, table string) string {
+	return schema + "." + table
+}
+
+func splitColumns(columns string) []string {
+	if columns == "" {
+		return nil
+	}
+	return strings.Split(columns, ", ")
+}
+
+type tableConstraint struct {
+	TableSchema    string `gorm:"column:table_schema"`
+	TableName      string `gorm:"column:table_name"`
+	ConstraintType string `gorm:"column:constraint_type"`
+	Columns        string `gorm:"column:columns"`
+	ForeignSchema  string `gorm:"column:foreign_schema"`
+	ForeignTable   string `gorm:"column:foreign_table"`
+	ForeignColumns string `gorm:"column:foreign_columns"`
+}
+
+// getTableConstraints loads the primary key, unique and foreign key
+// constraints of every selected table in one round trip. Column lists are
+// comma separated and keep the constraint's column order so composite keys
+// line up with their referenced columns.
+func (s *service) getTableConstraints(opts SchemaOptions) ([]tableConstraint, error) {
+	filter, args := schemaFilter("n.nspname", opts)
+
+	var rows []tableConstraint
+	err := s.db.Raw(`
+	SELECT n.nspname AS table_schema,
+		cl.relname AS table_name,
+		con.contype::text AS constraint_type,
+		array_to_string(ARRAY(
+			SELECT a.attname FROM unnest(con.conkey) WITH ORDINALITY AS k(attnum, ord)
+			JOIN pg_catalog.pg_attribute a ON a.attrelid = con.conrelid AND a.attnum = k.attnum
+			ORDER BY k.ord
+		), ', ') AS columns,
+		COALESCE(fn.nspname, '') AS foreign_schema,
+		COALESCE(fcl.relname, '') AS foreign_table,
+		COALESCE(array_to_string(ARRAY(
+			SELECT a.attname FROM unnest(con.confkey) WITH ORDINALITY AS k(attnum, ord)
+			JOIN pg_catalog.pg_attribute a ON a.attrelid = con.confrelid AND a.attnum = k.attnum
+			ORDER BY k.ord
+		), ', '), '') AS foreign_columns
+	FROM pg_catalog.pg_constraint con
+	JOIN pg_catalog.pg_class cl ON cl.oid = con.conrelid
+	JOIN pg_catalog.pg_namespace n ON n.oid = cl.relnamespace
+	LEFT JOIN pg_catalog.pg_class fcl ON fcl.oid = con.confrelid
+	LEFT JOIN pg_catalog.pg_namespace fn ON fn.oid = fcl.relnamespace
+	WHERE con.contype IN ('p', 'u', 'f') AND `+filter+`
+	ORDER BY n.nspname, cl.relname, con.contype, con.conname
+	`, args...).Scan(&rows).Error
+	return rows, err
+}
+
+// GetSchemaFingerprint returns a hash over the catalog entries that make up the
+// introspected schema. It changes whenever a relation, column or constraint is
+// created, dropped, renamed, retyped or rewritten, or when comments and enum
+// labels change, so it can be used to decide whether a cached schema is still
+// current without re-running the full introspection. Sampled values follow
+// table statistics and are only refreshed along with the rest of the schema.
+func (s *service) GetSchemaFingerprint(opts SchemaOptions) (string, error) {
+	switch s.Dialect() {
+	case models.DialectMySQL:
+		return s.getMySQLSchemaFingerprint(opts)
+	case models.DialectSQLite:
+		return s.getSQLiteSchemaFingerprint()
+	case models.DialectDuckDB:
+		return s.getDuckDBSchemaFingerprint(opts)
+	}
+
+	filter, args := schemaFilter("n.nspname", opts)
+
+	// the filter appears once per catalog below
+	var params []interface{}
+	for i := 0; i < 3; i++ {
+		params = append(params, args...)
+	}
+
+	var fingerprint string
+	err := s.db.Raw(`
+	SELECT COALESCE(md5(string_agg(entry, ',' ORDER BY entry)), '')
+	FROM (
+		SELECT c.oid::text || ':' || c.relfilenode::text || ':' || c.relkind::text || ':' || n.nspname || '.' || c.relname || ':' ||
+			a.attnum::text || ':' || a.attname || ':' || a.atttypid::text || ':' || a.atttypmod::text || ':' || a.attnotnull::text AS entry
+		FROM pg_catalog.pg_class c
+		JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+		JOIN pg_catalog.pg_attribute a ON a.attrelid = c.oid
+		WHERE c.relkind IN `+relkinds+` AND a.attnum > 0 AND NOT a.attisdropped AND `+filter+`
+		UNION ALL
+		SELECT 'con:' || con.oid::text || ':' || con.conrelid::text
+		FROM pg_catalog.pg_constraint con
+		JOIN pg_catalog.pg_namespace n ON n.oid = con.connamespace
+		WHERE `+filter+`
+		UNION ALL
+		SELECT 'desc:' || d.objoid::text || ':' || d.objsubid::text || ':' || md5(d.description)
+		FROM pg_catalog.pg_description d
+		JOIN pg_catalog.pg_class c ON c.oid = d.objoid AND d.classoid = 'pg_catalog.pg_class'::regclass
+		JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+		WHERE `+filter+`
+		UNION ALL
+		SELECT 'enum:' || e.enumtypid::text || ':' || e.enumsortorder::text || ':' || e.enumlabel
+		FROM pg_catalog.pg_enum e
+	) entries
+	`, params...).Scan(&fingerprint).Error
+	return fingerprint, err
+}
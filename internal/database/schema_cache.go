@@ -0,0 +1,92 @@
+package database
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cr34t1ve/hoprun/pkg/models"
+)
+
+type SchemaCacheStats struct {
+	ConnectionID  int       `json:"connection_id"`
+	Hits          int64     `json:"hits"`
+	Misses        int64     `json:"misses"`
+	Fingerprint   string    `json:"fingerprint"`
+	LastRefreshed time.Time `json:"last_refreshed"`
+}
+
+// SchemaCache keeps the introspected schema of user databases keyed by
+// connection ID. A cached schema is reused for as long as the database's
+// schema fingerprint stays the same.
+type SchemaCache interface {
+	GetSchema(connectionID int, dbService Service, opts SchemaOptions) (*models.Schema, error)
+	Stats(connectionID int) (*SchemaCacheStats, bool)
+	Invalidate(connectionID int)
+}
+
+type schemaCacheEntry struct {
+	schema *models.Schema
+	stats  SchemaCacheStats
+}
+
+type schemaCache struct {
+	mu      sync.Mutex
+	entries map[int]*schemaCacheEntry
+}
+
+func NewSchemaCache() SchemaCache {
+	return &schemaCache{entries: make(map[int]*schemaCacheEntry)}
+}
+
+func (c *schemaCache) GetSchema(connectionID int, dbService Service, opts SchemaOptions) (*models.Schema, error) {
+	fingerprint, err := dbService.GetSchemaFingerprint(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	entry, ok := c.entries[connectionID]
+	if ok && entry.stats.Fingerprint == fingerprint {
+		entry.stats.Hits++
+		schema := entry.schema
+		c.mu.Unlock()
+		return schema, nil
+	}
+	c.mu.Unlock()
+
+	schema, err := dbService.GetDatabaseSchema(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok = c.entries[connectionID]
+	if !ok {
+		entry = &schemaCacheEntry{stats: SchemaCacheStats{ConnectionID: connectionID}}
+		c.entries[connectionID] = entry
+	}
+	entry.schema = schema
+	entry.stats.Misses++
+	entry.stats.Fingerprint = fingerprint
+	entry.stats.LastRefreshed = time.Now()
+
+	return schema, nil
+}
+
+func (c *schemaCache) Stats(connectionID int) (*SchemaCacheStats, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[connectionID]
+	if !ok {
+		return nil, false
+	}
+	stats := entry.stats
+	return &stats, true
+}
+
+func (c *schemaCache) Invalidate(connectionID int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, connectionID)
+}
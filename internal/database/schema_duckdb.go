@@ -0,0 +1,180 @@
+package database
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/cr34t1ve/hoprun/pkg/models"
+)
+
+// duckDBReferences reads the target of a foreign key from its constraint
+// text, e.g. FOREIGN KEY (user_id) REFERENCES users(id), since DuckDB 1.0
+// has no columns for it.
+var duckDBReferences = regexp.MustCompile(`REFERENCES\s+([^\s(]+)\s*\(([^)]*)\)`)
+
+// getDuckDBSchema introspects a DuckDB database through its duckdb_tables,
+// duckdb_views, duckdb_columns and duckdb_constraints catalog functions.
+func (s *service) getDuckDBSchema(opts SchemaOptions) (*models.Schema, error) {
+	filter, args := schemaFilter("schema_name", opts)
+
+	var tables []struct {
+		TableSchema string `gorm:"column:table_schema"`
+		TableName   string `gorm:"column:table_name"`
+		TableKind   string `gorm:"column:table_kind"`
+		Comment     string `gorm:"column:comment"`
+	}
+	err := s.db.Raw(`
+	SELECT schema_name AS table_schema, table_name, 'table' AS table_kind, COALESCE(comment, '') AS comment
+	FROM duckdb_tables() WHERE NOT internal AND database_name = current_database() AND `+filter+`
+	UNION ALL
+	SELECT schema_name, view_name, 'view', COALESCE(comment, '')
+	FROM duckdb_views() WHERE NOT internal AND database_name = current_database() AND `+filter+`
+	ORDER BY 1, 2
+	`, append(args, args...)...).Scan(&tables).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var columns []struct {
+		TableSchema string `gorm:"column:table_schema"`
+		TableName   string `gorm:"column:table_name"`
+		ColumnName  string `gorm:"column:column_name"`
+		DataType    string `gorm:"column:data_type"`
+		Nullable    bool   `gorm:"column:nullable"`
+		Comment     string `gorm:"column:comment"`
+	}
+	err = s.db.Raw(`
+	SELECT schema_name AS table_schema, table_name, column_name, data_type, is_nullable AS nullable,
+		COALESCE(comment, '') AS comment
+	FROM duckdb_columns() WHERE NOT internal AND database_name = current_database() AND `+filter+`
+	ORDER BY schema_name, table_name, column_index
+	`, args...).Scan(&columns).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var constraints []struct {
+		TableSchema    string `gorm:"column:table_schema"`
+		TableName      string `gorm:"column:table_name"`
+		ConstraintType string `gorm:"column:constraint_type"`
+		Columns        string `gorm:"column:columns"`
+		Text           string `gorm:"column:text"`
+	}
+	err = s.db.Raw(`
+	SELECT schema_name AS table_schema, table_name, constraint_type,
+		array_to_string(constraint_column_names, ', ') AS columns, constraint_text AS text
+	FROM duckdb_constraints()
+	WHERE database_name = current_database() AND constraint_type IN ('PRIMARY KEY', 'UNIQUE', 'FOREIGN KEY') AND `+filter+`
+	ORDER BY schema_name, table_name, constraint_index
+	`, args...).Scan(&constraints).Error
+	if err != nil {
+		return nil, err
+	}
+
+	schema := &models.Schema{Tables: make([]models.Table, len(tables))}
+	byName := make(map[string]*models.Table, len(tables))
+	for i, t := range tables {
+		kind := models.TableKindTable
+		if t.TableKind == "view" {
+			kind = models.TableKindView
+		}
+		schema.Tables[i] = models.Table{
+			Schema:  t.TableSchema,
+			Name:    t.TableName,
+			Kind:    kind,
+			Comment: t.Comment,
+		}
+		byName[schema.Tables[i].QualifiedName()] = &schema.Tables[i]
+	}
+
+	for _, col := range columns {
+		table, ok := byName[qualifiedName(col.TableSchema, col.TableName)]
+		if !ok {
+			continue
+		}
+		table.Columns = append(table.Columns, models.Column{
+			Name:       col.ColumnName,
+			DataType:   col.DataType,
+			Nullable:   col.Nullable,
+			Comment:    col.Comment,
+			EnumValues: enumValues(col.DataType),
+		})
+	}
+
+	for _, con := range constraints {
+		table, ok := byName[qualifiedName(con.TableSchema, con.TableName)]
+		if !ok {
+			continue
+		}
+		switch con.ConstraintType {
+		case "PRIMARY KEY":
+			table.PrimaryKey = splitColumns(con.Columns)
+		case "UNIQUE":
+			table.UniqueKeys = append(table.UniqueKeys, splitColumns(con.Columns))
+		case "FOREIGN KEY":
+			match := duckDBReferences.FindStringSubmatch(con.Text)
+			if match == nil {
+				continue
+			}
+			referencedSchema, referencedTable := con.TableSchema, unquoteIdentifier(match[1])
+			if before, after, ok := strings.Cut(match[1], "."); ok {
+				referencedSchema, referencedTable = unquoteIdentifier(before), unquoteIdentifier(after)
+			}
+			var referencedColumns []string
+			for _, column := range strings.Split(match[2], ",") {
+				referencedColumns = append(referencedColumns, unquoteIdentifier(strings.TrimSpace(column)))
+			}
+			table.ForeignKeys = append(table.ForeignKeys, models.ForeignKey{
+				Columns:           splitColumns(con.Columns),
+				ReferencedSchema:  referencedSchema,
+				ReferencedTable:   referencedTable,
+				ReferencedColumns: referencedColumns,
+			})
+		}
+	}
+
+	return schema, nil
+}
+
+func unquoteIdentifier(name string) string {
+	if len(name) >= 2 && name[0] == '"' && name[len(name)-1] == '"' {
+		return strings.ReplaceAll(name[1:len(name)-1], `""`, `"`)
+	}
+	return name
+}
+
+// getDuckDBSchemaFingerprint hashes the CREATE statements DuckDB generates
+// from its catalog, together with the column definitions and comments.
+func (s *service) getDuckDBSchemaFingerprint(opts SchemaOptions) (string, error) {
+	filter, args := schemaFilter("schema_name", opts)
+
+	var params []interface{}
+	for i := 0; i < 3; i++ {
+		params = append(params, args...)
+	}
+
+	var entries []string
+	err := s.db.Raw(`
+	SELECT concat_ws(':', 'table', schema_name, table_name, sql, comment) AS entry
+	FROM duckdb_tables() WHERE NOT internal AND database_name = current_database() AND `+filter+`
+	UNION ALL
+	SELECT concat_ws(':', 'view', schema_name, view_name, sql, comment)
+	FROM duckdb_views() WHERE NOT internal AND database_name = current_database() AND `+filter+`
+	UNION ALL
+	SELECT concat_ws(':', 'col', schema_name, table_name, column_index, column_name, data_type, is_nullable, comment)
+	FROM duckdb_columns() WHERE NOT internal AND database_name = current_database() AND `+filter+`
+	`, params...).Scan(&entries).Error
+	if err != nil {
+		return "", err
+	}
+	if len(entries) == 0 {
+		return "", nil
+	}
+
+	sort.Strings(entries)
+	sum := md5.Sum([]byte(strings.Join(entries, "\n")))
+	return hex.EncodeToString(sum[:]), nil
+}
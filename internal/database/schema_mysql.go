@@ -0,0 +1,237 @@
+package database
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"sort"
+	"strings"
+
+	"github.com/cr34t1ve/hoprun/pkg/models"
+)
+
+// mysqlSchemaFilter is schemaFilter for MySQL, where a schema is a database.
+// Without an allow-list only the connection's own database is introspected,
+// since one server often hosts many unrelated databases.
+func mysqlSchemaFilter(column string, opts SchemaOptions) (string, []interface{}) {
+	conditions := []string{
+		column + " NOT IN ('mysql', 'information_schema', 'performance_schema', 'sys')",
+	}
+	var args []interface{}
+	if len(opts.IncludeSchemas) > 0 {
+		conditions = append(conditions, column+" IN ?")
+		args = append(args, opts.IncludeSchemas)
+	} else {
+		conditions = append(conditions, column+" = DATABASE()")
+	}
+	if len(opts.ExcludeSchemas) > 0 {
+		conditions = append(conditions, column+" NOT IN ?")
+		args = append(args, opts.ExcludeSchemas)
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+// getMySQLSchema introspects a MySQL or MariaDB database through
+// information_schema. Sample values are not collected: MySQL keeps no value
+// statistics to read them from without scanning the tables.
+func (s *service) getMySQLSchema(opts SchemaOptions) (*models.Schema, error) {
+	filter, args := mysqlSchemaFilter("TABLE_SCHEMA", opts)
+
+	var tables []struct {
+		TableSchema string `gorm:"column:table_schema"`
+		TableName   string `gorm:"column:table_name"`
+		TableType   string `gorm:"column:table_type"`
+		Comment     string `gorm:"column:comment"`
+	}
+	err := s.db.Raw(`
+	SELECT TABLE_SCHEMA AS table_schema, TABLE_NAME AS table_name, TABLE_TYPE AS table_type,
+		COALESCE(TABLE_COMMENT, '') AS comment
+	FROM information_schema.TABLES
+	WHERE TABLE_TYPE IN ('BASE TABLE', 'VIEW') AND `+filter+`
+	ORDER BY TABLE_SCHEMA, TABLE_NAME
+	`, args...).Scan(&tables).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var columns []struct {
+		TableSchema string `gorm:"column:table_schema"`
+		TableName   string `gorm:"column:table_name"`
+		ColumnName  string `gorm:"column:column_name"`
+		ColumnType  string `gorm:"column:column_type"`
+		IsNullable  string `gorm:"column:is_nullable"`
+		Comment     string `gorm:"column:comment"`
+	}
+	err = s.db.Raw(`
+	SELECT TABLE_SCHEMA AS table_schema, TABLE_NAME AS table_name, COLUMN_NAME AS column_name,
+		COLUMN_TYPE AS column_type, IS_NULLABLE AS is_nullable, COALESCE(COLUMN_COMMENT, '') AS comment
+	FROM information_schema.COLUMNS
+	WHERE `+filter+`
+	ORDER BY TABLE_SCHEMA, TABLE_NAME, ORDINAL_POSITION
+	`, args...).Scan(&columns).Error
+	if err != nil {
+		return nil, err
+	}
+
+	constraintFilter, constraintArgs := mysqlSchemaFilter("k.TABLE_SCHEMA", opts)
+	var keyColumns []struct {
+		TableSchema      string `gorm:"column:table_schema"`
+		TableName        string `gorm:"column:table_name"`
+		ConstraintName   string `gorm:"column:constraint_name"`
+		ConstraintType   string `gorm:"column:constraint_type"`
+		ColumnName       string `gorm:"column:column_name"`
+		ReferencedSchema string `gorm:"column:referenced_schema"`
+		ReferencedTable  string `gorm:"column:referenced_table"`
+		ReferencedColumn string `gorm:"column:referenced_column"`
+	}
+	err = s.db.Raw(`
+	SELECT k.TABLE_SCHEMA AS table_schema, k.TABLE_NAME AS table_name, k.CONSTRAINT_NAME AS constraint_name,
+		t.CONSTRAINT_TYPE AS constraint_type, k.COLUMN_NAME AS column_name,
+		COALESCE(k.REFERENCED_TABLE_SCHEMA, '') AS referenced_schema,
+		COALESCE(k.REFERENCED_TABLE_NAME, '') AS referenced_table,
+		COALESCE(k.REFERENCED_COLUMN_NAME, '') AS referenced_column
+	FROM information_schema.KEY_COLUMN_USAGE k
+	JOIN information_schema.TABLE_CONSTRAINTS t
+		ON t.CONSTRAINT_SCHEMA = k.CONSTRAINT_SCHEMA AND t.CONSTRAINT_NAME = k.CONSTRAINT_NAME
+		AND t.TABLE_SCHEMA = k.TABLE_SCHEMA AND t.TABLE_NAME = k.TABLE_NAME
+	WHERE t.CONSTRAINT_TYPE IN ('PRIMARY KEY', 'UNIQUE', 'FOREIGN KEY') AND `+constraintFilter+`
+	ORDER BY k.TABLE_SCHEMA, k.TABLE_NAME, t.CONSTRAINT_TYPE, k.CONSTRAINT_NAME, k.ORDINAL_POSITION
+	`, constraintArgs...).Scan(&keyColumns).Error
+	if err != nil {
+		return nil, err
+	}
+
+	schema := &models.Schema{Tables: make([]models.Table, len(tables))}
+	byName := make(map[string]*models.Table, len(tables))
+	for i, t := range tables {
+		kind := models.TableKindTable
+		comment := t.Comment
+		if t.TableType == "VIEW" {
+			// MySQL reports "VIEW" as the comment of every view
+			kind, comment = models.TableKindView, ""
+		}
+		schema.Tables[i] = models.Table{
+			Schema:  t.TableSchema,
+			Name:    t.TableName,
+			Kind:    kind,
+			Comment: comment,
+		}
+		byName[schema.Tables[i].QualifiedName()] = &schema.Tables[i]
+	}
+
+	for _, col := range columns {
+		table, ok := byName[qualifiedName(col.TableSchema, col.TableName)]
+		if !ok {
+			continue
+		}
+		table.Columns = append(table.Columns, models.Column{
+			Name:       col.ColumnName,
+			DataType:   col.ColumnType,
+			Nullable:   col.IsNullable == "YES",
+			Comment:    col.Comment,
+			EnumValues: enumValues(col.ColumnType),
+		})
+	}
+
+	// key columns arrive one row per column, grouped by constraint
+	var current *models.ForeignKey
+	var currentKey string
+	for _, kc := range keyColumns {
+		table, ok := byName[qualifiedName(kc.TableSchema, kc.TableName)]
+		if !ok {
+			continue
+		}
+		key := qualifiedName(kc.TableSchema, kc.TableName) + "." + kc.ConstraintName
+		first := key != currentKey
+		currentKey = key
+
+		switch kc.ConstraintType {
+		case "PRIMARY KEY":
+			table.PrimaryKey = append(table.PrimaryKey, kc.ColumnName)
+		case "UNIQUE":
+			if first {
+				table.UniqueKeys = append(table.UniqueKeys, nil)
+			}
+			last := len(table.UniqueKeys) - 1
+			table.UniqueKeys[last] = append(table.UniqueKeys[last], kc.ColumnName)
+		case "FOREIGN KEY":
+			if first {
+				table.ForeignKeys = append(table.ForeignKeys, models.ForeignKey{
+					ReferencedSchema: kc.ReferencedSchema,
+					ReferencedTable:  kc.ReferencedTable,
+				})
+			}
+			current = &table.ForeignKeys[len(table.ForeignKeys)-1]
+			current.Columns = append(current.Columns, kc.ColumnName)
+			current.ReferencedColumns = append(current.ReferencedColumns, kc.ReferencedColumn)
+		}
+	}
+
+	return schema, nil
+}
+
+// enumValues returns the labels of an enum('a','b') column type as MySQL
+// and DuckDB print it. Labels are quoted with doubled single quotes.
+func enumValues(columnType string) []string {
+	if !strings.HasPrefix(strings.ToLower(columnType), "enum(") || !strings.HasSuffix(columnType, ")") {
+		return nil
+	}
+	body := columnType[len("enum(") : len(columnType)-1]
+
+	var values []string
+	var value strings.Builder
+	inQuote := false
+	for i := 0; i < len(body); i++ {
+		c := body[i]
+		switch {
+		case c == '\'' && inQuote && i+1 < len(body) && body[i+1] == '\'':
+			value.WriteByte('\'')
+			i++
+		case c == '\'':
+			if inQuote {
+				values = append(values, value.String())
+				value.Reset()
+			}
+			inQuote = !inQuote
+		case inQuote:
+			value.WriteByte(c)
+		}
+	}
+	return values
+}
+
+// getMySQLSchemaFingerprint hashes the information_schema rows that make up
+// the introspected schema. MySQL has no object ids that change on rewrite,
+// so the column definitions themselves are hashed.
+func (s *service) getMySQLSchemaFingerprint(opts SchemaOptions) (string, error) {
+	filter, args := mysqlSchemaFilter("TABLE_SCHEMA", opts)
+
+	// the filter appears once per table below
+	var params []interface{}
+	for i := 0; i < 3; i++ {
+		params = append(params, args...)
+	}
+
+	var entries []string
+	err := s.db.Raw(`
+	SELECT CONCAT_WS(':', 'col', TABLE_SCHEMA, TABLE_NAME, ORDINAL_POSITION, COLUMN_NAME, COLUMN_TYPE, IS_NULLABLE,
+		MD5(COALESCE(COLUMN_COMMENT, ''))) AS entry
+	FROM information_schema.COLUMNS WHERE `+filter+`
+	UNION ALL
+	SELECT CONCAT_WS(':', 'table', TABLE_SCHEMA, TABLE_NAME, TABLE_TYPE, MD5(COALESCE(TABLE_COMMENT, '')))
+	FROM information_schema.TABLES WHERE `+filter+`
+	UNION ALL
+	SELECT CONCAT_WS(':', 'con', TABLE_SCHEMA, TABLE_NAME, CONSTRAINT_NAME, ORDINAL_POSITION, COLUMN_NAME,
+		COALESCE(REFERENCED_TABLE_SCHEMA, ''), COALESCE(REFERENCED_TABLE_NAME, ''), COALESCE(REFERENCED_COLUMN_NAME, ''))
+	FROM information_schema.KEY_COLUMN_USAGE WHERE `+filter+`
+	`, params...).Scan(&entries).Error
+	if err != nil {
+		return "", err
+	}
+	if len(entries) == 0 {
+		return "", nil
+	}
+
+	sort.Strings(entries)
+	sum := md5.Sum([]byte(strings.Join(entries, ",")))
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,192 @@
+package database
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cr34t1ve/hoprun/pkg/models"
+)
+
+// sqliteSchema is the name SQLite gives the main database file. Attached
+// databases are not introspected, so the schema options do not apply.
+const sqliteSchema = "main"
+
+// sqliteTables selects the user tables and views from sqlite_master.
+const sqliteTables = `
+	SELECT name, type FROM sqlite_master
+	WHERE type IN ('table', 'view') AND name NOT LIKE 'sqlite\_%' ESCAPE '\'`
+
+// getSQLiteSchema introspects a SQLite database through sqlite_master and the
+// table_info, index_list and foreign_key_list pragmas. SQLite has no
+// comments, enums or value statistics to add.
+func (s *service) getSQLiteSchema() (*models.Schema, error) {
+	var tables []struct {
+		Name string `gorm:"column:name"`
+		Type string `gorm:"column:type"`
+	}
+	if err := s.db.Raw(sqliteTables + " ORDER BY name").Scan(&tables).Error; err != nil {
+		return nil, err
+	}
+
+	var columns []struct {
+		TableName  string `gorm:"column:table_name"`
+		ColumnName string `gorm:"column:column_name"`
+		DataType   string `gorm:"column:data_type"`
+		NotNull    bool   `gorm:"column:not_null"`
+		PrimaryKey int    `gorm:"column:pk"`
+	}
+	err := s.db.Raw(`
+	SELECT m.name AS table_name, p.name AS column_name, p.type AS data_type, p."notnull" AS not_null, p.pk AS pk
+	FROM (` + sqliteTables + `) m
+	JOIN pragma_table_info(m.name) p
+	ORDER BY m.name, p.cid
+	`).Scan(&columns).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var uniqueColumns []struct {
+		TableName  string `gorm:"column:table_name"`
+		IndexName  string `gorm:"column:index_name"`
+		ColumnName string `gorm:"column:column_name"`
+	}
+	err = s.db.Raw(`
+	SELECT m.name AS table_name, il.name AS index_name, ii.name AS column_name
+	FROM (` + sqliteTables + `) m
+	JOIN pragma_index_list(m.name) il
+	JOIN pragma_index_info(il.name) ii
+	WHERE il."unique" AND il.origin = 'u'
+	ORDER BY m.name, il.name, ii.seqno
+	`).Scan(&uniqueColumns).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var foreignKeys []struct {
+		TableName        string `gorm:"column:table_name"`
+		ID               int    `gorm:"column:id"`
+		ColumnName       string `gorm:"column:column_name"`
+		ReferencedTable  string `gorm:"column:referenced_table"`
+		ReferencedColumn string `gorm:"column:referenced_column"`
+	}
+	err = s.db.Raw(`
+	SELECT m.name AS table_name, fk.id AS id, fk."from" AS column_name, fk."table" AS referenced_table,
+		COALESCE(fk."to", '') AS referenced_column
+	FROM (` + sqliteTables + `) m
+	JOIN pragma_foreign_key_list(m.name) fk
+	ORDER BY m.name, fk.id, fk.seq
+	`).Scan(&foreignKeys).Error
+	if err != nil {
+		return nil, err
+	}
+
+	schema := &models.Schema{Tables: make([]models.Table, len(tables))}
+	byName := make(map[string]*models.Table, len(tables))
+	for i, t := range tables {
+		kind := models.TableKindTable
+		if t.Type == "view" {
+			kind = models.TableKindView
+		}
+		schema.Tables[i] = models.Table{Schema: sqliteSchema, Name: t.Name, Kind: kind}
+		byName[t.Name] = &schema.Tables[i]
+	}
+
+	primaryKeys := make(map[string]map[int]string)
+	for _, col := range columns {
+		table, ok := byName[col.TableName]
+		if !ok {
+			continue
+		}
+		table.Columns = append(table.Columns, models.Column{
+			Name:     col.ColumnName,
+			DataType: col.DataType,
+			Nullable: !col.NotNull,
+		})
+		if col.PrimaryKey > 0 {
+			if primaryKeys[col.TableName] == nil {
+				primaryKeys[col.TableName] = make(map[int]string)
+			}
+			primaryKeys[col.TableName][col.PrimaryKey] = col.ColumnName
+		}
+	}
+	for name, key := range primaryKeys {
+		// pk is the column's position in the key, starting at 1
+		for i := 1; i <= len(key); i++ {
+			byName[name].PrimaryKey = append(byName[name].PrimaryKey, key[i])
+		}
+	}
+
+	var lastIndex string
+	for _, uc := range uniqueColumns {
+		table, ok := byName[uc.TableName]
+		if !ok {
+			continue
+		}
+		if uc.IndexName != lastIndex {
+			table.UniqueKeys = append(table.UniqueKeys, nil)
+			lastIndex = uc.IndexName
+		}
+		last := len(table.UniqueKeys) - 1
+		table.UniqueKeys[last] = append(table.UniqueKeys[last], uc.ColumnName)
+	}
+
+	lastKey := ""
+	for _, fk := range foreignKeys {
+		table, ok := byName[fk.TableName]
+		if !ok {
+			continue
+		}
+		key := fk.TableName + "." + strconv.Itoa(fk.ID)
+		if key != lastKey {
+			table.ForeignKeys = append(table.ForeignKeys, models.ForeignKey{
+				ReferencedSchema: sqliteSchema,
+				ReferencedTable:  fk.ReferencedTable,
+			})
+			lastKey = key
+		}
+		current := &table.ForeignKeys[len(table.ForeignKeys)-1]
+		current.Columns = append(current.Columns, fk.ColumnName)
+		current.ReferencedColumns = append(current.ReferencedColumns, fk.ReferencedColumn)
+	}
+
+	// a foreign key without target columns references the primary key
+	for i := range schema.Tables {
+		for j := range schema.Tables[i].ForeignKeys {
+			fk := &schema.Tables[i].ForeignKeys[j]
+			referenced, ok := byName[fk.ReferencedTable]
+			if !ok || len(referenced.PrimaryKey) != len(fk.Columns) {
+				continue
+			}
+			for k := range fk.ReferencedColumns {
+				if fk.ReferencedColumns[k] == "" {
+					fk.ReferencedColumns[k] = referenced.PrimaryKey[k]
+				}
+			}
+		}
+	}
+
+	return schema, nil
+}
+
+// getSQLiteSchemaFingerprint hashes the CREATE statements SQLite keeps in
+// sqlite_master, which change with every schema change.
+func (s *service) getSQLiteSchemaFingerprint() (string, error) {
+	var entries []string
+	err := s.db.Raw(`
+	SELECT type || ':' || name || ':' || COALESCE(sql, '') FROM sqlite_master
+	WHERE name NOT LIKE 'sqlite\_%' ESCAPE '\'
+	`).Scan(&entries).Error
+	if err != nil {
+		return "", err
+	}
+	if len(entries) == 0 {
+		return "", nil
+	}
+
+	sort.Strings(entries)
+	sum := md5.Sum([]byte(strings.Join(entries, "\n")))
+	return hex.EncodeToString(sum[:]), nil
+}
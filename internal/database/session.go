@@ -0,0 +1,109 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/cr34t1ve/hoprun/pkg/models"
+)
+
+// CreateSession stores a new session, first dropping the user's sessions that
+// have expired along with their rotated refresh tokens.
+func (s *service) CreateSession(ctx context.Context, session *models.Session) (*models.Session, error) {
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		expired := tx.Model(&models.Session{}).Select("id").
+			Where("user_id = ? AND expires_at < ?", session.UserID, time.Now())
+		err := tx.Where("session_id IN (?)", expired).Delete(&models.RotatedRefreshToken{}).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Where("user_id = ? AND expires_at < ?", session.UserID, time.Now()).
+			Delete(&models.Session{}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(session).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// GetSessionByAccessTokenID returns the live session whose current access
+// token has the given jti.
+func (s *service) GetSessionByAccessTokenID(ctx context.Context, accessTokenID string) (*models.Session, error) {
+	var session models.Session
+	result := s.db.WithContext(ctx).
+		Where("access_token_id = ? AND revoked_at IS NULL AND expires_at > ?", accessTokenID, time.Now()).
+		First(&session)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &session, nil
+}
+
+// RotateSession replaces the refresh token and access token ID of the live
+// session holding refreshTokenHash. The session keeps the expiry it was
+// created with. The update is conditional on the old hash, so a refresh token
+// can only be used once even by concurrent requests, and the old hash is kept
+// so that presenting it again revokes the session.
+func (s *service) RotateSession(ctx context.Context, refreshTokenHash, newRefreshTokenHash, accessTokenID string) (*models.Session, error) {
+	var session models.Session
+	replayed := false
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("refresh_token_hash = ? AND revoked_at IS NULL AND expires_at > ?", refreshTokenHash, time.Now()).
+			First(&session)
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			var rotated models.RotatedRefreshToken
+			if err := tx.Where("token_hash = ?", refreshTokenHash).First(&rotated).Error; err != nil {
+				return err
+			}
+			replayed = true
+			return tx.Model(&models.Session{}).
+				Where("id = ? AND revoked_at IS NULL", rotated.SessionID).
+				Update("revoked_at", time.Now()).Error
+		}
+		if result.Error != nil {
+			return result.Error
+		}
+
+		result = tx.Model(&session).
+			Where("refresh_token_hash = ?", refreshTokenHash).
+			Updates(map[string]interface{}{
+				"refresh_token_hash": newRefreshTokenHash,
+				"access_token_id":    accessTokenID,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return tx.Create(&models.RotatedRefreshToken{SessionID: session.ID, TokenHash: refreshTokenHash}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	if replayed {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &session, nil
+}
+
+// RevokeSession ends the session an access token was issued from.
+func (s *service) RevokeSession(ctx context.Context, accessTokenID string) error {
+	return s.db.WithContext(ctx).Model(&models.Session{}).
+		Where("access_token_id = ? AND revoked_at IS NULL", accessTokenID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// RevokeUserSessions ends every session of a user.
+func (s *service) RevokeUserSessions(ctx context.Context, userID int) error {
+	return s.db.WithContext(ctx).Model(&models.Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
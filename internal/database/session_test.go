@@ -0,0 +1,115 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/cr34t1ve/hoprun/pkg/models"
+)
+
+func newTestService(t *testing.T) *service {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{TranslateError: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every connection to :memory: is a new database
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	if err := db.AutoMigrate(&models.Session{}, &models.RotatedRefreshToken{}); err != nil {
+		t.Fatal(err)
+	}
+	return &service{db: db}
+}
+
+func TestRotateSessionKeepsExpiry(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	created, err := s.CreateSession(ctx, &models.Session{UserID: 1, RefreshTokenHash: "r1", AccessTokenID: "a1", ExpiresAt: expiresAt})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.RotateSession(ctx, "r1", "r2", "a2"); err != nil {
+		t.Fatal(err)
+	}
+	session, err := s.GetSessionByAccessTokenID(ctx, "a2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.ID != created.ID || !session.ExpiresAt.Equal(expiresAt) {
+		t.Errorf("rotated session = %+v, want session %d still expiring at %s", session, created.ID, expiresAt)
+	}
+	if _, err := s.GetSessionByAccessTokenID(ctx, "a1"); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("previous access token still accepted: %v", err)
+	}
+}
+
+func TestRotateSessionReplayRevokes(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+	if _, err := s.CreateSession(ctx, &models.Session{UserID: 1, RefreshTokenHash: "r1", AccessTokenID: "a1", ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateSession(ctx, &models.Session{UserID: 1, RefreshTokenHash: "other", AccessTokenID: "other", ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.RotateSession(ctx, "r1", "r2", "a2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.RotateSession(ctx, "r2", "r3", "a3"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the first token, two rotations old, is presented again
+	if _, err := s.RotateSession(ctx, "r1", "r4", "a4"); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("replayed RotateSession() = %v, want gorm.ErrRecordNotFound", err)
+	}
+	if _, err := s.GetSessionByAccessTokenID(ctx, "a3"); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("session still live after a replayed refresh token: %v", err)
+	}
+	if _, err := s.RotateSession(ctx, "r3", "r5", "a5"); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("current refresh token of a revoked session accepted: %v", err)
+	}
+	// other sessions of the user are left alone
+	if _, err := s.GetSessionByAccessTokenID(ctx, "other"); err != nil {
+		t.Errorf("other session revoked: %v", err)
+	}
+
+	if _, err := s.RotateSession(ctx, "unknown", "r6", "a6"); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("unknown RotateSession() = %v, want gorm.ErrRecordNotFound", err)
+	}
+}
+
+func TestCreateSessionDropsExpired(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+	expired, err := s.CreateSession(ctx, &models.Session{UserID: 1, RefreshTokenHash: "r1", AccessTokenID: "a1", ExpiresAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.RotateSession(ctx, "r1", "r2", "a2"); err != nil {
+		t.Fatal(err)
+	}
+	s.db.Model(expired).Update("expires_at", time.Now().Add(-time.Second))
+
+	if _, err := s.CreateSession(ctx, &models.Session{UserID: 1, RefreshTokenHash: "n1", AccessTokenID: "n1", ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	var sessions, rotated int64
+	s.db.Model(&models.Session{}).Where("id = ?", expired.ID).Count(&sessions)
+	s.db.Model(&models.RotatedRefreshToken{}).Where("session_id = ?", expired.ID).Count(&rotated)
+	if sessions != 0 || rotated != 0 {
+		t.Errorf("%d expired sessions and %d of their rotated tokens kept, want none", sessions, rotated)
+	}
+}
@@ -2,87 +2,378 @@ package databaseconnection
 
 import (
 	"context"
-	"crypto/aes"
-	"crypto/cipher"
-	"crypto/rand"
-	"encoding/base64"
 	"errors"
-	"io"
+	"fmt"
+	"log"
 	"os"
+	"path/filepath"
+	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
+	"github.com/cr34t1ve/hoprun/internal/dsn"
+	"github.com/cr34t1ve/hoprun/internal/secrets"
 	"github.com/cr34t1ve/hoprun/pkg/models"
 )
 
 type Service interface {
-	AddConnection(ctx context.Context, projectID int, dbName, dbUser, dbPassword, dbHost, dbPort string) (*models.DatabaseConnection, error)
-	ListProjectConnections(ctx context.Context, projectID int) (*[]models.DatabaseConnection, error)
-	GetProjectConnection(ctx context.Context, projectID int) (*models.DatabaseConnection, error)
+	AddConnection(ctx context.Context, connection *models.DatabaseConnection) (*models.DatabaseConnection, error)
+	// ListProjectConnections returns a project's connections as seen by a
+	// member with role: viewers only get each connection's name, dialect and
+	// health, not where it points or who it connects as.
+	ListProjectConnections(ctx context.Context, projectID int, role string) (*[]models.DatabaseConnection, error)
+	// GetProjectConnection picks one of a project's connections by ID, by
+	// name, or else the project's default connection, and returns it ready
+	// to connect to like GetConnection.
+	GetProjectConnection(ctx context.Context, project *models.Project, connectionID int, name string) (*models.DatabaseConnection, error)
+	SetDefaultConnection(ctx context.Context, projectID, connectionID int) error
+	// GetConnection and ListAllConnections return connections with their
+	// passwords decrypted and file paths resolved, for connecting to them.
+	GetConnection(ctx context.Context, connectionID int) (*models.DatabaseConnection, error)
+	// GetStoredConnection returns a connection as it is stored, with its
+	// credentials still encrypted, for changing its settings.
+	GetStoredConnection(ctx context.Context, connectionID int) (*models.DatabaseConnection, error)
+	// ListAllConnections leaves out connections that cannot be prepared,
+	// e.g. because their secrets do not decrypt, and records a failed check
+	// for them instead.
+	ListAllConnections(ctx context.Context) ([]models.DatabaseConnection, error)
+	// GetConnectionProjectID returns the project a connection belongs to,
+	// without loading its credentials.
+	GetConnectionProjectID(ctx context.Context, connectionID int) (int, error)
+	RecordCheck(ctx context.Context, check *models.ConnectionCheck) error
+	UpdateConnection(ctx context.Context, connection *models.DatabaseConnection) (*models.DatabaseConnection, error)
+	DeleteConnection(ctx context.Context, connectionID int) error
+	// ReencryptConnections rewraps every connection's data key with the
+	// current keyring key, encrypts any passwords still stored in plaintext
+	// and seals again secrets not yet bound to their connection. It returns
+	// the number of connections changed.
+	ReencryptConnections(ctx context.Context) (int, error)
 }
 
+// DefaultMaxConnections is the per-project connection limit used when none
+// is configured.
+const DefaultMaxConnections = 10
+
+var (
+	ErrConnectionLimit = errors.New("connection count limit reached")
+	// ErrNoDefaultConnection is returned when a project has several
+	// connections, none is named in the request and no default is set.
+	ErrNoDefaultConnection = errors.New("project has no default connection; pass connection_id or connection")
+	// ErrFileNotFound is returned when a sqlite or duckdb connection names a
+	// file that is not in the database directory.
+	ErrFileNotFound = errors.New("database file not found")
+	// ErrFileDatabasesDisabled is returned for sqlite and duckdb connections
+	// when no database directory is configured.
+	ErrFileDatabasesDisabled = errors.New("file-backed connections are disabled; set FILE_DATABASE_DIR")
+	// ErrSSLKeyRequired and ErrSSHKeyRequired are returned when a client
+	// certificate or a jump host has no key, given or stored.
+	ErrSSLKeyRequired = errors.New("sslcert requires sslkey")
+	ErrSSHKeyRequired = errors.New("ssh_host requires ssh_private_key")
+)
+
 type service struct {
-	db *gorm.DB
+	db             *gorm.DB
+	secrets        secrets.Service
+	maxConnections int
+	// fileDir is the directory sqlite and duckdb file paths are relative to
+	fileDir string
 }
 
-func NewService(db *gorm.DB) Service {
-	return &service{db: db}
+// NewService returns the connection service. fileDir is the directory
+// file-backed databases are read from; when empty, sqlite and duckdb
+// connections are refused.
+func NewService(db *gorm.DB, secrets secrets.Service, maxConnections int, fileDir string) Service {
+	if maxConnections <= 0 {
+		maxConnections = DefaultMaxConnections
+	}
+	return &service{db: db, secrets: secrets, maxConnections: maxConnections, fileDir: fileDir}
 }
 
-func (s *service) AddConnection(ctx context.Context, projectID int, dbName, dbUser, dbPassword, dbHost, dbPort string) (*models.DatabaseConnection, error) {
-	count, err := s.checkForConnectionsLength(ctx, projectID)
-	if err != nil {
+// AddConnection stores a new connection. A project's first connection
+// becomes its default, and an unnamed connection is called "default".
+func (s *service) AddConnection(ctx context.Context, connection *models.DatabaseConnection) (*models.DatabaseConnection, error) {
+	if connection.Name == "" {
+		connection.Name = "default"
+	}
+	if connection.Dialect == "" {
+		connection.Dialect = models.DialectPostgres
+	}
+	setDefaultPort(connection)
+	if err := s.checkFile(connection); err != nil {
 		return nil, err
 	}
-
-	if count >= 1 {
-		return nil, errors.New("connection count limit reached")
+	if err := checkKeys(connection); err != nil {
+		return nil, err
 	}
 
-	// hashedPassword, err := encryptPassword(dbPassword)
-	// if err != nil {
-	// 	return nil, err
-	// }
-
-	databaseConnection := &models.DatabaseConnection{
-		ProjectID:  projectID,
-		DBName:     dbName,
-		DBUser:     dbUser,
-		DBPassword: dbPassword,
-		DBHost:     dbHost,
-		DBPort:     dbPort,
+	// the secrets are sealed to the connection's ID, so they are only
+	// written once the row exists
+	fields := secretFields(connection)
+	plain := make([]string, len(fields))
+	for i, field := range fields {
+		plain[i], *field.value = *field.value, ""
 	}
-	result := s.db.WithContext(ctx).Create(databaseConnection)
-	if result.Error != nil {
-		return nil, result.Error
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := s.checkConnectionLimit(tx, connection.ProjectID); err != nil {
+			return err
+		}
+		if err := tx.Create(connection).Error; err != nil {
+			return err
+		}
+		for i, field := range fields {
+			*field.value = plain[i]
+		}
+		if err := s.sealSecrets(connection); err != nil {
+			return err
+		}
+		if err := tx.Model(connection).UpdateColumns(secretColumns(connection)).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.Project{}).
+			Where("id = ? AND default_connection_id IS NULL", connection.ProjectID).
+			Update("default_connection_id", connection.ID).Error
+	})
+	if err != nil {
+		return nil, err
 	}
-	return databaseConnection, nil
+	return connection, nil
 }
 
-func (s *service) ListProjectConnections(ctx context.Context, projectID int) (*[]models.DatabaseConnection, error) {
+func (s *service) ListProjectConnections(ctx context.Context, projectID int, role string) (*[]models.DatabaseConnection, error) {
 	var connections []models.DatabaseConnection
 	results := s.db.WithContext(ctx).Where("project_id = ?", projectID).Find(&connections)
 	if results.Error != nil {
 		return nil, results.Error
 	}
+	if !models.RoleAtLeast(role, models.RoleEditor) {
+		for i := range connections {
+			connections[i] = redact(&connections[i])
+		}
+	}
 	return &connections, nil
 }
 
-func (s *service) GetProjectConnection(ctx context.Context, projectID int) (*models.DatabaseConnection, error) {
-	var connections models.DatabaseConnection
-	results := s.db.WithContext(ctx).Where("project_id = ?", projectID).First(&connections)
-	if results.Error != nil {
-		return nil, results.Error
+// redact keeps the parts of a connection a viewer needs to pick one to query.
+func redact(connection *models.DatabaseConnection) models.DatabaseConnection {
+	return models.DatabaseConnection{
+		ID:            connection.ID,
+		ProjectID:     connection.ProjectID,
+		Name:          connection.Name,
+		Dialect:       connection.Dialect,
+		CreatedAt:     connection.CreatedAt,
+		UpdatedAt:     connection.UpdatedAt,
+		Status:        connection.Status,
+		LastCheckedAt: connection.LastCheckedAt,
 	}
+}
 
-	// decryptedPassword, err := decryptPassword(connections.DBPassword)
-	// if err != nil {
-	// 	return nil, err
-	// }
+func (s *service) GetProjectConnection(ctx context.Context, project *models.Project, connectionID int, name string) (*models.DatabaseConnection, error) {
+	query := s.db.WithContext(ctx).Where("project_id = ?", project.ID)
+	switch {
+	case connectionID != 0:
+		query = query.Where("id = ?", connectionID)
+	case name != "":
+		query = query.Where("name = ?", name)
+	case project.DefaultConnectionID != nil:
+		query = query.Where("id = ?", *project.DefaultConnectionID)
+	default:
+		// without a default, a project's only connection is unambiguous
+		count, err := s.checkForConnectionsLength(ctx, project.ID)
+		if err != nil {
+			return nil, err
+		}
+		if count > 1 {
+			return nil, ErrNoDefaultConnection
+		}
+	}
 
-	// connections.DBPassword = decryptedPassword
+	var connection models.DatabaseConnection
+	if err := query.First(&connection).Error; err != nil {
+		return nil, err
+	}
+	if err := s.prepare(&connection); err != nil {
+		return nil, err
+	}
+	return &connection, nil
+}
 
-	return &connections, nil
+// SetDefaultConnection makes connectionID the project's default. The
+// connection must belong to the project.
+func (s *service) SetDefaultConnection(ctx context.Context, projectID, connectionID int) error {
+	var count int64
+	err := s.db.WithContext(ctx).Model(&models.DatabaseConnection{}).
+		Where("id = ? AND project_id = ?", connectionID, projectID).
+		Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return s.db.WithContext(ctx).Model(&models.Project{ID: projectID}).Update("default_connection_id", connectionID).Error
+}
+
+func (s *service) GetConnection(ctx context.Context, connectionID int) (*models.DatabaseConnection, error) {
+	var connection models.DatabaseConnection
+	if err := s.db.WithContext(ctx).First(&connection, connectionID).Error; err != nil {
+		return nil, err
+	}
+	if err := s.prepare(&connection); err != nil {
+		return nil, err
+	}
+	return &connection, nil
+}
+
+func (s *service) GetStoredConnection(ctx context.Context, connectionID int) (*models.DatabaseConnection, error) {
+	var connection models.DatabaseConnection
+	if err := s.db.WithContext(ctx).First(&connection, connectionID).Error; err != nil {
+		return nil, err
+	}
+	return &connection, nil
+}
+
+func (s *service) GetConnectionProjectID(ctx context.Context, connectionID int) (int, error) {
+	var connection models.DatabaseConnection
+	if err := s.db.WithContext(ctx).Select("id", "project_id").First(&connection, connectionID).Error; err != nil {
+		return 0, err
+	}
+	return connection.ProjectID, nil
+}
+
+func (s *service) ListAllConnections(ctx context.Context) ([]models.DatabaseConnection, error) {
+	var connections []models.DatabaseConnection
+	if err := s.db.WithContext(ctx).Order("id").Find(&connections).Error; err != nil {
+		return nil, err
+	}
+	prepared := connections[:0]
+	for _, connection := range connections {
+		if err := s.prepare(&connection); err != nil {
+			log.Printf("Cannot prepare connection %d: %v", connection.ID, err)
+			check := &models.ConnectionCheck{
+				ConnectionID: connection.ID,
+				Status:       models.ConnectionStatusError,
+				CheckedAt:    time.Now(),
+				Error:        err.Error(),
+			}
+			if err := s.RecordCheck(ctx, check); err != nil {
+				log.Printf("Failed to record health check for connection %d: %v", connection.ID, err)
+			}
+			continue
+		}
+		prepared = append(prepared, connection)
+	}
+	return prepared, nil
+}
+
+// RecordCheck stores the outcome of a health check on its connection.
+func (s *service) RecordCheck(ctx context.Context, check *models.ConnectionCheck) error {
+	// UpdateColumns leaves updated_at alone so the connection's pool is kept
+	result := s.db.WithContext(ctx).Model(&models.DatabaseConnection{ID: check.ConnectionID}).UpdateColumns(map[string]interface{}{
+		"status":          check.Status,
+		"last_checked_at": check.CheckedAt,
+		"last_error":      check.Error,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// UpdateConnection saves new settings for an existing connection. The project
+// cannot be changed. An empty dialect keeps the stored one. An empty password
+// keeps the stored one, as do an empty client key while a client certificate
+// is set and an empty SSH key while a jump host is set.
+func (s *service) UpdateConnection(ctx context.Context, connection *models.DatabaseConnection) (*models.DatabaseConnection, error) {
+	var existing models.DatabaseConnection
+	if err := s.db.WithContext(ctx).First(&existing, connection.ID).Error; err != nil {
+		return nil, err
+	}
+
+	connection.ProjectID = existing.ProjectID
+	connection.CreatedAt = existing.CreatedAt
+	if connection.Name == "" {
+		connection.Name = existing.Name
+	}
+	if connection.Dialect == "" {
+		connection.Dialect = existing.Dialect
+	}
+	setDefaultPort(connection)
+	if err := s.checkFile(connection); err != nil {
+		return nil, err
+	}
+	// the last check was against the old settings
+	connection.Status = models.ConnectionStatusUnknown
+	connection.LastCheckedAt = nil
+	connection.LastError = ""
+	if err := s.openSecrets(&existing); err != nil {
+		return nil, err
+	}
+	if connection.DBPassword == "" {
+		connection.DBPassword = existing.DBPassword
+	}
+	if connection.SSLKey == "" && connection.SSLCert != "" {
+		connection.SSLKey = existing.SSLKey
+	}
+	if connection.SSHPrivateKey == "" && connection.SSHHost != "" {
+		connection.SSHPrivateKey = existing.SSHPrivateKey
+	}
+	if err := checkKeys(connection); err != nil {
+		return nil, err
+	}
+	if err := s.sealSecrets(connection); err != nil {
+		return nil, err
+	}
+
+	result := s.db.WithContext(ctx).Save(connection)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return connection, nil
+}
+
+// DeleteConnection removes a connection, clearing it as its project's
+// default.
+func (s *service) DeleteConnection(ctx context.Context, connectionID int) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&models.Project{}).
+			Where("default_connection_id = ?", connectionID).
+			Update("default_connection_id", nil).Error
+		if err != nil {
+			return err
+		}
+
+		result := tx.Delete(&models.DatabaseConnection{}, connectionID)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+}
+
+// checkConnectionLimit rejects a new connection once the project has
+// maxConnections. The project row is locked until the transaction ends, so
+// concurrent adds cannot each pass the check against the same count.
+func (s *service) checkConnectionLimit(tx *gorm.DB, projectID int) error {
+	var project models.Project
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&project, projectID).Error; err != nil {
+		return err
+	}
+
+	var count int64
+	if err := tx.Model(&models.DatabaseConnection{}).Where("project_id = ?", projectID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count >= int64(s.maxConnections) {
+		return fmt.Errorf("%w (%d per project)", ErrConnectionLimit, s.maxConnections)
+	}
+	return nil
 }
 
 func (s *service) checkForConnectionsLength(ctx context.Context, projectID int) (int64, error) {
@@ -94,54 +385,220 @@ func (s *service) checkForConnectionsLength(ctx context.Context, projectID int)
 	return count, nil
 }
 
-func encryptPassword(password string) (string, error) {
-	key := []byte(os.Getenv("ENCRYPTION_KEY")) // 32 bytes for AES-256
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		return "", err
+func (s *service) ReencryptConnections(ctx context.Context) (int, error) {
+	var connections []models.DatabaseConnection
+	if err := s.db.WithContext(ctx).Find(&connections).Error; err != nil {
+		return 0, err
 	}
 
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return "", err
+	current := s.secrets.CurrentKeyID()
+	changed := 0
+	for i := range connections {
+		connection := &connections[i]
+		unbound := hasUnboundSecrets(connection)
+		switch {
+		case connection.KeyID == current && !unbound:
+			continue
+		case connection.KeyID == "":
+			// stored before encryption was enabled
+			if err := s.sealSecrets(connection); err != nil {
+				return changed, err
+			}
+		case unbound:
+			// sealed before secrets were bound to their connection
+			if err := s.openSecrets(connection); err != nil {
+				return changed, err
+			}
+			if err := s.sealSecrets(connection); err != nil {
+				return changed, err
+			}
+		default:
+			key, err := s.secrets.OpenDataKey(connection.KeyID, connection.DataKey)
+			if err != nil {
+				return changed, err
+			}
+			if err := s.secrets.Rewrap(key); err != nil {
+				return changed, err
+			}
+			connection.KeyID, connection.DataKey = key.KeyID, key.Wrapped
+		}
+
+		// UpdateColumns leaves updated_at alone; the credentials are unchanged
+		result := s.db.WithContext(ctx).Model(connection).UpdateColumns(secretColumns(connection))
+		if result.Error != nil {
+			return changed, result.Error
+		}
+		changed++
 	}
+	return changed, nil
+}
 
-	nonce := make([]byte, gcm.NonceSize())
-	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
-		return "", err
+// setDefaultPort fills in the dialect's standard port, which the column
+// default would otherwise set to Postgres's.
+func setDefaultPort(connection *models.DatabaseConnection) {
+	if connection.DBPort == "" && !models.IsFileDialect(connection.Dialect) {
+		connection.DBPort = dsn.DefaultPort(connection.Dialect)
 	}
+}
 
-	cipherText := gcm.Seal(nonce, nonce, []byte(password), nil)
-	return base64.StdEncoding.EncodeToString(cipherText), nil
+// checkKeys requires the keys that dsn.Validate and sshtunnel.Validate leave
+// optional, so an update can keep the stored ones. It runs once the stored
+// keys have been filled in.
+func checkKeys(connection *models.DatabaseConnection) error {
+	if connection.SSLCert != "" && connection.SSLKey == "" {
+		return ErrSSLKeyRequired
+	}
+	if connection.SSHHost != "" && connection.SSHPrivateKey == "" {
+		return ErrSSHKeyRequired
+	}
+	return nil
 }
 
-func decryptPassword(encryptedPassword string) (string, error) {
-	key := []byte(os.Getenv("ENCRYPTION_KEY")) // 32 bytes for AES-256
-	block, err := aes.NewCipher(key)
+// checkFile makes sure a file-backed connection's database exists when it is
+// saved, so a wrong path is reported straight away.
+func (s *service) checkFile(connection *models.DatabaseConnection) error {
+	if !models.IsFileDialect(connection.Dialect) {
+		return nil
+	}
+	path, err := s.filePath(connection)
 	if err != nil {
-		return "", err
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() {
+		return fmt.Errorf("%w: %s", ErrFileNotFound, connection.FilePath)
 	}
+	return nil
+}
 
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return "", err
+// filePath returns the absolute path of a file-backed connection's database
+// with symlinks resolved. A symlink in the database directory could point
+// anywhere, so the resolved path must still be inside the directory.
+func (s *service) filePath(connection *models.DatabaseConnection) (string, error) {
+	if s.fileDir == "" {
+		return "", ErrFileDatabasesDisabled
+	}
+	if !filepath.IsLocal(connection.FilePath) {
+		return "", fmt.Errorf("%w: %s", ErrFileNotFound, connection.FilePath)
 	}
 
-	cipherText, err := base64.RawStdEncoding.DecodeString(encryptedPassword)
+	dir, err := filepath.EvalSymlinks(s.fileDir)
 	if err != nil {
 		return "", err
 	}
+	path, err := filepath.EvalSymlinks(filepath.Join(dir, connection.FilePath))
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrFileNotFound, connection.FilePath)
+	}
+	if rel, err := filepath.Rel(dir, path); err != nil || !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("%w: %s", ErrFileNotFound, connection.FilePath)
+	}
+	return path, nil
+}
 
-	nonceSize := gcm.NonceSize()
-	if len(cipherText) < nonceSize {
-		return "", err
+// prepare readies a stored connection for connecting to it: secrets are
+// decrypted and file paths made absolute, both in place.
+func (s *service) prepare(connection *models.DatabaseConnection) error {
+	if err := s.openSecrets(connection); err != nil {
+		return err
 	}
+	if models.IsFileDialect(connection.Dialect) {
+		path, err := s.filePath(connection)
+		if err != nil {
+			return err
+		}
+		connection.FilePath = path
+	}
+	return nil
+}
+
+type secretField struct {
+	column string
+	value  *string
+}
 
-	nonce, cipherText := cipherText[:nonceSize], cipherText[nonceSize:]
-	plainText, err := gcm.Open(nil, nonce, cipherText, nil)
+// secretFields lists the connection fields that are encrypted at rest.
+func secretFields(connection *models.DatabaseConnection) []secretField {
+	return []secretField{
+		{"db_password", &connection.DBPassword},
+		{"ssl_key", &connection.SSLKey},
+		{"ssh_private_key", &connection.SSHPrivateKey},
+	}
+}
+
+// secretAAD binds a sealed field to its row and column, so ciphertext copied
+// to another connection or field does not decrypt.
+func secretAAD(connectionID int, column string) []byte {
+	return []byte(fmt.Sprintf("database_connections/%d/%s", connectionID, column))
+}
+
+// sealSecrets replaces the plaintext secrets with their ciphertext under a
+// new data key. Empty fields stay empty. The connection must have its ID.
+func (s *service) sealSecrets(connection *models.DatabaseConnection) error {
+	if connection.ID == 0 {
+		return errors.New("cannot seal the secrets of an unsaved connection")
+	}
+	key, err := s.secrets.NewDataKey()
 	if err != nil {
-		return "", err
+		return err
+	}
+	for _, field := range secretFields(connection) {
+		if *field.value == "" {
+			continue
+		}
+		sealed, err := key.Seal(*field.value, secretAAD(connection.ID, field.column))
+		if err != nil {
+			return err
+		}
+		*field.value = sealed
+	}
+	connection.KeyID, connection.DataKey = key.KeyID, key.Wrapped
+	return nil
+}
+
+// openSecrets decrypts the stored secrets in place. Rows without a key id
+// predate encryption and hold the password in plaintext until re-encrypted.
+func (s *service) openSecrets(connection *models.DatabaseConnection) error {
+	if connection.KeyID == "" {
+		return nil
+	}
+	key, err := s.secrets.OpenDataKey(connection.KeyID, connection.DataKey)
+	if err != nil {
+		return err
+	}
+	for _, field := range secretFields(connection) {
+		if *field.value == "" {
+			continue
+		}
+		plain, err := key.Open(*field.value, secretAAD(connection.ID, field.column))
+		if err != nil {
+			return err
+		}
+		*field.value = plain
+	}
+	return nil
+}
+
+// secretColumns returns the sealed secrets and the key they are sealed with,
+// for saving them without touching the rest of the row.
+func secretColumns(connection *models.DatabaseConnection) map[string]interface{} {
+	columns := map[string]interface{}{
+		"key_id":   connection.KeyID,
+		"data_key": connection.DataKey,
+	}
+	for _, field := range secretFields(connection) {
+		columns[field.column] = *field.value
 	}
+	return columns
+}
 
-	return string(plainText), nil
+// hasUnboundSecrets reports whether any of a connection's secrets were
+// sealed before they were bound to the connection.
+func hasUnboundSecrets(connection *models.DatabaseConnection) bool {
+	for _, field := range secretFields(connection) {
+		if *field.value != "" && !secrets.Bound(*field.value) {
+			return true
+		}
+	}
+	return false
 }
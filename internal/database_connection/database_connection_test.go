@@ -0,0 +1,222 @@
+package databaseconnection
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/cr34t1ve/hoprun/internal/secrets"
+	"github.com/cr34t1ve/hoprun/pkg/models"
+)
+
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{TranslateError: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every connection to :memory: is a new database
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	if err := db.AutoMigrate(&models.Project{}, &models.DatabaseConnection{}); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func newTestSecrets(t *testing.T, current string, ids ...string) secrets.Service {
+	t.Helper()
+	keys := make(map[string][]byte, len(ids))
+	for _, id := range ids {
+		keys[id] = bytes.Repeat([]byte(id[len(id)-1:]), 32)
+	}
+	keyring, err := secrets.NewKeyring(current, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return secrets.NewService(keyring)
+}
+
+func addTestConnection(t *testing.T, s Service, db *gorm.DB, name, password string) *models.DatabaseConnection {
+	t.Helper()
+	project := &models.Project{Name: name}
+	if err := db.Create(project).Error; err != nil {
+		t.Fatal(err)
+	}
+	connection, err := s.AddConnection(context.Background(), &models.DatabaseConnection{
+		ProjectID:  project.ID,
+		Name:       name,
+		DBHost:     "db.example.com",
+		DBName:     "app",
+		DBUser:     "reader",
+		DBPassword: password,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return connection
+}
+
+func storedConnection(t *testing.T, db *gorm.DB, id int) models.DatabaseConnection {
+	t.Helper()
+	var connection models.DatabaseConnection
+	if err := db.First(&connection, id).Error; err != nil {
+		t.Fatal(err)
+	}
+	return connection
+}
+
+func TestConnectionSecretsRoundTrip(t *testing.T) {
+	db := newTestDB(t)
+	s := NewService(db, newTestSecrets(t, "k1", "k1"), 0, "")
+
+	added := addTestConnection(t, s, db, "primary", "s3cret")
+	stored := storedConnection(t, db, added.ID)
+	if stored.DBPassword == "s3cret" || !secrets.Bound(stored.DBPassword) || stored.KeyID != "k1" {
+		t.Fatalf("stored password = %q under %q, want bound ciphertext under k1", stored.DBPassword, stored.KeyID)
+	}
+
+	connection, err := s.GetConnection(context.Background(), added.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if connection.DBPassword != "s3cret" {
+		t.Fatalf("password = %q, want s3cret", connection.DBPassword)
+	}
+}
+
+func TestConnectionSecretsCannotBeSwapped(t *testing.T) {
+	db := newTestDB(t)
+	s := NewService(db, newTestSecrets(t, "k1", "k1"), 0, "")
+
+	first := addTestConnection(t, s, db, "first", "first password")
+	second := addTestConnection(t, s, db, "second", "second password")
+
+	// copy the first row's password, along with the key it is sealed with,
+	// onto the second row
+	stored := storedConnection(t, db, first.ID)
+	err := db.Model(&models.DatabaseConnection{ID: second.ID}).UpdateColumns(map[string]interface{}{
+		"db_password": stored.DBPassword,
+		"key_id":      stored.KeyID,
+		"data_key":    stored.DataKey,
+	}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if connection, err := s.GetConnection(context.Background(), second.ID); err == nil {
+		t.Fatalf("swapped password opened as %q", connection.DBPassword)
+	}
+
+	// nor can it be moved to another field of the same row
+	err = db.Model(&models.DatabaseConnection{ID: first.ID}).UpdateColumns(map[string]interface{}{
+		"ssl_key": stored.DBPassword,
+	}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetConnection(context.Background(), first.ID); err == nil {
+		t.Fatal("password opened as the SSL key")
+	}
+}
+
+func TestReencryptConnections(t *testing.T) {
+	db := newTestDB(t)
+	before := NewService(db, newTestSecrets(t, "k1", "k1"), 0, "")
+	rotated := addTestConnection(t, before, db, "rotated", "rotated password")
+
+	// a row stored before encryption, and one sealed before secrets were
+	// bound to their connection
+	plaintext := addTestConnection(t, before, db, "plaintext", "")
+	if err := db.Model(plaintext).UpdateColumns(map[string]interface{}{"db_password": "plain password", "key_id": "", "data_key": ""}).Error; err != nil {
+		t.Fatal(err)
+	}
+	unbound := addTestConnection(t, before, db, "unbound", "")
+	key, err := newTestSecrets(t, "k1", "k1").NewDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy, err := key.Seal("unbound password", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy = legacy[len("v2:"):]
+	if err := db.Model(unbound).UpdateColumns(map[string]interface{}{"db_password": legacy, "key_id": key.KeyID, "data_key": key.Wrapped}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	after := NewService(db, newTestSecrets(t, "k2", "k1", "k2"), 0, "")
+	changed, err := after.ReencryptConnections(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed != 3 {
+		t.Fatalf("ReencryptConnections() changed %d connections, want 3", changed)
+	}
+
+	// only k2 is needed from now on
+	k2Only := NewService(db, newTestSecrets(t, "k2", "k2"), 0, "")
+	want := map[int]string{
+		rotated.ID:   "rotated password",
+		plaintext.ID: "plain password",
+		unbound.ID:   "unbound password",
+	}
+	for id, password := range want {
+		stored := storedConnection(t, db, id)
+		if stored.KeyID != "k2" || !secrets.Bound(stored.DBPassword) {
+			t.Errorf("connection %d stored under %q, bound %v; want bound under k2", id, stored.KeyID, secrets.Bound(stored.DBPassword))
+		}
+		connection, err := k2Only.GetConnection(context.Background(), id)
+		if err != nil {
+			t.Fatalf("connection %d: %v", id, err)
+		}
+		if connection.DBPassword != password {
+			t.Errorf("connection %d password = %q, want %q", id, connection.DBPassword, password)
+		}
+	}
+
+	changed, err = after.ReencryptConnections(context.Background())
+	if err != nil || changed != 0 {
+		t.Fatalf("second ReencryptConnections() = %d, %v; want nothing to change", changed, err)
+	}
+}
+
+func TestAddConnectionLimit(t *testing.T) {
+	db := newTestDB(t)
+	s := NewService(db, newTestSecrets(t, "k1", "k1"), 2, "")
+
+	first := addTestConnection(t, s, db, "first", "password")
+	add := func(name string) error {
+		_, err := s.AddConnection(context.Background(), &models.DatabaseConnection{
+			ProjectID: first.ProjectID,
+			Name:      name,
+			DBHost:    "db.example.com",
+		})
+		return err
+	}
+	if err := add("second"); err != nil {
+		t.Fatal(err)
+	}
+	if err := add("third"); !errors.Is(err, ErrConnectionLimit) {
+		t.Fatalf("AddConnection() over the limit = %v, want ErrConnectionLimit", err)
+	}
+
+	var count int64
+	db.Model(&models.DatabaseConnection{}).Where("project_id = ?", first.ProjectID).Count(&count)
+	if count != 2 {
+		t.Errorf("project has %d connections, want 2", count)
+	}
+	// the limit is per project
+	addTestConnection(t, s, db, "other", "password")
+
+	if _, err := s.AddConnection(context.Background(), &models.DatabaseConnection{ProjectID: 999, DBHost: "db.example.com"}); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("AddConnection() to a missing project = %v, want gorm.ErrRecordNotFound", err)
+	}
+}
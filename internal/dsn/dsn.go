@@ -0,0 +1,215 @@
+package dsn
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/cr34t1ve/hoprun/pkg/models"
+)
+
+const DefaultApplicationName = "hoprun"
+
+// DefaultPort returns the port a dialect's server listens on by default.
+func DefaultPort(dialect string) string {
+	if dialect == models.DialectMySQL {
+		return "3306"
+	}
+	return "5432"
+}
+
+var sslModes = map[string]bool{
+	"disable":     true,
+	"allow":       true,
+	"prefer":      true,
+	"require":     true,
+	"verify-ca":   true,
+	"verify-full": true,
+}
+
+// reservedParams are set from the connection's own fields or name files on
+// the HopRun server, so they cannot be passed as extra parameters.
+var reservedParams = map[string]bool{
+	"host":             true,
+	"hostaddr":         true,
+	"port":             true,
+	"user":             true,
+	"password":         true,
+	"dbname":           true,
+	"sslmode":          true,
+	"sslrootcert":      true,
+	"sslcert":          true,
+	"sslkey":           true,
+	"sslpassword":      true,
+	"sslcrl":           true,
+	"passfile":         true,
+	"service":          true,
+	"servicefile":      true,
+	"application_name": true,
+}
+
+var paramName = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// Validate checks a connection's settings before they are stored, so typos
+// are reported when the connection is added rather than on its first query.
+func Validate(conn *models.DatabaseConnection) error {
+	switch conn.Dialect {
+	case "", models.DialectPostgres, models.DialectMySQL:
+	case models.DialectSQLite, models.DialectDuckDB:
+		return validateFile(conn)
+	default:
+		return fmt.Errorf("unsupported dialect %q", conn.Dialect)
+	}
+	if conn.DBHost == "" {
+		return errors.New("db_host is required")
+	}
+	if conn.DBName == "" || conn.DBUser == "" {
+		return errors.New("db_name and db_user are required")
+	}
+	if conn.DBPort != "" {
+		port, err := strconv.Atoi(conn.DBPort)
+		if err != nil || port < 1 || port > 65535 {
+			return fmt.Errorf("invalid db_port %q", conn.DBPort)
+		}
+	}
+	if conn.SSLMode != "" && !sslModes[conn.SSLMode] {
+		return fmt.Errorf("invalid sslmode %q", conn.SSLMode)
+	}
+	if conn.SSLRootCert != "" {
+		if !x509.NewCertPool().AppendCertsFromPEM([]byte(conn.SSLRootCert)) {
+			return errors.New("sslrootcert must contain PEM encoded certificates")
+		}
+	}
+	if conn.SSLKey != "" {
+		if conn.SSLCert == "" {
+			return errors.New("sslkey requires sslcert")
+		}
+		if _, err := tls.X509KeyPair([]byte(conn.SSLCert), []byte(conn.SSLKey)); err != nil {
+			return errors.New("sslcert and sslkey are not a valid pair: " + err.Error())
+		}
+	}
+	for name := range conn.Params {
+		if !paramName.MatchString(name) {
+			return fmt.Errorf("invalid connection parameter %q", name)
+		}
+		// MySQL params are session variables rather than client options
+		if conn.Dialect != models.DialectMySQL && reservedParams[name] {
+			return fmt.Errorf("connection parameter %q cannot be set in params", name)
+		}
+	}
+	return nil
+}
+
+// Postgres returns a key/value connection string for conn. Every value is
+// quoted, so passwords may contain spaces, quotes and backslashes. conn must
+// hold the decrypted password. Certificates are not part of the string; use
+// PostgresConfig to connect.
+func Postgres(conn *models.DatabaseConnection) string {
+	port := conn.DBPort
+	if port == "" {
+		port = DefaultPort(models.DialectPostgres)
+	}
+	applicationName := conn.ApplicationName
+	if applicationName == "" {
+		applicationName = DefaultApplicationName
+	}
+
+	parts := []string{
+		pair("host", conn.DBHost),
+		pair("port", port),
+		pair("user", conn.DBUser),
+		pair("password", conn.DBPassword),
+		pair("dbname", conn.DBName),
+		pair("sslmode", sslMode(conn)),
+		pair("application_name", applicationName),
+	}
+
+	names := make([]string, 0, len(conn.Params))
+	for name := range conn.Params {
+		if !reservedParams[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		parts = append(parts, pair(name, conn.Params[name]))
+	}
+	return strings.Join(parts, " ")
+}
+
+// PostgresConfig parses the connection string for conn and adds its CA and
+// client certificates to the TLS configuration. When dial is not nil, pgx
+// connects through it and leaves host names for the far end to resolve, so
+// hosts only known behind a jump host can be reached.
+func PostgresConfig(conn *models.DatabaseConnection, dial func(ctx context.Context, network, addr string) (net.Conn, error)) (*pgx.ConnConfig, error) {
+	config, err := pgx.ParseConfig(Postgres(conn))
+	if err != nil {
+		return nil, err
+	}
+	if dial != nil {
+		config.DialFunc = dial
+		config.LookupFunc = func(ctx context.Context, host string) ([]string, error) {
+			return []string{host}, nil
+		}
+	}
+
+	var roots *x509.CertPool
+	if conn.SSLRootCert != "" {
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM([]byte(conn.SSLRootCert)) {
+			return nil, errors.New("sslrootcert must contain PEM encoded certificates")
+		}
+	}
+	var certificates []tls.Certificate
+	if conn.SSLCert != "" && conn.SSLKey != "" {
+		certificate, err := tls.X509KeyPair([]byte(conn.SSLCert), []byte(conn.SSLKey))
+		if err != nil {
+			return nil, err
+		}
+		certificates = []tls.Certificate{certificate}
+	}
+
+	// the verify-ca check pgx installs reads RootCAs from the config it was
+	// created with, so the configs are updated in place
+	configure := func(tlsConfig *tls.Config) {
+		if tlsConfig == nil {
+			return
+		}
+		if roots != nil {
+			tlsConfig.RootCAs = roots
+		}
+		tlsConfig.Certificates = certificates
+	}
+	configure(config.TLSConfig)
+	for _, fallback := range config.Fallbacks {
+		configure(fallback.TLSConfig)
+	}
+	return config, nil
+}
+
+// sslMode follows libpq: require with a CA certificate verifies the server
+// certificate like verify-ca.
+func sslMode(conn *models.DatabaseConnection) string {
+	switch {
+	case conn.SSLMode == "":
+		return "prefer"
+	case conn.SSLMode == "require" && conn.SSLRootCert != "":
+		return "verify-ca"
+	}
+	return conn.SSLMode
+}
+
+func pair(key, value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `'`, `\'`)
+	return key + "='" + value + "'"
+}
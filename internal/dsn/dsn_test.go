@@ -0,0 +1,135 @@
+package dsn
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/cr34t1ve/hoprun/pkg/models"
+)
+
+func TestPair(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"secret", `password='secret'`},
+		{"", `password=''`},
+		{"with space", `password='with space'`},
+		{"it's", `password='it\'s'`},
+		{`back\slash`, `password='back\\slash'`},
+		{`\'`, `password='\\\''`},
+		{"a=b host=evil", `password='a=b host=evil'`},
+	}
+	for _, tt := range tests {
+		if got := pair("password", tt.value); got != tt.want {
+			t.Errorf("pair(%q) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestPostgresRoundTrip(t *testing.T) {
+	passwords := []string{"plain", "with space", "it's", `back\slash`, `\'`, "a=b host=evil sslmode=disable"}
+	for _, password := range passwords {
+		conn := &models.DatabaseConnection{
+			DBHost:     "db.example.com",
+			DBName:     "app",
+			DBUser:     "reader",
+			DBPassword: password,
+			SSLMode:    "require",
+			Params:     map[string]string{"connect_timeout": "5"},
+		}
+		config, err := pgx.ParseConfig(Postgres(conn))
+		if err != nil {
+			t.Fatalf("ParseConfig for password %q: %v", password, err)
+		}
+		if config.Password != password {
+			t.Errorf("password = %q, want %q", config.Password, password)
+		}
+		if config.Host != "db.example.com" || config.Port != 5432 || config.Database != "app" {
+			t.Errorf("password %q changed the target: %s:%d/%s", password, config.Host, config.Port, config.Database)
+		}
+		if config.TLSConfig == nil || len(config.Fallbacks) != 0 {
+			t.Errorf("password %q changed sslmode require", password)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := func() *models.DatabaseConnection {
+		return &models.DatabaseConnection{DBHost: "db.example.com", DBName: "app", DBUser: "reader"}
+	}
+	tests := []struct {
+		name   string
+		modify func(conn *models.DatabaseConnection)
+		errMsg string
+	}{
+		{"valid", func(conn *models.DatabaseConnection) {}, ""},
+		{"valid mysql", func(conn *models.DatabaseConnection) { conn.Dialect = models.DialectMySQL; conn.DBPort = "3306" }, ""},
+		{"valid sslmode", func(conn *models.DatabaseConnection) { conn.SSLMode = "verify-full" }, ""},
+		{"unknown dialect", func(conn *models.DatabaseConnection) { conn.Dialect = "oracle" }, "unsupported dialect"},
+		{"missing host", func(conn *models.DatabaseConnection) { conn.DBHost = "" }, "db_host is required"},
+		{"missing user", func(conn *models.DatabaseConnection) { conn.DBUser = "" }, "db_name and db_user are required"},
+		{"port not a number", func(conn *models.DatabaseConnection) { conn.DBPort = "postgres" }, "invalid db_port"},
+		{"port zero", func(conn *models.DatabaseConnection) { conn.DBPort = "0" }, "invalid db_port"},
+		{"port too large", func(conn *models.DatabaseConnection) { conn.DBPort = "65536" }, "invalid db_port"},
+		{"port with host", func(conn *models.DatabaseConnection) { conn.DBPort = "5432 host=evil" }, "invalid db_port"},
+		{"unknown sslmode", func(conn *models.DatabaseConnection) { conn.SSLMode = "verify" }, "invalid sslmode"},
+		{"sslmode case", func(conn *models.DatabaseConnection) { conn.SSLMode = "Require" }, "invalid sslmode"},
+		{"rootcert not PEM", func(conn *models.DatabaseConnection) { conn.SSLRootCert = "not a certificate" }, "sslrootcert"},
+		{"key without cert", func(conn *models.DatabaseConnection) { conn.SSLKey = "key" }, "sslkey requires sslcert"},
+		{"bad param name", func(conn *models.DatabaseConnection) { conn.Params = map[string]string{"a b": "1"} }, "invalid connection parameter"},
+		{"reserved param", func(conn *models.DatabaseConnection) { conn.Params = map[string]string{"host": "evil"} }, "cannot be set in params"},
+		{"mysql session variable", func(conn *models.DatabaseConnection) {
+			conn.Dialect = models.DialectMySQL
+			conn.Params = map[string]string{"time_zone": "+00:00"}
+		}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := valid()
+			tt.modify(conn)
+			err := Validate(conn)
+			if tt.errMsg == "" {
+				if err != nil {
+					t.Fatalf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Fatalf("Validate() = %v, want error containing %q", err, tt.errMsg)
+			}
+		})
+	}
+}
+
+// testCertificate returns a self-signed certificate in PEM.
+func testCertificate(t *testing.T) string {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
@@ -0,0 +1,50 @@
+package dsn
+
+import (
+	"errors"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/cr34t1ve/hoprun/pkg/models"
+)
+
+// validateFile checks a sqlite or duckdb connection. Paths must stay inside
+// the database directory, so they are relative and may not climb out of it.
+// This is only a lexical check; symlinks are followed and checked when the
+// connection service resolves the path against the directory.
+func validateFile(conn *models.DatabaseConnection) error {
+	if conn.FilePath == "" {
+		return errors.New("file_path is required for " + conn.Dialect + " connections")
+	}
+	if !filepath.IsLocal(conn.FilePath) {
+		return errors.New("file_path must be relative to the database directory and stay inside it")
+	}
+	if strings.ContainsAny(conn.FilePath, "?#") {
+		return errors.New("file_path must not contain ? or #")
+	}
+	if conn.SSHHost != "" {
+		return errors.New("ssh_host does not apply to " + conn.Dialect + " connections")
+	}
+	return nil
+}
+
+// SQLite returns a read-only URI for the SQLite database at path. query_only
+// also stops the connection from creating temporary objects.
+func SQLite(path string) string {
+	uri := url.URL{Scheme: "file", Path: path, RawQuery: "mode=ro&_pragma=query_only(1)"}
+	return uri.String()
+}
+
+// DuckDB opens the database at path read-only, with access to other files,
+// extensions and the network turned off, and locks the configuration so a
+// query cannot turn it back on. The driver takes the path up to the first ?
+// as it is but parses the whole string as a URL for the options, so a path
+// with ? or # in it, which could also come from the database directory, is
+// refused: # would turn the options into a fragment and drop them.
+func DuckDB(path string) (string, error) {
+	if strings.ContainsAny(path, "?#") {
+		return "", errors.New("duckdb file path must not contain ? or #")
+	}
+	return path + "?access_mode=READ_ONLY&enable_external_access=false&lock_configuration=true", nil
+}
@@ -0,0 +1,92 @@
+package dsn
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	_ "github.com/glebarez/go-sqlite"
+
+	"github.com/cr34t1ve/hoprun/pkg/models"
+)
+
+func TestValidateFile(t *testing.T) {
+	tests := []struct {
+		path    string
+		allowed bool
+	}{
+		{"sales.db", true},
+		{"reports/2024 q1.db", true},
+		{"", false},
+		{"/etc/passwd", false},
+		{"../outside.db", false},
+		{"sales.db?mode=rw", false},
+		{"sales#1.db", false},
+	}
+	for _, dialect := range []string{models.DialectSQLite, models.DialectDuckDB} {
+		for _, tt := range tests {
+			err := Validate(&models.DatabaseConnection{Dialect: dialect, FilePath: tt.path})
+			if tt.allowed != (err == nil) {
+				t.Errorf("Validate(%s %q) = %v, want allowed %v", dialect, tt.path, err, tt.allowed)
+			}
+		}
+	}
+}
+
+// SQLite escapes the path, so ? and # in it cannot change the options even
+// if they reach it.
+func TestSQLiteReadOnly(t *testing.T) {
+	for _, name := range []string{"plain.db", "with space.db", "a?mode=rw.db", "a#b.db", "a%3Fb.db"} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			created := filepath.Join(dir, "created.db")
+			db, err := sql.Open("sqlite", created)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := db.Exec("CREATE TABLE t (x INTEGER); INSERT INTO t VALUES (1)"); err != nil {
+				t.Fatal(err)
+			}
+			db.Close()
+			path := filepath.Join(dir, name)
+			if err := os.Rename(created, path); err != nil {
+				t.Fatal(err)
+			}
+
+			uri := SQLite(path)
+			if !strings.HasSuffix(uri, "?mode=ro&_pragma=query_only(1)") {
+				t.Fatalf("SQLite(%q) = %s, want the read-only options last", path, uri)
+			}
+			db, err = sql.Open("sqlite", uri)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			var x int
+			if err := db.QueryRow("SELECT x FROM t").Scan(&x); err != nil || x != 1 {
+				t.Fatalf("read = %d, %v; want 1", x, err)
+			}
+			if _, err := db.Exec("INSERT INTO t VALUES (2)"); err == nil {
+				t.Fatal("write through the read-only URI succeeded")
+			}
+		})
+	}
+}
+
+func TestDuckDB(t *testing.T) {
+	source, err := DuckDB("/data/sales.duckdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "/data/sales.duckdb?access_mode=READ_ONLY&enable_external_access=false&lock_configuration=true"; source != want {
+		t.Errorf("DuckDB() = %s, want %s", source, want)
+	}
+	// the driver would read the options after # as a URL fragment
+	for _, path := range []string{"/data/a?access_mode=READ_WRITE", "/data/a#b.duckdb", "/data#1/sales.duckdb"} {
+		if source, err := DuckDB(path); err == nil {
+			t.Errorf("DuckDB(%q) = %s, want an error", path, source)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package dsn
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+
+	"github.com/cr34t1ve/hoprun/pkg/models"
+)
+
+const mysqlDialTimeout = 10 * time.Second
+
+// MySQLConfig returns the driver configuration for a MySQL or MariaDB
+// connection. The sslmode values are mapped onto the driver's TLS settings
+// with libpq semantics, and params are set as session variables;
+// application_name only applies to Postgres. When dial is not nil the driver
+// connects through it, e.g. over an SSH tunnel. conn must hold the decrypted
+// password.
+func MySQLConfig(conn *models.DatabaseConnection, dial func(ctx context.Context, network, addr string) (net.Conn, error)) (*mysql.Config, error) {
+	port := conn.DBPort
+	if port == "" {
+		port = DefaultPort(models.DialectMySQL)
+	}
+
+	config := mysql.NewConfig()
+	config.User = conn.DBUser
+	config.Passwd = conn.DBPassword
+	config.Net = "tcp"
+	config.Addr = net.JoinHostPort(conn.DBHost, port)
+	config.DBName = conn.DBName
+	config.Timeout = mysqlDialTimeout
+	config.ParseTime = true
+	config.Params = make(map[string]string, len(conn.Params))
+	for name, value := range conn.Params {
+		// the driver sends params as SET name=value without quoting
+		config.Params[name] = quoteMySQL(value)
+	}
+
+	// set on the config rather than registered with the driver, so each pool
+	// and health check keeps its own dialer
+	config.DialFunc = dial
+
+	tlsConfig, err := mysqlTLS(conn)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig == nil {
+		config.TLSConfig = "false"
+	} else {
+		config.TLS = tlsConfig
+		config.AllowFallbackToPlaintext = conn.SSLMode == "" || conn.SSLMode == "allow" || conn.SSLMode == "prefer"
+	}
+	return config, nil
+}
+
+func mysqlTLS(conn *models.DatabaseConnection) (*tls.Config, error) {
+	mode := sslMode(conn)
+	if mode == "disable" {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{}
+	var roots *x509.CertPool
+	if conn.SSLRootCert != "" {
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM([]byte(conn.SSLRootCert)) {
+			return nil, errors.New("sslrootcert must contain PEM encoded certificates")
+		}
+	}
+	if conn.SSLCert != "" && conn.SSLKey != "" {
+		certificate, err := tls.X509KeyPair([]byte(conn.SSLCert), []byte(conn.SSLKey))
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	switch mode {
+	case "allow", "prefer", "require":
+		tlsConfig.InsecureSkipVerify = true
+	case "verify-ca":
+		// verify the chain but not the host name, as libpq does
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return verifyChain(rawCerts, roots)
+		}
+	case "verify-full":
+		tlsConfig.RootCAs = roots
+		tlsConfig.ServerName = conn.DBHost
+	}
+	return tlsConfig, nil
+}
+
+func verifyChain(rawCerts [][]byte, roots *x509.CertPool) error {
+	if len(rawCerts) == 0 {
+		return errors.New("server sent no certificate")
+	}
+	opts := x509.VerifyOptions{Roots: roots, Intermediates: x509.NewCertPool()}
+	var leaf *x509.Certificate
+	for i, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return errors.New("failed to parse certificate from server: " + err.Error())
+		}
+		if i == 0 {
+			leaf = cert
+		} else {
+			opts.Intermediates.AddCert(cert)
+		}
+	}
+	_, err := leaf.Verify(opts)
+	return err
+}
+
+func quoteMySQL(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `'`, `\'`)
+	return "'" + value + "'"
+}
@@ -0,0 +1,98 @@
+package dsn
+
+import (
+	"testing"
+
+	"github.com/cr34t1ve/hoprun/pkg/models"
+)
+
+func TestMySQLConfigSSLMode(t *testing.T) {
+	rootCert := testCertificate(t)
+	tests := []struct {
+		sslMode    string
+		rootCert   string
+		plaintext  bool
+		fallback   bool
+		skipVerify bool
+		verifyCA   bool
+		serverName string
+	}{
+		{sslMode: "disable", plaintext: true},
+		{sslMode: "", fallback: true, skipVerify: true},
+		{sslMode: "allow", fallback: true, skipVerify: true},
+		{sslMode: "prefer", fallback: true, skipVerify: true},
+		{sslMode: "require", skipVerify: true},
+		// libpq verifies the CA for require once a root certificate is given
+		{sslMode: "require", rootCert: rootCert, skipVerify: true, verifyCA: true},
+		{sslMode: "verify-ca", rootCert: rootCert, skipVerify: true, verifyCA: true},
+		{sslMode: "verify-full", rootCert: rootCert, serverName: "db.example.com"},
+	}
+	for _, tt := range tests {
+		name := tt.sslMode
+		if tt.rootCert != "" {
+			name += " with sslrootcert"
+		}
+		t.Run(name, func(t *testing.T) {
+			conn := &models.DatabaseConnection{
+				Dialect:     models.DialectMySQL,
+				DBHost:      "db.example.com",
+				DBName:      "app",
+				DBUser:      "reader",
+				SSLMode:     tt.sslMode,
+				SSLRootCert: tt.rootCert,
+			}
+			config, err := MySQLConfig(conn, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config.Addr != "db.example.com:3306" {
+				t.Errorf("Addr = %s, want the default MySQL port", config.Addr)
+			}
+			if tt.plaintext {
+				if config.TLS != nil || config.TLSConfig != "false" {
+					t.Fatalf("TLS = %v, TLSConfig = %q, want TLS off", config.TLS, config.TLSConfig)
+				}
+				return
+			}
+			if config.TLS == nil {
+				t.Fatal("TLS is off")
+			}
+			if config.AllowFallbackToPlaintext != tt.fallback {
+				t.Errorf("AllowFallbackToPlaintext = %v, want %v", config.AllowFallbackToPlaintext, tt.fallback)
+			}
+			if config.TLS.InsecureSkipVerify != tt.skipVerify {
+				t.Errorf("InsecureSkipVerify = %v, want %v", config.TLS.InsecureSkipVerify, tt.skipVerify)
+			}
+			if (config.TLS.VerifyPeerCertificate != nil) != tt.verifyCA {
+				t.Errorf("VerifyPeerCertificate set = %v, want %v", config.TLS.VerifyPeerCertificate != nil, tt.verifyCA)
+			}
+			if config.TLS.ServerName != tt.serverName {
+				t.Errorf("ServerName = %q, want %q", config.TLS.ServerName, tt.serverName)
+			}
+			if tt.serverName != "" && config.TLS.RootCAs == nil {
+				t.Error("RootCAs not set for verify-full")
+			}
+		})
+	}
+}
+
+func TestMySQLConfigParams(t *testing.T) {
+	conn := &models.DatabaseConnection{
+		Dialect: models.DialectMySQL,
+		DBHost:  "db.example.com",
+		DBPort:  "3307",
+		DBName:  "app",
+		DBUser:  "reader",
+		Params:  map[string]string{"time_zone": `+00:00'; DROP TABLE t; \`},
+	}
+	config, err := MySQLConfig(conn, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Addr != "db.example.com:3307" {
+		t.Errorf("Addr = %s", config.Addr)
+	}
+	if want := `'+00:00\'; DROP TABLE t; \\'`; config.Params["time_zone"] != want {
+		t.Errorf("time_zone = %s, want %s", config.Params["time_zone"], want)
+	}
+}
@@ -2,33 +2,117 @@ package middleware
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"strings"
 
+	apikey "github.com/cr34t1ve/hoprun/internal/api_key"
 	"github.com/cr34t1ve/hoprun/internal/auth"
+	"github.com/cr34t1ve/hoprun/pkg/models"
 )
 
-func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			http.Error(w, "Missing auth token", http.StatusUnauthorized)
-			return
-		}
+type contextKey int
 
-		bearerToken := strings.Split(authHeader, " ")
-		if len(bearerToken) != 2 {
-			http.Error(w, "Invalid token format", http.StatusUnauthorized)
-			return
-		}
+const (
+	userIDKey contextKey = iota
+	tokenIDKey
+	apiKeyKey
+)
+
+// AuthMiddleware rejects requests without a valid bearer token and stores the
+// token's user on the request context, where handlers read it with UserID.
+// The bearer token is either a JWT access token or a project API key; a key
+// acts as the user who created it, limited to its project and scopes.
+func AuthMiddleware(authService auth.Service, apiKeys apikey.Service) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authHeader := r.Header.Get("Authorization")
+			if authHeader == "" {
+				http.Error(w, "Missing auth token", http.StatusUnauthorized)
+				return
+			}
+
+			bearerToken := strings.Split(authHeader, " ")
+			if len(bearerToken) != 2 || !strings.EqualFold(bearerToken[0], "Bearer") {
+				http.Error(w, "Invalid token format", http.StatusUnauthorized)
+				return
+			}
+
+			if strings.HasPrefix(bearerToken[1], apikey.KeyPrefix) {
+				key, err := apiKeys.ValidateKey(r.Context(), bearerToken[1])
+				if err != nil {
+					if errors.Is(err, apikey.ErrInvalidKey) {
+						http.Error(w, "Invalid API key", http.StatusUnauthorized)
+						return
+					}
+					http.Error(w, "Failed to validate API key: "+err.Error(), http.StatusInternalServerError)
+					return
+				}
+
+				ctx := context.WithValue(r.Context(), userIDKey, key.CreatedBy)
+				ctx = context.WithValue(ctx, apiKeyKey, key)
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+
+			claims, err := authService.ValidateToken(r.Context(), bearerToken[1])
+			if err != nil {
+				if errors.Is(err, auth.ErrInvalidToken) {
+					http.Error(w, "Invalid token", http.StatusUnauthorized)
+					return
+				}
+				http.Error(w, "Failed to validate token: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), userIDKey, claims.UserID)
+			ctx = context.WithValue(ctx, tokenIDKey, claims.ID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// RequireScope limits API keys to routes their scopes allow. Requests made
+// with a user's access token pass through.
+func RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if key, ok := APIKey(r.Context()); ok && !key.HasScope(scope) {
+				http.Error(w, "API key lacks the "+scope+" scope", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
 
-		claims, err := auth.Service.ValidateToken(bearerToken[1])
-		if err != nil {
-			http.Error(w, "Invalid token", http.StatusUnauthorized)
+// RequireUser rejects API keys from routes that act on the user rather than a
+// project, such as creating projects, logging out and managing API keys.
+func RequireUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := APIKey(r.Context()); ok {
+			http.Error(w, "This route cannot be called with an API key", http.StatusForbidden)
 			return
 		}
+		next.ServeHTTP(w, r)
+	})
+}
 
-		ctx := context.WithValue(r.Context(), "user_id", claims.UserID)
-		next.ServeHTTP(w, r.WithContext(ctx))
-	}
+// APIKey returns the key the request was authenticated with, if any.
+func APIKey(ctx context.Context) (*models.APIKey, bool) {
+	key, ok := ctx.Value(apiKeyKey).(*models.APIKey)
+	return key, ok
+}
+
+// TokenID returns the jti of the access token the request was authenticated
+// with.
+func TokenID(ctx context.Context) (string, bool) {
+	tokenID, ok := ctx.Value(tokenIDKey).(string)
+	return tokenID, ok
+}
+
+// UserID returns the user authenticated by AuthMiddleware.
+func UserID(ctx context.Context) (int, bool) {
+	userID, ok := ctx.Value(userIDKey).(int)
+	return userID, ok
 }
@@ -2,54 +2,134 @@ package nlp
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
 
-	"github.com/sashabaranov/go-openai"
+	"github.com/cr34t1ve/hoprun/pkg/models"
 )
 
 type Service interface {
-	NaturalLanguageToSQL(query string, dbSchema string) (string, error)
+	// dialect is one of the models.Dialect constants and decides which SQL
+	// dialect the model is asked to write.
+	NaturalLanguageToSQL(ctx context.Context, query, dialect, dbSchema, businessContext string, settings Settings) (string, error)
+	RepairSQL(ctx context.Context, query, dialect, dbSchema, businessContext string, attempts []models.QueryAttempt, settings Settings) (string, error)
+	ValidateSettings(settings Settings) error
 }
 
 type service struct {
-	client *openai.Client
+	providers map[string]Provider
+	defaults  Settings
 }
 
-func NewService(apiKey string) Service {
+func NewService(providers map[string]Provider, defaults Settings) Service {
 	return &service{
-		client: openai.NewClient(apiKey),
+		providers: providers,
+		defaults:  defaults,
 	}
 }
 
-func (s *service) NaturalLanguageToSQL(query string, dbSchema string) (string, error) {
-	prompt := fmt.Sprintf(`You are a SQL expert. Given the following database schema:
+func (s *service) NaturalLanguageToSQL(ctx context.Context, query, dialect, dbSchema, businessContext string, settings Settings) (string, error) {
+	return s.complete(ctx, []Message{
+		{
+			Role:    RoleSystem,
+			Content: buildPrompt(query, dialect, dbSchema, businessContext),
+		},
+	}, settings)
+}
 
-%s
+// RepairSQL asks the model to correct a query that failed to execute. The
+// conversation replays every previous attempt together with its database
+// error so the model does not repeat a fix that already failed.
+func (s *service) RepairSQL(ctx context.Context, query, dialect, dbSchema, businessContext string, attempts []models.QueryAttempt, settings Settings) (string, error) {
+	messages := []Message{
+		{
+			Role:    RoleSystem,
+			Content: buildPrompt(query, dialect, dbSchema, businessContext),
+		},
+	}
+	for _, attempt := range attempts {
+		messages = append(messages,
+			Message{
+				Role:    RoleAssistant,
+				Content: attempt.SQL,
+			},
+			Message{
+				Role: RoleUser,
+				Content: fmt.Sprintf(`The query above failed with the following database error:
 
-Convert the following natural language query to SQL:
 %s
 
-Return only the SQL query without any markdown formatting, explanations, or additional text.`, dbSchema, query)
-
-	resp, err := s.client.CreateChatCompletion(
-		context.Background(),
-		openai.ChatCompletionRequest{
-			Model: openai.GPT3Dot5Turbo,
-			Messages: []openai.ChatCompletionMessage{
-				{
-					Role:    openai.ChatMessageRoleSystem,
-					Content: prompt,
-				},
+Fix the query so it answers the original question. Return only the corrected SQL query without any markdown formatting, explanations, or additional text.`, attempt.Error),
 			},
-		},
-	)
+		)
+	}
+	return s.complete(ctx, messages, settings)
+}
+
+func buildPrompt(query, dialect, dbSchema, businessContext string) string {
+	d, ok := dialects[dialect]
+	if !ok {
+		d = dialects[models.DialectPostgres]
+	}
+	return fmt.Sprintf(`You are a %s SQL expert. Given the following database schema:
+
+%s
+%s
+Convert the following natural language query to a single %s SELECT statement:
+%s
+
+Always reference tables by their fully qualified schema.table names as shown in the schema.
+%s
+Return only the SQL query without any markdown formatting, explanations, or additional text.`, d.name, dbSchema, formatBusinessContext(businessContext), d.name, query, d.rules)
+}
+
+type sqlDialect struct {
+	name  string
+	rules string
+}
 
+// dialects holds the syntax reminders for each database, covering the
+// places models most often mix dialects up: quoting, dates and matching.
+var dialects = map[string]sqlDialect{
+	models.DialectPostgres: {
+		name: "PostgreSQL",
+		rules: `Quote identifiers with double quotes when needed and strings with single quotes.
+Use ILIKE for case-insensitive matching, date_trunc and extract for dates, and interval arithmetic such as now() - interval '7 days'.`,
+	},
+	models.DialectMySQL: {
+		name: "MySQL",
+		rules: `Quote identifiers with backticks when needed and strings with single quotes; never use double quotes for identifiers.
+There is no ILIKE: use LOWER(column) LIKE LOWER('pattern'). Use LIMIT n OFFSET m for paging.
+Give every selected column a unique name, aliasing columns that share a name across joined tables.
+For dates use NOW(), CURDATE(), DATE_SUB(NOW(), INTERVAL 7 DAY), DATE_FORMAT and TIMESTAMPDIFF rather than PostgreSQL functions such as date_trunc or ::casts.`,
+	},
+	models.DialectSQLite: {
+		name: "SQLite",
+		rules: `Quote identifiers with double quotes when needed and strings with single quotes.
+SQLite has no date type: dates are stored as text, numbers or Julian days, so use date(), datetime(), strftime() and julianday(), e.g. date('now', '-7 days').
+There is no ILIKE; LIKE is already case-insensitive for ASCII. Use || to concatenate and CAST(x AS REAL) before dividing integers.`,
+	},
+	models.DialectDuckDB: {
+		name: "DuckDB",
+		rules: `Quote identifiers with double quotes when needed and strings with single quotes.
+Use ILIKE for case-insensitive matching, date_trunc, date_diff and interval arithmetic such as current_date - INTERVAL 7 DAY.
+DuckDB supports GROUP BY ALL, QUALIFY and list functions; do not read files with read_csv, read_parquet or file paths.`,
+	},
+}
+
+func (s *service) complete(ctx context.Context, messages []Message, settings Settings) (string, error) {
+	settings = s.resolve(settings)
+	provider, ok := s.providers[settings.Provider]
+	if !ok {
+		return "", fmt.Errorf("LLM provider %q is not configured", settings.Provider)
+	}
+
+	sqlQuery, err := provider.Complete(ctx, messages, settings)
 	if err != nil {
 		return "", err
 	}
 
-	sqlQuery := resp.Choices[0].Message.Content
 	sqlQuery = strings.TrimSpace(sqlQuery)
 	sqlQuery = strings.TrimPrefix(sqlQuery, "```sql")
 	sqlQuery = strings.TrimPrefix(sqlQuery, "```")
@@ -58,3 +138,51 @@ Return only the SQL query without any markdown formatting, explanations, or addi
 
 	return sqlQuery, nil
 }
+
+func (s *service) ValidateSettings(settings Settings) error {
+	if settings.Provider != "" {
+		if _, ok := s.providers[settings.Provider]; !ok {
+			return fmt.Errorf("LLM provider %q is not configured", settings.Provider)
+		}
+	}
+	if settings.Temperature != nil && (*settings.Temperature < 0 || *settings.Temperature > 2) {
+		return errors.New("temperature must be between 0 and 2")
+	}
+	if settings.MaxTokens < 0 {
+		return errors.New("max tokens must not be negative")
+	}
+	return nil
+}
+
+// resolve fills unset fields of a project's settings from the deployment
+// defaults. The default model only applies when the project keeps the
+// default provider, since model names differ between providers; otherwise
+// the provider's own default model is used.
+func (s *service) resolve(settings Settings) Settings {
+	if settings.Provider == "" {
+		settings.Provider = s.defaults.Provider
+	}
+	if settings.Model == "" && settings.Provider == s.defaults.Provider {
+		settings.Model = s.defaults.Model
+	}
+	if settings.Temperature == nil {
+		settings.Temperature = s.defaults.Temperature
+	}
+	if settings.MaxTokens == 0 {
+		settings.MaxTokens = s.defaults.MaxTokens
+	}
+	return settings
+}
+
+// formatBusinessContext wraps the project's context documents so the model
+// treats them as definitions to follow rather than part of the question.
+func formatBusinessContext(businessContext string) string {
+	if businessContext == "" {
+		return ""
+	}
+	return fmt.Sprintf(`
+Use the following business context when interpreting the query. Definitions and rules here take precedence over assumptions drawn from table or column names:
+
+%s
+`, businessContext)
+}
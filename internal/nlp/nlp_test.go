@@ -0,0 +1,184 @@
+package nlp
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/cr34t1ve/hoprun/pkg/models"
+)
+
+func TestNaturalLanguageToSQLPrompt(t *testing.T) {
+	fake := NewFakeProvider("SELECT 1").
+		On("top customers", "```sql\nSELECT name FROM public.customers ORDER BY revenue DESC\n```")
+	s := NewService(map[string]Provider{"fake": fake}, Settings{Provider: "fake"})
+
+	sql, err := s.NaturalLanguageToSQL(context.Background(), "top customers", models.DialectMySQL,
+		"TABLE shop.customers (name text, revenue numeric)", "Revenue excludes refunds.", Settings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sql != "SELECT name FROM public.customers ORDER BY revenue DESC" {
+		t.Errorf("sql = %q, want the response without markdown", sql)
+	}
+
+	requests := fake.Requests()
+	if len(requests) != 1 || len(requests[0].Messages) != 1 {
+		t.Fatalf("requests = %+v, want one single-message request", requests)
+	}
+	message := requests[0].Messages[0]
+	if message.Role != RoleSystem {
+		t.Errorf("role = %s, want %s", message.Role, RoleSystem)
+	}
+	for _, want := range []string{
+		"You are a MySQL SQL expert",
+		"TABLE shop.customers (name text, revenue numeric)",
+		"Revenue excludes refunds.",
+		"top customers",
+		"backticks",
+	} {
+		if !strings.Contains(message.Content, want) {
+			t.Errorf("prompt does not contain %q:\n%s", want, message.Content)
+		}
+	}
+	if strings.Contains(message.Content, "ILIKE for") {
+		t.Errorf("MySQL prompt carries the PostgreSQL rules:\n%s", message.Content)
+	}
+}
+
+func TestPromptDialects(t *testing.T) {
+	tests := []struct {
+		dialect string
+		name    string
+	}{
+		{models.DialectPostgres, "PostgreSQL"},
+		{models.DialectMySQL, "MySQL"},
+		{models.DialectSQLite, "SQLite"},
+		{models.DialectDuckDB, "DuckDB"},
+		{"", "PostgreSQL"},
+	}
+	for _, tt := range tests {
+		prompt := buildPrompt("q", tt.dialect, "schema", "")
+		if !strings.Contains(prompt, "You are a "+tt.name+" SQL expert") {
+			t.Errorf("prompt for %q does not name %s:\n%s", tt.dialect, tt.name, prompt)
+		}
+		if strings.Contains(prompt, "business context") {
+			t.Errorf("prompt for %q mentions business context without any", tt.dialect)
+		}
+	}
+}
+
+func TestRepairSQLReplaysAttempts(t *testing.T) {
+	fake := NewFakeProvider("SELECT id FROM public.orders")
+	s := NewService(map[string]Provider{"fake": fake}, Settings{Provider: "fake"})
+
+	attempts := []models.QueryAttempt{
+		{SQL: "SELECT idd FROM public.orders", Error: `ERROR: column "idd" does not exist (SQLSTATE 42703)`},
+		{SQL: "SELECT order_id FROM public.orders", Error: `ERROR: column "order_id" does not exist (SQLSTATE 42703)`},
+	}
+	if _, err := s.RepairSQL(context.Background(), "order ids", models.DialectPostgres, "schema", "", attempts, Settings{}); err != nil {
+		t.Fatal(err)
+	}
+
+	messages := fake.Requests()[0].Messages
+	if len(messages) != 1+2*len(attempts) {
+		t.Fatalf("got %d messages, want the prompt and two per attempt", len(messages))
+	}
+	for i, attempt := range attempts {
+		sql, feedback := messages[1+2*i], messages[2+2*i]
+		if sql.Role != RoleAssistant || sql.Content != attempt.SQL {
+			t.Errorf("message %d = %+v, want the attempted SQL from the assistant", 1+2*i, sql)
+		}
+		if feedback.Role != RoleUser || !strings.Contains(feedback.Content, attempt.Error) {
+			t.Errorf("message %d = %+v, want the database error from the user", 2+2*i, feedback)
+		}
+	}
+}
+
+func TestProviderSelection(t *testing.T) {
+	openai := NewFakeProvider("SELECT 'openai'")
+	local := NewFakeProvider("SELECT 'local'")
+	temperature := float32(0.2)
+	s := NewService(map[string]Provider{"openai": openai, "local": local}, Settings{
+		Provider:    "openai",
+		Model:       "gpt-4o",
+		Temperature: &temperature,
+		MaxTokens:   500,
+	})
+
+	projectTemperature := float32(0)
+	tests := []struct {
+		name     string
+		settings Settings
+		want     string
+		provider *FakeProvider
+		expected Settings
+	}{
+		{"defaults", Settings{}, "SELECT 'openai'", openai,
+			Settings{Provider: "openai", Model: "gpt-4o", Temperature: &temperature, MaxTokens: 500}},
+		{"project model", Settings{Model: "gpt-4o-mini", MaxTokens: 100}, "SELECT 'openai'", openai,
+			Settings{Provider: "openai", Model: "gpt-4o-mini", Temperature: &temperature, MaxTokens: 100}},
+		// the default model belongs to the default provider
+		{"other provider", Settings{Provider: "local"}, "SELECT 'local'", local,
+			Settings{Provider: "local", Temperature: &temperature, MaxTokens: 500}},
+		{"zero temperature", Settings{Provider: "local", Model: "llama3", Temperature: &projectTemperature}, "SELECT 'local'", local,
+			Settings{Provider: "local", Model: "llama3", Temperature: &projectTemperature, MaxTokens: 500}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := len(tt.provider.Requests())
+			sql, err := s.NaturalLanguageToSQL(context.Background(), "q", models.DialectPostgres, "schema", "", tt.settings)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sql != tt.want {
+				t.Errorf("sql = %q, want %q", sql, tt.want)
+			}
+			requests := tt.provider.Requests()
+			if len(requests) != before+1 {
+				t.Fatalf("provider got %d requests, want %d", len(requests), before+1)
+			}
+			got := requests[len(requests)-1].Settings
+			if got.Provider != tt.expected.Provider || got.Model != tt.expected.Model || got.MaxTokens != tt.expected.MaxTokens || got.Temperature != tt.expected.Temperature {
+				t.Errorf("settings = %+v, want %+v", got, tt.expected)
+			}
+		})
+	}
+
+	if _, err := s.NaturalLanguageToSQL(context.Background(), "q", models.DialectPostgres, "schema", "", Settings{Provider: "anthropic"}); err == nil {
+		t.Error("unconfigured provider used")
+	}
+}
+
+func TestValidateSettings(t *testing.T) {
+	s := NewService(map[string]Provider{"fake": NewFakeProvider("SELECT 1")}, Settings{Provider: "fake"})
+	high, negative := float32(2.5), float32(-0.1)
+	tests := []struct {
+		settings Settings
+		valid    bool
+	}{
+		{Settings{}, true},
+		{Settings{Provider: "fake", MaxTokens: 100}, true},
+		{Settings{Provider: "missing"}, false},
+		{Settings{Temperature: &high}, false},
+		{Settings{Temperature: &negative}, false},
+		{Settings{MaxTokens: -1}, false},
+	}
+	for _, tt := range tests {
+		if err := s.ValidateSettings(tt.settings); (err == nil) != tt.valid {
+			t.Errorf("ValidateSettings(%+v) = %v, want valid %v", tt.settings, err, tt.valid)
+		}
+	}
+}
+
+func TestFakeProviderHonoursCancellation(t *testing.T) {
+	fake := NewFakeProvider("SELECT 1")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := fake.Complete(ctx, []Message{{Role: RoleUser, Content: "q"}}, Settings{}); err == nil {
+		t.Fatal("cancelled request answered")
+	}
+	if len(fake.Requests()) != 0 {
+		t.Fatal("cancelled request recorded")
+	}
+}
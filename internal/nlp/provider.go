@@ -0,0 +1,213 @@
+package nlp
+
+import (
+	"context"
+	"errors"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+const (
+	RoleSystem    = openai.ChatMessageRoleSystem
+	RoleUser      = openai.ChatMessageRoleUser
+	RoleAssistant = openai.ChatMessageRoleAssistant
+)
+
+type Message struct {
+	Role    string
+	Content string
+}
+
+// Settings selects the provider and generation parameters for a request.
+// Zero fields fall back to the service defaults; a nil Temperature means the
+// default temperature rather than zero.
+type Settings struct {
+	Provider    string   `json:"provider"`
+	Model       string   `json:"model"`
+	Temperature *float32 `json:"temperature"`
+	MaxTokens   int      `json:"max_tokens"`
+}
+
+// Provider generates a chat completion. Implementations must be safe for
+// concurrent use.
+type Provider interface {
+	Complete(ctx context.Context, messages []Message, settings Settings) (string, error)
+}
+
+type openAIProvider struct {
+	client       *openai.Client
+	defaultModel string
+}
+
+// NewOpenAIProvider returns a provider for the OpenAI API or any server that
+// implements its chat completions endpoint, such as Ollama, vLLM or
+// llama.cpp. An empty baseURL uses api.openai.com; self-hosted servers usually
+// accept an empty apiKey. defaultModel is used when a request names no model.
+func NewOpenAIProvider(apiKey, baseURL, defaultModel string) Provider {
+	config := openai.DefaultConfig(apiKey)
+	if baseURL != "" {
+		config.BaseURL = strings.TrimSuffix(baseURL, "/")
+	}
+	return &openAIProvider{
+		client:       openai.NewClientWithConfig(config),
+		defaultModel: defaultModel,
+	}
+}
+
+func (p *openAIProvider) Complete(ctx context.Context, messages []Message, settings Settings) (string, error) {
+	req := openai.ChatCompletionRequest{
+		Model:     settings.Model,
+		MaxTokens: settings.MaxTokens,
+	}
+	if req.Model == "" {
+		req.Model = p.defaultModel
+	}
+	if req.Model == "" {
+		return "", errors.New("no model configured for provider")
+	}
+	if settings.Temperature != nil {
+		req.Temperature = *settings.Temperature
+		// the client omits a zero temperature, which the API reads as its
+		// default of 1, so send the smallest non-zero value instead
+		if req.Temperature == 0 {
+			req.Temperature = math.SmallestNonzeroFloat32
+		}
+	}
+	for _, message := range messages {
+		req.Messages = append(req.Messages, openai.ChatCompletionMessage{
+			Role:    message.Role,
+			Content: message.Content,
+		})
+	}
+
+	resp, err := p.client.CreateChatCompletion(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	if len(resp.Choices) == 0 {
+		return "", errors.New("provider returned no choices")
+	}
+	return resp.Choices[0].Message.Content, nil
+}
+
+// FakeRequest is a completion request recorded by FakeProvider.
+type FakeRequest struct {
+	Messages []Message
+	Settings Settings
+}
+
+// FakeProvider is a deterministic Provider for tests and demos. It answers
+// with the response of the first rule whose substring appears in the last
+// message, or with the default response when no rule matches, and records
+// every request it receives.
+type FakeProvider struct {
+	mu       sync.Mutex
+	rules    []fakeRule
+	fallback string
+	requests []FakeRequest
+}
+
+type fakeRule struct {
+	match    string
+	response string
+}
+
+func NewFakeProvider(fallback string) *FakeProvider {
+	return &FakeProvider{fallback: fallback}
+}
+
+// On registers response for prompts whose last message contains match.
+func (p *FakeProvider) On(match, response string) *FakeProvider {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rules = append(p.rules, fakeRule{match: match, response: response})
+	return p
+}
+
+func (p *FakeProvider) Complete(ctx context.Context, messages []Message, settings Settings) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.requests = append(p.requests, FakeRequest{
+		Messages: append([]Message(nil), messages...),
+		Settings: settings,
+	})
+
+	var last string
+	if len(messages) > 0 {
+		last = messages[len(messages)-1].Content
+	}
+	for _, rule := range p.rules {
+		if strings.Contains(last, rule.match) {
+			return rule.response, nil
+		}
+	}
+	return p.fallback, nil
+}
+
+// Requests returns the requests received so far.
+func (p *FakeProvider) Requests() []FakeRequest {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]FakeRequest(nil), p.requests...)
+}
+
+// ProvidersFromEnv builds the deployment's providers and default settings:
+//
+//	OPENAI_API_KEY, OPENAI_BASE_URL     register the "openai" provider
+//	LOCAL_LLM_BASE_URL, LOCAL_LLM_MODEL register "local", an OpenAI-compatible server
+//	LOCAL_LLM_API_KEY                   API key sent to "local", if it requires one
+//	LLM_FAKE_RESPONSE                   registers "fake", answering every prompt with it
+//	LLM_PROVIDER                        default provider (defaults to "openai")
+//	LLM_MODEL, LLM_TEMPERATURE, LLM_MAX_TOKENS  default generation settings
+//
+// Base URLs are only configurable here rather than per project so that API
+// keys are never sent to hosts chosen by a project.
+func ProvidersFromEnv() (map[string]Provider, Settings, error) {
+	providers := make(map[string]Provider)
+	if key, baseURL := os.Getenv("OPENAI_API_KEY"), os.Getenv("OPENAI_BASE_URL"); key != "" || baseURL != "" {
+		providers["openai"] = NewOpenAIProvider(key, baseURL, openai.GPT3Dot5Turbo)
+	}
+	if baseURL := os.Getenv("LOCAL_LLM_BASE_URL"); baseURL != "" {
+		providers["local"] = NewOpenAIProvider(os.Getenv("LOCAL_LLM_API_KEY"), baseURL, os.Getenv("LOCAL_LLM_MODEL"))
+	}
+	if response := os.Getenv("LLM_FAKE_RESPONSE"); response != "" {
+		providers["fake"] = NewFakeProvider(response)
+	}
+
+	defaults := Settings{
+		Provider: os.Getenv("LLM_PROVIDER"),
+		Model:    os.Getenv("LLM_MODEL"),
+	}
+	if defaults.Provider == "" {
+		defaults.Provider = "openai"
+	}
+	if value := os.Getenv("LLM_TEMPERATURE"); value != "" {
+		temperature, err := strconv.ParseFloat(value, 32)
+		if err != nil {
+			return nil, Settings{}, errors.New("invalid LLM_TEMPERATURE: " + err.Error())
+		}
+		t := float32(temperature)
+		defaults.Temperature = &t
+	}
+	if value := os.Getenv("LLM_MAX_TOKENS"); value != "" {
+		maxTokens, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, Settings{}, errors.New("invalid LLM_MAX_TOKENS: " + err.Error())
+		}
+		defaults.MaxTokens = maxTokens
+	}
+
+	if _, ok := providers[defaults.Provider]; !ok {
+		return nil, Settings{}, errors.New("default LLM provider " + defaults.Provider + " is not configured")
+	}
+	return providers, defaults, nil
+}
@@ -0,0 +1,219 @@
+package projectcontext
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/cr34t1ve/hoprun/pkg/models"
+)
+
+const (
+	MaxDocumentSize = 16 * 1024
+	MaxProjectSize  = 64 * 1024
+)
+
+var (
+	ErrDocumentTooLarge = fmt.Errorf("context document exceeds %d bytes", MaxDocumentSize)
+	ErrProjectTooLarge  = fmt.Errorf("project context exceeds %d bytes", MaxProjectSize)
+)
+
+type Service interface {
+	AddDocument(ctx context.Context, projectID int, title, content string) (*models.ContextDocument, error)
+	UpdateDocument(ctx context.Context, documentID int, title, content string) (*models.ContextDocument, error)
+	DeleteDocument(ctx context.Context, documentID int) error
+	GetDocument(ctx context.Context, documentID int) (*models.ContextDocument, error)
+	ListDocuments(ctx context.Context, projectID int) (*[]models.ContextDocument, error)
+	ListDocumentVersions(ctx context.Context, documentID int) (*[]models.ContextDocumentVersion, error)
+	// GetDocumentProjectID returns the project a document belongs or
+	// belonged to, so the history of deleted documents stays reachable.
+	GetDocumentProjectID(ctx context.Context, documentID int) (int, error)
+	GetPromptContext(ctx context.Context, projectID int) (string, []models.ContextDocumentRef, error)
+}
+
+type service struct {
+	db *gorm.DB
+}
+
+func NewService(db *gorm.DB) Service {
+	return &service{db: db}
+}
+
+func (s *service) AddDocument(ctx context.Context, projectID int, title, content string) (*models.ContextDocument, error) {
+	if len(content) > MaxDocumentSize {
+		return nil, ErrDocumentTooLarge
+	}
+
+	document := &models.ContextDocument{
+		ProjectID: projectID,
+		Title:     title,
+		Content:   content,
+		Version:   1,
+	}
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := checkProjectSize(tx, projectID, 0, len(content)); err != nil {
+			return err
+		}
+		if err := tx.Create(document).Error; err != nil {
+			return err
+		}
+		return tx.Create(newVersion(document)).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return document, nil
+}
+
+func (s *service) UpdateDocument(ctx context.Context, documentID int, title, content string) (*models.ContextDocument, error) {
+	if len(content) > MaxDocumentSize {
+		return nil, ErrDocumentTooLarge
+	}
+
+	var document models.ContextDocument
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// the row is locked so concurrent edits each get their own version
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&document, documentID).Error; err != nil {
+			return err
+		}
+		if err := checkProjectSize(tx, document.ProjectID, document.ID, len(content)); err != nil {
+			return err
+		}
+
+		document.Title = title
+		document.Content = content
+		document.Version++
+		if err := tx.Save(&document).Error; err != nil {
+			return err
+		}
+		return tx.Create(newVersion(&document)).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &document, nil
+}
+
+// DeleteDocument removes a document from the project. Its versions are kept so
+// earlier queries can still be traced back to the context they used.
+func (s *service) DeleteDocument(ctx context.Context, documentID int) error {
+	result := s.db.WithContext(ctx).Delete(&models.ContextDocument{}, documentID)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (s *service) GetDocument(ctx context.Context, documentID int) (*models.ContextDocument, error) {
+	var document models.ContextDocument
+	result := s.db.WithContext(ctx).First(&document, documentID)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &document, nil
+}
+
+func (s *service) ListDocuments(ctx context.Context, projectID int) (*[]models.ContextDocument, error) {
+	var documents []models.ContextDocument
+	results := s.db.WithContext(ctx).Where("project_id = ?", projectID).Order("id").Find(&documents)
+	if results.Error != nil {
+		return nil, results.Error
+	}
+	return &documents, nil
+}
+
+func (s *service) ListDocumentVersions(ctx context.Context, documentID int) (*[]models.ContextDocumentVersion, error) {
+	var versions []models.ContextDocumentVersion
+	results := s.db.WithContext(ctx).Where("context_document_id = ?", documentID).Order("version DESC").Find(&versions)
+	if results.Error != nil {
+		return nil, results.Error
+	}
+	return &versions, nil
+}
+
+func (s *service) GetDocumentProjectID(ctx context.Context, documentID int) (int, error) {
+	var version models.ContextDocumentVersion
+	result := s.db.WithContext(ctx).Select("project_id").
+		Where("context_document_id = ?", documentID).
+		Order("version DESC").
+		First(&version)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	if version.ProjectID != 0 {
+		return version.ProjectID, nil
+	}
+
+	// versions written before they carried the project can only be traced
+	// through a live document
+	document, err := s.GetDocument(ctx, documentID)
+	if err != nil {
+		return 0, err
+	}
+	return document.ProjectID, nil
+}
+
+// GetPromptContext joins the project's documents into a single markdown block
+// for the prompt and returns the document versions it was built from.
+func (s *service) GetPromptContext(ctx context.Context, projectID int) (string, []models.ContextDocumentRef, error) {
+	documents, err := s.ListDocuments(ctx, projectID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var b strings.Builder
+	refs := make([]models.ContextDocumentRef, 0, len(*documents))
+	for _, document := range *documents {
+		fmt.Fprintf(&b, "## %s\n\n%s\n\n", document.Title, strings.TrimSpace(document.Content))
+		refs = append(refs, models.ContextDocumentRef{
+			ContextDocumentID: document.ID,
+			Version:           document.Version,
+		})
+	}
+	return strings.TrimSpace(b.String()), refs, nil
+}
+
+// checkProjectSize rejects a write that would take the project's documents
+// over MaxProjectSize. excludeID is the document being replaced, if any. The
+// project row is locked until the transaction ends, so concurrent writes
+// cannot each pass the check against the same total.
+func checkProjectSize(tx *gorm.DB, projectID, excludeID, size int) error {
+	var project models.Project
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&project, projectID).Error; err != nil {
+		return err
+	}
+
+	var total int64
+	err := tx.Model(&models.ContextDocument{}).
+		Where("project_id = ? AND id <> ?", projectID, excludeID).
+		Select("COALESCE(SUM(octet_length(content)), 0)").
+		Scan(&total).Error
+	if err != nil {
+		return err
+	}
+	if total+int64(size) > MaxProjectSize {
+		return ErrProjectTooLarge
+	}
+	return nil
+}
+
+func newVersion(document *models.ContextDocument) *models.ContextDocumentVersion {
+	return &models.ContextDocumentVersion{
+		ProjectID:         document.ProjectID,
+		ContextDocumentID: document.ID,
+		Version:           document.Version,
+		Title:             document.Title,
+		Content:           document.Content,
+	}
+}
+
+func IsLimitError(err error) bool {
+	return errors.Is(err, ErrDocumentTooLarge) || errors.Is(err, ErrProjectTooLarge)
+}
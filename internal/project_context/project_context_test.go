@@ -0,0 +1,92 @@
+package projectcontext
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/cr34t1ve/hoprun/pkg/models"
+)
+
+func newTestService(t *testing.T) *service {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{TranslateError: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every connection to :memory: is a new database
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	if err := db.AutoMigrate(&models.Project{}, &models.ContextDocument{}, &models.ContextDocumentVersion{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&models.Project{ID: 1, Name: "shop"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	return &service{db: db}
+}
+
+func TestVersionsOutliveDocument(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+
+	document, err := s.AddDocument(ctx, 1, "Customers", "Active means ordered in 90 days.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.UpdateDocument(ctx, document.ID, "Customers", "Active means ordered in 30 days."); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteDocument(ctx, document.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetDocument(ctx, document.ID); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("GetDocument() after delete = %v, want gorm.ErrRecordNotFound", err)
+	}
+
+	projectID, err := s.GetDocumentProjectID(ctx, document.ID)
+	if err != nil || projectID != 1 {
+		t.Fatalf("GetDocumentProjectID() = %d, %v; want project 1", projectID, err)
+	}
+	versions, err := s.ListDocumentVersions(ctx, document.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*versions) != 2 || (*versions)[0].Version != 2 || (*versions)[1].Content != "Active means ordered in 90 days." {
+		t.Errorf("versions after delete = %+v, want both, newest first", *versions)
+	}
+	for _, version := range *versions {
+		if version.ProjectID != 1 {
+			t.Errorf("version %d has project %d, want 1", version.Version, version.ProjectID)
+		}
+	}
+}
+
+func TestGetDocumentProjectID(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+
+	if _, err := s.GetDocumentProjectID(ctx, 42); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("GetDocumentProjectID() of an unknown document = %v, want gorm.ErrRecordNotFound", err)
+	}
+
+	// versions saved before they carried the project are traced through
+	// the live document
+	document := &models.ContextDocument{ProjectID: 1, Title: "Legacy", Content: "x", Version: 1}
+	if err := s.db.Create(document).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := s.db.Create(&models.ContextDocumentVersion{ContextDocumentID: document.ID, Version: 1, Title: "Legacy", Content: "x"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if projectID, err := s.GetDocumentProjectID(ctx, document.ID); err != nil || projectID != 1 {
+		t.Errorf("GetDocumentProjectID() of a legacy document = %d, %v; want project 1", projectID, err)
+	}
+}
@@ -1,11 +1,22 @@
 package query
 
 import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"github.com/cr34t1ve/hoprun/internal/costguard"
 	"github.com/cr34t1ve/hoprun/internal/database"
+	"github.com/cr34t1ve/hoprun/internal/sqlguard"
+	"github.com/cr34t1ve/hoprun/pkg/models"
 )
 
 type Service interface {
-	ExecuteQuery(query string) ([]map[string]interface{}, error)
+	ExecuteQuery(ctx context.Context, query string, opts database.QueryOptions) ([]map[string]interface{}, bool, error)
+	ExplainQuery(ctx context.Context, query string, opts database.QueryOptions) (*models.QueryPlan, error)
 	FormatResults(results []map[string]interface{}, visualization string) interface{}
 }
 
@@ -17,11 +28,71 @@ func NewService(dbService database.Service) Service {
 	return &service{dbService: dbService}
 }
 
-func (s *service) ExecuteQuery(query string) ([]map[string]interface{}, error) {
-	return s.dbService.ExecuteRawQuery(query)
+// ExecuteQuery runs a generated query against the user's database and reports
+// whether the results were truncated at opts.MaxRows. Anything other than a
+// single SELECT is rejected with a *sqlguard.Error before it reaches the
+// database.
+func (s *service) ExecuteQuery(ctx context.Context, query string, opts database.QueryOptions) ([]map[string]interface{}, bool, error) {
+	dialect := s.dbService.Dialect()
+	if err := sqlguard.ValidateReadOnly(query, dialect); err != nil {
+		return nil, false, err
+	}
+	return s.dbService.ExecuteReadOnlyQuery(ctx, sqlguard.StripTerminator(query, dialect), opts)
+}
+
+// ExplainQuery returns the plan of a generated query as ExecuteQuery would run
+// it, without executing it. It applies the same validation as ExecuteQuery.
+func (s *service) ExplainQuery(ctx context.Context, query string, opts database.QueryOptions) (*models.QueryPlan, error) {
+	dialect := s.dbService.Dialect()
+	if err := sqlguard.ValidateReadOnly(query, dialect); err != nil {
+		return nil, err
+	}
+	explain, err := s.dbService.ExplainReadOnlyQuery(ctx, sqlguard.StripTerminator(query, dialect), opts)
+	if err != nil {
+		return nil, err
+	}
+	switch dialect {
+	case models.DialectMySQL:
+		return costguard.SummarizeMySQL(explain)
+	case models.DialectSQLite:
+		return costguard.SummarizeSQLite(explain)
+	case models.DialectDuckDB:
+		return costguard.SummarizeDuckDB(explain)
+	}
+	return costguard.Summarize(explain)
 }
 
 func (s *service) FormatResults(results []map[string]interface{}, visualization string) interface{} {
 	// Implement formatting logic based on visualization type
 	return results
 }
+
+// DescribeError formats a query error for the model, including the detail,
+// hint and position Postgres reports alongside the message. The database
+// service has already made the position relative to the generated query.
+// MySQL errors are formatted the way the mysql client prints them.
+func DescribeError(err error) string {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		if mysqlErr.SQLState[0] == 0 {
+			return fmt.Sprintf("ERROR %d: %s", mysqlErr.Number, mysqlErr.Message)
+		}
+		return fmt.Sprintf("ERROR %d (%s): %s", mysqlErr.Number, mysqlErr.SQLState[:], mysqlErr.Message)
+	}
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return err.Error()
+	}
+
+	description := fmt.Sprintf("ERROR: %s (SQLSTATE %s)", pgErr.Message, pgErr.Code)
+	if pgErr.Detail != "" {
+		description += "\nDETAIL: " + pgErr.Detail
+	}
+	if pgErr.Hint != "" {
+		description += "\nHINT: " + pgErr.Hint
+	}
+	if pgErr.Position > 0 {
+		description += fmt.Sprintf("\nPOSITION: %d", pgErr.Position)
+	}
+	return description
+}
@@ -0,0 +1,167 @@
+package schemaformat
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/cr34t1ve/hoprun/pkg/models"
+)
+
+const (
+	FormatText = "text"
+	FormatDDL  = "ddl"
+	FormatJSON = "json"
+)
+
+// Render formats a schema for use as prompt context. An empty format falls
+// back to the text rendering.
+func Render(schema *models.Schema, format string) (string, error) {
+	switch format {
+	case "", FormatText:
+		return Text(schema), nil
+	case FormatDDL:
+		return DDL(schema), nil
+	case FormatJSON:
+		return JSON(schema)
+	default:
+		return "", fmt.Errorf("unknown schema format %q", format)
+	}
+}
+
+func IsValid(format string) bool {
+	switch format {
+	case "", FormatText, FormatDDL, FormatJSON:
+		return true
+	}
+	return false
+}
+
+// Text renders one block per table listing its columns and keys.
+func Text(schema *models.Schema) string {
+	var b strings.Builder
+	for _, table := range schema.Tables {
+		if table.Kind == models.TableKindTable {
+			fmt.Fprintf(&b, "Table %s:\n", table.QualifiedName())
+		} else {
+			fmt.Fprintf(&b, "Table %s (%s):\n", table.QualifiedName(), table.Kind)
+		}
+		if table.Comment != "" {
+			fmt.Fprintf(&b, "  Description: %s\n", oneLine(table.Comment))
+		}
+		for _, col := range table.Columns {
+			if col.Nullable {
+				fmt.Fprintf(&b, "  %s (%s)", col.Name, col.DataType)
+			} else {
+				fmt.Fprintf(&b, "  %s (%s, not null)", col.Name, col.DataType)
+			}
+			if note := columnNote(col); note != "" {
+				fmt.Fprintf(&b, " - %s", note)
+			}
+			b.WriteString("\n")
+		}
+		if len(table.PrimaryKey) > 0 {
+			fmt.Fprintf(&b, "  Primary key: (%s)\n", strings.Join(table.PrimaryKey, ", "))
+		}
+		for _, unique := range table.UniqueKeys {
+			fmt.Fprintf(&b, "  Unique: (%s)\n", strings.Join(unique, ", "))
+		}
+		for _, fk := range table.ForeignKeys {
+			fmt.Fprintf(&b, "  Foreign key: (%s) references %s(%s)\n", strings.Join(fk.Columns, ", "), fk.ReferencedName(), strings.Join(fk.ReferencedColumns, ", "))
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+var ddlKeywords = map[string]string{
+	models.TableKindTable:            "CREATE TABLE",
+	models.TableKindView:             "CREATE VIEW",
+	models.TableKindMaterializedView: "CREATE MATERIALIZED VIEW",
+	models.TableKindForeignTable:     "CREATE FOREIGN TABLE",
+}
+
+// DDL renders each table as a compact CREATE statement. Views are rendered
+// with their output columns rather than their definition.
+func DDL(schema *models.Schema) string {
+	var b strings.Builder
+	for _, table := range schema.Tables {
+		keyword, ok := ddlKeywords[table.Kind]
+		if !ok {
+			keyword = ddlKeywords[models.TableKindTable]
+		}
+
+		// comments trail the separating comma, so each line keeps its own
+		var lines, comments []string
+		for _, col := range table.Columns {
+			line := col.Name + " " + col.DataType
+			if !col.Nullable {
+				line += " NOT NULL"
+			}
+			lines = append(lines, line)
+			comments = append(comments, columnNote(col))
+		}
+		if len(table.PrimaryKey) > 0 {
+			lines = append(lines, fmt.Sprintf("PRIMARY KEY (%s)", strings.Join(table.PrimaryKey, ", ")))
+		}
+		for _, unique := range table.UniqueKeys {
+			lines = append(lines, fmt.Sprintf("UNIQUE (%s)", strings.Join(unique, ", ")))
+		}
+		for _, fk := range table.ForeignKeys {
+			lines = append(lines, fmt.Sprintf("FOREIGN KEY (%s) REFERENCES %s (%s)", strings.Join(fk.Columns, ", "), fk.ReferencedName(), strings.Join(fk.ReferencedColumns, ", ")))
+		}
+
+		if table.Comment != "" {
+			fmt.Fprintf(&b, "-- %s\n", oneLine(table.Comment))
+		}
+		fmt.Fprintf(&b, "%s %s (\n", keyword, table.QualifiedName())
+		for i, line := range lines {
+			b.WriteString("  " + line)
+			if i < len(lines)-1 {
+				b.WriteString(",")
+			}
+			if i < len(comments) && comments[i] != "" {
+				b.WriteString(" -- " + comments[i])
+			}
+			b.WriteString("\n")
+		}
+		b.WriteString(");\n")
+	}
+	return b.String()
+}
+
+// columnNote combines a column's comment with its enum labels or sampled
+// values into a single line.
+func columnNote(col models.Column) string {
+	var parts []string
+	if col.Comment != "" {
+		parts = append(parts, oneLine(col.Comment))
+	}
+	if len(col.EnumValues) > 0 {
+		parts = append(parts, "Allowed values: "+quoteValues(col.EnumValues))
+	} else if len(col.SampleValues) > 0 {
+		parts = append(parts, "Sample values: "+quoteValues(col.SampleValues))
+	}
+	return strings.Join(parts, ". ")
+}
+
+func quoteValues(values []string) string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		quoted[i] = "'" + strings.ReplaceAll(value, "'", "''") + "'"
+	}
+	return strings.Join(quoted, ", ")
+}
+
+func oneLine(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// JSON renders the schema as compact JSON.
+func JSON(schema *models.Schema) (string, error) {
+	data, err := json.Marshal(schema)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
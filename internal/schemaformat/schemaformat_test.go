@@ -0,0 +1,160 @@
+package schemaformat
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/cr34t1ve/hoprun/pkg/models"
+)
+
+var testSchema = &models.Schema{Tables: []models.Table{
+	{
+		Schema:  "public",
+		Name:    "orders",
+		Kind:    models.TableKindTable,
+		Comment: "One row per\ncheckout.",
+		Columns: []models.Column{
+			{Name: "id", DataType: "integer"},
+			{Name: "customer_id", DataType: "integer", Nullable: true},
+			{Name: "status", DataType: "order_status", EnumValues: []string{"paid", "shipped"}},
+			{Name: "note", DataType: "text", Nullable: true, Comment: "Free text", SampleValues: []string{"gift", "don't bend"}},
+		},
+		PrimaryKey: []string{"id"},
+		UniqueKeys: [][]string{{"customer_id", "note"}},
+		ForeignKeys: []models.ForeignKey{
+			{Columns: []string{"customer_id"}, ReferencedSchema: "public", ReferencedTable: "customers", ReferencedColumns: []string{"id"}},
+		},
+	},
+	{
+		Schema:  "public",
+		Name:    "order_totals",
+		Kind:    models.TableKindMaterializedView,
+		Columns: []models.Column{{Name: "total", DataType: "numeric", Nullable: true}},
+	},
+}}
+
+func TestRender(t *testing.T) {
+	text := `Table public.orders:
+  Description: One row per checkout.
+  id (integer, not null)
+  customer_id (integer)
+  status (order_status, not null) - Allowed values: 'paid', 'shipped'
+  note (text) - Free text. Sample values: 'gift', 'don''t bend'
+  Primary key: (id)
+  Unique: (customer_id, note)
+  Foreign key: (customer_id) references public.customers(id)
+
+Table public.order_totals (materialized view):
+  total (numeric)
+
+`
+	ddl := `-- One row per checkout.
+CREATE TABLE public.orders (
+  id integer NOT NULL,
+  customer_id integer,
+  status order_status NOT NULL, -- Allowed values: 'paid', 'shipped'
+  note text, -- Free text. Sample values: 'gift', 'don''t bend'
+  PRIMARY KEY (id),
+  UNIQUE (customer_id, note),
+  FOREIGN KEY (customer_id) REFERENCES public.customers (id)
+);
+CREATE MATERIALIZED VIEW public.order_totals (
+  total numeric
+);
+`
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"", text},
+		{FormatText, text},
+		{FormatDDL, ddl},
+	}
+	for _, tt := range tests {
+		got, err := Render(testSchema, tt.format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("Render(%q) =\n%s\nwant\n%s", tt.format, got, tt.want)
+		}
+	}
+
+	if _, err := Render(testSchema, "yaml"); err == nil {
+		t.Error("unknown format rendered")
+	}
+}
+
+func TestRenderJSON(t *testing.T) {
+	rendered, err := Render(testSchema, FormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got models.Schema
+	if err := json.Unmarshal([]byte(rendered), &got); err != nil {
+		t.Fatalf("JSON rendering does not parse: %v\n%s", err, rendered)
+	}
+	if !reflect.DeepEqual(&got, testSchema) {
+		t.Errorf("JSON round trip = %+v, want %+v", got, testSchema)
+	}
+}
+
+// TestRenderDialects checks the names each introspector produces are
+// rendered as they are qualified in that dialect's SQL.
+func TestRenderDialects(t *testing.T) {
+	tests := []struct {
+		dialect string
+		table   models.Table
+		text    string
+		ddl     string
+	}{
+		{
+			models.DialectPostgres,
+			models.Table{Schema: "sales", Name: "customers", Kind: models.TableKindForeignTable, Columns: []models.Column{{Name: "email", DataType: "character varying"}}},
+			"Table sales.customers (foreign table):\n  email (character varying, not null)\n\n",
+			"CREATE FOREIGN TABLE sales.customers (\n  email character varying NOT NULL\n);\n",
+		},
+		{
+			// MySQL reports the database as the schema
+			models.DialectMySQL,
+			models.Table{Schema: "shop", Name: "customers", Kind: models.TableKindTable, Columns: []models.Column{{Name: "tier", DataType: "enum('gold','silver')", EnumValues: []string{"gold", "silver"}}}},
+			"Table shop.customers:\n  tier (enum('gold','silver'), not null) - Allowed values: 'gold', 'silver'\n\n",
+			"CREATE TABLE shop.customers (\n  tier enum('gold','silver') NOT NULL -- Allowed values: 'gold', 'silver'\n);\n",
+		},
+		{
+			models.DialectSQLite,
+			models.Table{Schema: "main", Name: "customers", Kind: models.TableKindView, Columns: []models.Column{{Name: "name", DataType: "TEXT", Nullable: true}}},
+			"Table main.customers (view):\n  name (TEXT)\n\n",
+			"CREATE VIEW main.customers (\n  name TEXT\n);\n",
+		},
+		{
+			models.DialectDuckDB,
+			models.Table{Schema: "main", Name: "customers", Kind: models.TableKindTable, Columns: []models.Column{{Name: "id", DataType: "BIGINT"}}, PrimaryKey: []string{"id"}},
+			"Table main.customers:\n  id (BIGINT, not null)\n  Primary key: (id)\n\n",
+			"CREATE TABLE main.customers (\n  id BIGINT NOT NULL,\n  PRIMARY KEY (id)\n);\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.dialect, func(t *testing.T) {
+			schema := &models.Schema{Tables: []models.Table{tt.table}}
+			if got := Text(schema); got != tt.text {
+				t.Errorf("Text() =\n%s\nwant\n%s", got, tt.text)
+			}
+			if got := DDL(schema); got != tt.ddl {
+				t.Errorf("DDL() =\n%s\nwant\n%s", got, tt.ddl)
+			}
+		})
+	}
+}
+
+func TestIsValid(t *testing.T) {
+	for _, format := range []string{"", FormatText, FormatDDL, FormatJSON} {
+		if !IsValid(format) {
+			t.Errorf("IsValid(%q) = false", format)
+		}
+	}
+	if IsValid("yaml") {
+		t.Error(`IsValid("yaml") = true`)
+	}
+}
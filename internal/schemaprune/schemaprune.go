@@ -0,0 +1,244 @@
+package schemaprune
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/cr34t1ve/hoprun/internal/schemaformat"
+	"github.com/cr34t1ve/hoprun/pkg/models"
+)
+
+const (
+	DefaultMaxTables   = 20
+	DefaultTokenBudget = 4000
+)
+
+// Options bounds the pruned schema. Zero values fall back to the defaults.
+// Format is the schema format the tables will be rendered in and is used to
+// estimate how many prompt tokens each table costs.
+type Options struct {
+	MaxTables   int
+	TokenBudget int
+	Format      string
+}
+
+var stopwords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true, "by": true,
+	"did": true, "do": true, "does": true, "for": true, "from": true, "get": true, "give": true,
+	"has": true, "have": true, "how": true, "i": true, "in": true, "is": true, "it": true, "list": true,
+	"many": true, "me": true, "much": true, "of": true, "on": true, "or": true, "our": true,
+	"show": true, "that": true, "the": true, "their": true, "there": true, "to": true, "was": true,
+	"we": true, "were": true, "what": true, "when": true, "where": true, "which": true, "who": true,
+	"with": true, "all": true, "last": true, "per": true, "each": true, "top": true,
+}
+
+// Prune returns the subset of schema most relevant to question. Tables are
+// ranked by lexical overlap between the question and their names, columns
+// and comments, then the foreign-key neighbours of matching tables are added
+// so join paths stay intact. Tables are taken in rank order until MaxTables
+// or TokenBudget is reached; the most relevant table is always kept. When
+// nothing in the question matches, tables are taken in schema order under
+// the same limits.
+func Prune(schema *models.Schema, question string, opts Options) *models.Schema {
+	if opts.MaxTables <= 0 {
+		opts.MaxTables = DefaultMaxTables
+	}
+	if opts.TokenBudget <= 0 {
+		opts.TokenBudget = DefaultTokenBudget
+	}
+
+	terms := make(map[string]bool)
+	for _, term := range tokenize(question) {
+		if !stopwords[term] {
+			terms[term] = true
+		}
+	}
+	lowerQuestion := strings.ToLower(question)
+
+	scores := make([]float64, len(schema.Tables))
+	var seeds []int
+	for i, table := range schema.Tables {
+		scores[i] = score(table, terms, lowerQuestion)
+		if scores[i] > 0 {
+			seeds = append(seeds, i)
+		}
+	}
+	sort.SliceStable(seeds, func(a, b int) bool {
+		return scores[seeds[a]] > scores[seeds[b]]
+	})
+
+	var candidates []int
+	if len(seeds) == 0 {
+		for i := range schema.Tables {
+			candidates = append(candidates, i)
+		}
+	} else {
+		neighbours := foreignKeyNeighbours(schema)
+		seen := make(map[int]bool)
+		for _, i := range seeds {
+			candidates = append(candidates, i)
+			seen[i] = true
+		}
+		for _, i := range seeds {
+			for _, n := range neighbours[i] {
+				if !seen[n] {
+					candidates = append(candidates, n)
+					seen[n] = true
+				}
+			}
+		}
+	}
+
+	pruned := &models.Schema{}
+	tokens := 0
+	for _, i := range candidates {
+		if len(pruned.Tables) >= opts.MaxTables {
+			break
+		}
+		cost := estimateTokens(schema.Tables[i], opts.Format)
+		if len(pruned.Tables) > 0 && tokens+cost > opts.TokenBudget {
+			continue
+		}
+		pruned.Tables = append(pruned.Tables, schema.Tables[i])
+		tokens += cost
+	}
+
+	return pruned
+}
+
+// TableNames returns the qualified names of the tables in schema.
+func TableNames(schema *models.Schema) []string {
+	names := make([]string, len(schema.Tables))
+	for i, table := range schema.Tables {
+		names[i] = table.QualifiedName()
+	}
+	return names
+}
+
+func score(table models.Table, terms map[string]bool, question string) float64 {
+	var score float64
+	for _, token := range tokenize(table.Name) {
+		if terms[token] {
+			score += 3
+		}
+	}
+	if strings.Contains(question, strings.ToLower(table.Name)) {
+		score += 2
+	}
+	for _, token := range tokenize(table.Schema) {
+		if terms[token] {
+			score++
+		}
+	}
+	score += commentScore(table.Comment, terms)
+
+	// column matches are capped so wide tables don't outrank tables whose
+	// name matches the question
+	var columnScore float64
+	for _, col := range table.Columns {
+		for _, token := range tokenize(col.Name) {
+			if terms[token] {
+				columnScore++
+				break
+			}
+		}
+		columnScore += commentScore(col.Comment, terms)
+	}
+	if columnScore > 3 {
+		columnScore = 3
+	}
+
+	return score + columnScore
+}
+
+// commentScore counts the distinct question terms found in a comment at half
+// the weight of a name match, since comments are free text.
+func commentScore(comment string, terms map[string]bool) float64 {
+	if comment == "" {
+		return 0
+	}
+	matched := make(map[string]bool)
+	for _, token := range tokenize(comment) {
+		if terms[token] && !stopwords[token] {
+			matched[token] = true
+		}
+	}
+	return float64(len(matched)) / 2
+}
+
+// foreignKeyNeighbours returns, for each table index, the indexes of tables it
+// references or is referenced by.
+func foreignKeyNeighbours(schema *models.Schema) map[int][]int {
+	index := make(map[string]int, len(schema.Tables))
+	for i, table := range schema.Tables {
+		index[table.QualifiedName()] = i
+	}
+
+	neighbours := make(map[int][]int)
+	for i, table := range schema.Tables {
+		for _, fk := range table.ForeignKeys {
+			j, ok := index[fk.ReferencedName()]
+			if !ok || j == i {
+				continue
+			}
+			neighbours[i] = append(neighbours[i], j)
+			neighbours[j] = append(neighbours[j], i)
+		}
+	}
+	return neighbours
+}
+
+// estimateTokens approximates the prompt cost of a table using the common
+// four-characters-per-token heuristic.
+func estimateTokens(table models.Table, format string) int {
+	rendered, err := schemaformat.Render(&models.Schema{Tables: []models.Table{table}}, format)
+	if err != nil {
+		rendered = schemaformat.Text(&models.Schema{Tables: []models.Table{table}})
+	}
+	return len(rendered)/4 + 1
+}
+
+// tokenize splits text into lower-cased, singularised words, breaking
+// identifiers on underscores and camelCase boundaries.
+func tokenize(text string) []string {
+	var tokens []string
+	var current []rune
+	flush := func() {
+		if len(current) > 0 {
+			tokens = append(tokens, singular(strings.ToLower(string(current))))
+			current = current[:0]
+		}
+	}
+
+	runes := []rune(text)
+	for i, r := range runes {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if unicode.IsUpper(r) && i > 0 && unicode.IsLower(runes[i-1]) {
+				flush()
+			}
+			current = append(current, r)
+		default:
+			flush()
+		}
+	}
+	flush()
+
+	return tokens
+}
+
+func singular(word string) string {
+	switch {
+	case len(word) > 4 && strings.HasSuffix(word, "ies"):
+		return word[:len(word)-3] + "y"
+	case len(word) > 4 && (strings.HasSuffix(word, "sses") || strings.HasSuffix(word, "uses") ||
+		strings.HasSuffix(word, "xes") || strings.HasSuffix(word, "ches") || strings.HasSuffix(word, "shes")):
+		return word[:len(word)-2]
+	case strings.HasSuffix(word, "ss") || strings.HasSuffix(word, "us") || strings.HasSuffix(word, "is"):
+		return word
+	case len(word) > 2 && strings.HasSuffix(word, "s"):
+		return word[:len(word)-1]
+	}
+	return word
+}
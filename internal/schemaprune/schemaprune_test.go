@@ -0,0 +1,203 @@
+package schemaprune
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cr34t1ve/hoprun/internal/schemaformat"
+	"github.com/cr34t1ve/hoprun/pkg/models"
+)
+
+func table(name string, columns ...string) models.Table {
+	t := models.Table{Schema: "public", Name: name, Kind: models.TableKindTable}
+	for _, column := range columns {
+		t.Columns = append(t.Columns, models.Column{Name: column, DataType: "text"})
+	}
+	return t
+}
+
+func references(t models.Table, referenced string) models.Table {
+	t.ForeignKeys = append(t.ForeignKeys, models.ForeignKey{
+		Columns:           []string{referenced + "_id"},
+		ReferencedSchema:  "public",
+		ReferencedTable:   referenced,
+		ReferencedColumns: []string{"id"},
+	})
+	return t
+}
+
+func testSchema() *models.Schema {
+	customers := table("customers", "id", "name", "email")
+	customers.Comment = "People who have placed at least one order"
+	return &models.Schema{Tables: []models.Table{
+		table("audit_log", "id", "action", "created_at"),
+		customers,
+		references(table("orders", "id", "customer_id", "total", "created_at"), "customers"),
+		references(table("order_items", "id", "order_id", "product_id", "quantity"), "orders"),
+		table("products", "id", "name", "price"),
+		table("invoice_lines", "id", "description", "amount"),
+	}}
+}
+
+func TestPruneRanking(t *testing.T) {
+	tests := []struct {
+		name     string
+		question string
+		want     []string
+	}{
+		{
+			// the exact table name outranks a shared word, and the comment
+			// on customers is worth half a name match per term
+			name:     "name match",
+			question: "How many orders were placed last week?",
+			want:     []string{"public.orders", "public.order_items", "public.customers"},
+		},
+		{
+			name:     "plural and camelCase",
+			question: "Show the orderItems for each product",
+			want:     []string{"public.order_items", "public.orders", "public.products", "public.customers"},
+		},
+		{
+			// orders is added as a foreign-key neighbour even though
+			// nothing in the question names it
+			name:     "foreign-key neighbours",
+			question: "quantity sold",
+			want:     []string{"public.order_items", "public.orders"},
+		},
+		{
+			name:     "no match keeps schema order",
+			question: "what is the weather",
+			want:     []string{"public.audit_log", "public.customers", "public.orders", "public.order_items", "public.products", "public.invoice_lines"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := TableNames(Prune(testSchema(), tt.question, Options{}))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Prune(%q) = %v, want %v", tt.question, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPruneCapsColumnMatches(t *testing.T) {
+	// report matches every term through its columns, but a table named in
+	// the question still ranks above it
+	schema := &models.Schema{Tables: []models.Table{
+		table("report", "revenue", "cost", "margin", "profit", "tax"),
+		table("revenue", "id", "booked_at"),
+	}}
+	got := TableNames(Prune(schema, "revenue cost margin profit tax", Options{}))
+	want := []string{"public.revenue", "public.report"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Prune() = %v, want %v", got, want)
+	}
+}
+
+func TestPruneLimits(t *testing.T) {
+	schema := testSchema()
+	cost := func(name string) int {
+		for _, table := range schema.Tables {
+			if table.Name == name {
+				return estimateTokens(table, schemaformat.FormatDDL)
+			}
+		}
+		t.Fatalf("no table %s", name)
+		return 0
+	}
+	question := "How many orders were placed last week?"
+
+	tests := []struct {
+		name string
+		opts Options
+		want []string
+	}{
+		{"max tables", Options{MaxTables: 2}, []string{"public.orders", "public.order_items"}},
+		{
+			"budget fits two",
+			Options{TokenBudget: cost("orders") + cost("order_items"), Format: schemaformat.FormatDDL},
+			[]string{"public.orders", "public.order_items"},
+		},
+		{
+			// a table over the remaining budget is skipped rather than
+			// ending the walk, so a smaller one further down still fits
+			"budget skips a larger table",
+			Options{TokenBudget: cost("orders") + cost("customers"), Format: schemaformat.FormatDDL},
+			[]string{"public.orders", "public.customers"},
+		},
+		{
+			"most relevant table always kept",
+			Options{TokenBudget: 1, Format: schemaformat.FormatDDL},
+			[]string{"public.orders"},
+		},
+	}
+	if cost("order_items") <= cost("customers") {
+		t.Fatalf("fixture: order_items (%d tokens) must cost more than customers (%d)", cost("order_items"), cost("customers"))
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := TableNames(Prune(schema, question, tt.opts))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Prune() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEstimateTokensFollowsFormat(t *testing.T) {
+	orders := testSchema().Tables[2]
+	text := estimateTokens(orders, schemaformat.FormatText)
+	json := estimateTokens(orders, schemaformat.FormatJSON)
+	if text == json {
+		t.Errorf("text and JSON renderings both estimated at %d tokens", text)
+	}
+	// an unknown format is estimated as text
+	if got := estimateTokens(orders, "yaml"); got != text {
+		t.Errorf("unknown format estimated at %d tokens, want %d", got, text)
+	}
+}
+
+// TestPruneLeavesSchema guards the cached schema, which Prune is handed
+// directly and shares with every other question on the connection.
+func TestPruneLeavesSchema(t *testing.T) {
+	schema := testSchema()
+	before, err := json.Marshal(schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pruned := Prune(schema, "orders", Options{MaxTables: 1})
+	pruned.Tables = append(pruned.Tables, table("extra"))
+	pruned.Tables[0].Name = "renamed"
+
+	after, err := json.Marshal(schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(before) != string(after) {
+		t.Errorf("schema changed by pruning:\nbefore %s\nafter  %s", before, after)
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"order_items", []string{"order", "item"}},
+		{"orderItems", []string{"order", "item"}},
+		{"Categories and ADDRESSES", []string{"category", "and", "address"}},
+		{"status, analysis, boxes, matches", []string{"status", "analysis", "box", "match"}},
+		{"top-10 users?", []string{"top", "10", "user"}},
+	}
+	for _, tt := range tests {
+		if got := tokenize(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("tokenize(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+	if got := strings.Join(tokenize(""), ","); got != "" {
+		t.Errorf("tokenize(\"\") = %q", got)
+	}
+}
@@ -0,0 +1,135 @@
+package secrets
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// KeyProvider supplies the key-encryption keys that wrap per-record data
+// keys. Every key ever used must stay available so older rows can still be
+// opened; CurrentKeyID names the key new data keys are wrapped with.
+type KeyProvider interface {
+	CurrentKeyID() string
+	Key(keyID string) ([]byte, error)
+}
+
+type keyring struct {
+	current string
+	keys    map[string][]byte
+}
+
+// NewKeyring returns a KeyProvider over a fixed set of 32-byte AES-256 keys.
+func NewKeyring(current string, keys map[string][]byte) (KeyProvider, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no encryption keys configured")
+	}
+	for id, key := range keys {
+		if id == "" || strings.ContainsAny(id, ":,") {
+			return nil, fmt.Errorf("invalid encryption key id %q", id)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("encryption key %q must be 32 bytes, got %d", id, len(key))
+		}
+	}
+	if _, ok := keys[current]; !ok {
+		return nil, fmt.Errorf("current encryption key %q is not in the keyring", current)
+	}
+	return &keyring{current: current, keys: keys}, nil
+}
+
+func (k *keyring) CurrentKeyID() string {
+	return k.current
+}
+
+func (k *keyring) Key(keyID string) ([]byte, error) {
+	key, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("encryption key %q is not in the keyring", keyID)
+	}
+	return key, nil
+}
+
+// NewEnvKeyring reads ENCRYPTION_KEYS as comma-separated id:base64key pairs
+// and ENCRYPTION_KEY_ID as the current key, defaulting to the last pair
+// listed. For older deployments a single raw 32-byte ENCRYPTION_KEY is
+// accepted as key "default".
+func NewEnvKeyring() (KeyProvider, error) {
+	keys := make(map[string][]byte)
+	current := os.Getenv("ENCRYPTION_KEY_ID")
+
+	if value := os.Getenv("ENCRYPTION_KEYS"); value != "" {
+		var last string
+		for _, pair := range strings.Split(value, ",") {
+			id, encoded, ok := strings.Cut(strings.TrimSpace(pair), ":")
+			if !ok {
+				return nil, errors.New("ENCRYPTION_KEYS entries must be id:base64key")
+			}
+			key, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil {
+				return nil, fmt.Errorf("invalid base64 for encryption key %q: %v", id, err)
+			}
+			keys[id] = key
+			last = id
+		}
+		if current == "" {
+			current = last
+		}
+	} else if key := os.Getenv("ENCRYPTION_KEY"); key != "" {
+		keys["default"] = []byte(key)
+		if current == "" {
+			current = "default"
+		}
+	}
+
+	return NewKeyring(current, keys)
+}
+
+type keyringFile struct {
+	Current string            `json:"current"`
+	Keys    map[string]string `json:"keys"`
+}
+
+// NewFileKeyring reads a JSON keyring of the form
+// {"current": "k2", "keys": {"k1": "<base64>", "k2": "<base64>"}}.
+func NewFileKeyring(path string) (KeyProvider, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file keyringFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid keyring file %s: %v", path, err)
+	}
+
+	keys := make(map[string][]byte, len(file.Keys))
+	for id, encoded := range file.Keys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid base64 for encryption key %q: %v", id, err)
+		}
+		keys[id] = key
+	}
+	if file.Current == "" && len(keys) > 0 {
+		ids := make([]string, 0, len(keys))
+		for id := range keys {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		file.Current = ids[len(ids)-1]
+	}
+	return NewKeyring(file.Current, keys)
+}
+
+// KeyProviderFromEnv uses the keyring file named by ENCRYPTION_KEYRING_FILE
+// when set, and the environment keyring otherwise.
+func KeyProviderFromEnv() (KeyProvider, error) {
+	if path := os.Getenv("ENCRYPTION_KEYRING_FILE"); path != "" {
+		return NewFileKeyring(path)
+	}
+	return NewEnvKeyring()
+}
@@ -0,0 +1,161 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"strings"
+)
+
+// boundPrefix marks values sealed with additional data. Values without it
+// were sealed before values were bound to their record and open without.
+const boundPrefix = "v2:"
+
+// DataKey is a random per-record key. Record fields are sealed with the data
+// key, and the data key is stored wrapped by a keyring key, so rotating the
+// keyring only rewraps data keys instead of re-encrypting every field.
+type DataKey struct {
+	// KeyID names the keyring key that wraps this data key
+	KeyID string
+	// Wrapped is the base64 encoded, encrypted data key stored with the record
+	Wrapped string
+
+	plain []byte
+}
+
+// Seal encrypts plaintext with AES-256-GCM and returns base64 of nonce and
+// ciphertext. additionalData names where the value is stored, e.g. its
+// record and field; it is authenticated but not stored, and Open must be
+// given the same, so a value copied to another record or field does not
+// open.
+func (k *DataKey) Seal(plaintext string, additionalData []byte) (string, error) {
+	sealed, err := seal(k.plain, []byte(plaintext), additionalData)
+	if err != nil {
+		return "", err
+	}
+	return boundPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Open reverses Seal.
+func (k *DataKey) Open(ciphertext string, additionalData []byte) (string, error) {
+	if !Bound(ciphertext) {
+		additionalData = nil
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(ciphertext, boundPrefix))
+	if err != nil {
+		return "", err
+	}
+	plain, err := open(k.plain, data, additionalData)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+// Bound reports whether ciphertext was sealed with additional data. Older
+// values still open but should be sealed again.
+func Bound(ciphertext string) bool {
+	return strings.HasPrefix(ciphertext, boundPrefix)
+}
+
+type Service interface {
+	// NewDataKey generates a data key wrapped with the current keyring key.
+	NewDataKey() (*DataKey, error)
+	// OpenDataKey unwraps a stored data key.
+	OpenDataKey(keyID, wrapped string) (*DataKey, error)
+	// Rewrap wraps key with the current keyring key, updating KeyID and
+	// Wrapped. Values sealed with the key stay valid.
+	Rewrap(key *DataKey) error
+	CurrentKeyID() string
+}
+
+type service struct {
+	keys KeyProvider
+}
+
+func NewService(keys KeyProvider) Service {
+	return &service{keys: keys}
+}
+
+func (s *service) NewDataKey() (*DataKey, error) {
+	plain := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, plain); err != nil {
+		return nil, err
+	}
+	key := &DataKey{plain: plain}
+	if err := s.Rewrap(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (s *service) OpenDataKey(keyID, wrapped string) (*DataKey, error) {
+	kek, err := s.keys.Key(keyID)
+	if err != nil {
+		return nil, err
+	}
+	data, err := base64.StdEncoding.DecodeString(wrapped)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := open(kek, data, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &DataKey{KeyID: keyID, Wrapped: wrapped, plain: plain}, nil
+}
+
+func (s *service) Rewrap(key *DataKey) error {
+	keyID := s.keys.CurrentKeyID()
+	kek, err := s.keys.Key(keyID)
+	if err != nil {
+		return err
+	}
+	wrapped, err := seal(kek, key.plain, nil)
+	if err != nil {
+		return err
+	}
+	key.KeyID = keyID
+	key.Wrapped = base64.StdEncoding.EncodeToString(wrapped)
+	return nil
+}
+
+func (s *service) CurrentKeyID() string {
+	return s.keys.CurrentKeyID()
+}
+
+func seal(key, plaintext, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(key, data, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonceSize := gcm.NonceSize()
+	if len(data) < nonceSize {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := data[:nonceSize], data[nonceSize:]
+	return gcm.Open(nil, nonce, ciphertext, additionalData)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,176 @@
+package secrets
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func testKeyring(t *testing.T, current string, ids ...string) KeyProvider {
+	t.Helper()
+	keys := make(map[string][]byte, len(ids))
+	for _, id := range ids {
+		keys[id] = bytes.Repeat([]byte(id[len(id)-1:]), 32)
+	}
+	keyring, err := NewKeyring(current, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keyring
+}
+
+func TestSealOpen(t *testing.T) {
+	s := NewService(testKeyring(t, "k1", "k1"))
+	key, err := s.NewDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.KeyID != "k1" || key.Wrapped == "" {
+		t.Fatalf("data key = %s/%q, want wrapped with k1", key.KeyID, key.Wrapped)
+	}
+
+	aad := []byte("database_connections/1/db_password")
+	sealed, err := key.Seal("s3cret pässword", aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(sealed, "s3cret") || !Bound(sealed) {
+		t.Fatalf("sealed = %q, want bound ciphertext", sealed)
+	}
+	again, err := key.Seal("s3cret pässword", aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again == sealed {
+		t.Fatal("sealing twice gave the same ciphertext")
+	}
+
+	// the data key is stored wrapped and opened again from the row
+	stored, err := s.OpenDataKey(key.KeyID, key.Wrapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := stored.Open(sealed, aad)
+	if err != nil || plain != "s3cret pässword" {
+		t.Fatalf("Open() = %q, %v; want the plaintext", plain, err)
+	}
+}
+
+func TestOpenAfterRotation(t *testing.T) {
+	before := NewService(testKeyring(t, "k1", "k1"))
+	key, err := before.NewDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := key.Seal("secret", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// k2 is added and made current; k1 stays in the keyring
+	after := NewService(testKeyring(t, "k2", "k1", "k2"))
+	if after.CurrentKeyID() != "k2" {
+		t.Fatalf("CurrentKeyID() = %s, want k2", after.CurrentKeyID())
+	}
+	old, err := after.OpenDataKey("k1", key.Wrapped)
+	if err != nil {
+		t.Fatalf("OpenDataKey with the old key id: %v", err)
+	}
+	if plain, err := old.Open(sealed, nil); err != nil || plain != "secret" {
+		t.Fatalf("Open() with the old key = %q, %v", plain, err)
+	}
+
+	// rewrapping moves the data key to k2 without touching sealed values
+	if err := after.Rewrap(old); err != nil {
+		t.Fatal(err)
+	}
+	if old.KeyID != "k2" || old.Wrapped == key.Wrapped {
+		t.Fatalf("Rewrap() left the data key under %s", old.KeyID)
+	}
+	rewrapped, err := after.OpenDataKey(old.KeyID, old.Wrapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain, err := rewrapped.Open(sealed, nil); err != nil || plain != "secret" {
+		t.Fatalf("Open() after Rewrap = %q, %v", plain, err)
+	}
+
+	// the wrapped key names its keyring key; the other one cannot open it
+	if _, err := after.OpenDataKey("k2", key.Wrapped); err == nil {
+		t.Fatal("data key wrapped with k1 opened with k2")
+	}
+	if _, err := NewService(testKeyring(t, "k2", "k2")).OpenDataKey("k1", key.Wrapped); err == nil {
+		t.Fatal("data key opened after its keyring key was removed")
+	}
+}
+
+func TestOpenRejectsTampering(t *testing.T) {
+	s := NewService(testKeyring(t, "k1", "k1"))
+	key, err := s.NewDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	aad := []byte("database_connections/1/db_password")
+	sealed, err := key.Seal("secret", aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(sealed, boundPrefix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 1
+	flipped := boundPrefix + base64.StdEncoding.EncodeToString(data)
+	if _, err := key.Open(flipped, aad); err == nil {
+		t.Fatal("tampered ciphertext opened")
+	}
+
+	// a value moved to another row or field does not open there
+	for _, other := range []string{"database_connections/2/db_password", "database_connections/1/ssl_key"} {
+		if _, err := key.Open(sealed, []byte(other)); err == nil {
+			t.Fatalf("ciphertext for %s opened as %s", aad, other)
+		}
+	}
+	// nor does dropping the prefix make it open without the binding
+	if _, err := key.Open(strings.TrimPrefix(sealed, boundPrefix), nil); err == nil {
+		t.Fatal("bound ciphertext opened without its additional data")
+	}
+
+	other, err := s.NewDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.Open(sealed, aad); err == nil {
+		t.Fatal("ciphertext opened with another data key")
+	}
+
+	wrapped, err := base64.StdEncoding.DecodeString(key.Wrapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrapped[len(wrapped)-1] ^= 1
+	if _, err := s.OpenDataKey(key.KeyID, base64.StdEncoding.EncodeToString(wrapped)); err == nil {
+		t.Fatal("tampered data key opened")
+	}
+}
+
+func TestNewKeyring(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	tests := []struct {
+		name    string
+		current string
+		keys    map[string][]byte
+	}{
+		{"empty", "k1", nil},
+		{"short key", "k1", map[string][]byte{"k1": key[:16]}},
+		{"id with separator", "k:1", map[string][]byte{"k:1": key}},
+		{"unknown current", "k2", map[string][]byte{"k1": key}},
+	}
+	for _, tt := range tests {
+		if _, err := NewKeyring(tt.current, tt.keys); err == nil {
+			t.Errorf("%s: NewKeyring() succeeded", tt.name)
+		}
+	}
+}
@@ -0,0 +1,440 @@
+package sqlguard
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cr34t1ve/hoprun/pkg/models"
+)
+
+// Error explains why a statement was rejected.
+type Error struct {
+	Reason string
+}
+
+func (e *Error) Error() string {
+	return "query blocked: " + e.Reason
+}
+
+func blocked(format string, args ...interface{}) error {
+	return &Error{Reason: fmt.Sprintf(format, args...)}
+}
+
+// writeKeywords may not appear anywhere in an allowed statement: they are how
+// a SELECT or WITH statement modifies data (data-modifying CTEs, SELECT INTO).
+var writeKeywords = map[string]bool{
+	"insert": true,
+	"update": true,
+	"delete": true,
+	"merge":  true,
+	"into":   true,
+}
+
+// deniedFunctions have side effects or reach outside the database and are not
+// all stopped by a read-only transaction. The query_to_xml family and ts_stat
+// run SQL passed to them as a string, which the checks here never see.
+var deniedFunctions = map[string]bool{
+	"pg_terminate_backend": true, "pg_cancel_backend": true, "pg_reload_conf": true,
+	"pg_rotate_logfile": true, "pg_promote": true, "set_config": true,
+	"pg_read_file": true, "pg_read_binary_file": true, "pg_ls_dir": true, "pg_stat_file": true,
+	"lo_import": true, "lo_export": true, "lo_unlink": true, "lo_create": true, "lo_from_bytea": true, "lo_put": true,
+	"dblink": true, "dblink_exec": true, "dblink_connect": true,
+	"pg_advisory_lock": true, "pg_advisory_xact_lock": true, "pg_try_advisory_lock": true,
+	"nextval": true, "setval": true, "pg_sleep": true, "pg_sleep_for": true, "pg_sleep_until": true,
+	"query_to_xml": true, "query_to_xmlschema": true, "query_to_xml_and_xmlschema": true,
+	"cursor_to_xml": true, "cursor_to_xmlschema": true, "ts_stat": true,
+}
+
+// deniedDialectFunctions are the equivalents in the other dialects. DuckDB
+// file access is also switched off when the database is opened.
+var deniedDialectFunctions = map[string]map[string]bool{
+	models.DialectMySQL: {
+		"sleep": true, "benchmark": true, "load_file": true,
+		"get_lock": true, "release_lock": true, "release_all_locks": true, "is_free_lock": true,
+		"master_pos_wait": true, "source_pos_wait": true,
+	},
+	models.DialectSQLite: {
+		"load_extension": true, "readfile": true, "writefile": true, "edit": true, "fts3_tokenizer": true,
+	},
+	models.DialectDuckDB: {
+		"read_csv": true, "read_csv_auto": true, "read_parquet": true, "parquet_scan": true,
+		"read_json": true, "read_json_auto": true, "read_text": true, "read_blob": true, "glob": true,
+		"set_config": true, "query": true,
+	},
+}
+
+// ValidateReadOnly accepts a single SELECT or WITH ... SELECT statement and
+// rejects everything else, returning an *Error with the reason. It works on
+// tokens rather than a full parse, so string literals, quoted identifiers
+// and comments cannot hide or fake keywords. It is a first line of defence;
+// queries are still executed in a read-only transaction. dialect is one of
+// the models.Dialect constants and selects the quoting and comment rules.
+func ValidateReadOnly(sql, dialect string) error {
+	tokens, err := tokenize(sql, dialect)
+	if err != nil {
+		return err
+	}
+	denied, ok := deniedDialectFunctions[dialect]
+	if !ok {
+		denied = deniedFunctions
+	}
+
+	var statements [][]token
+	var current []token
+	for _, tok := range tokens {
+		if tok.text == ";" && !tok.quoted {
+			if len(current) > 0 {
+				statements = append(statements, current)
+			}
+			current = nil
+			continue
+		}
+		current = append(current, tok)
+	}
+	if len(current) > 0 {
+		statements = append(statements, current)
+	}
+
+	switch len(statements) {
+	case 0:
+		return blocked("the query is empty")
+	case 1:
+	default:
+		return blocked("only a single statement is allowed, found %d", len(statements))
+	}
+
+	statement := statements[0]
+	// the statement is wrapped in a subquery to limit its rows, which an
+	// unmatched ")" could close early
+	depth := 0
+	for _, tok := range statement {
+		if tok.quoted {
+			continue
+		}
+		if tok.text == "(" {
+			depth++
+		} else if tok.text == ")" {
+			depth--
+		}
+		if depth < 0 {
+			return blocked("unbalanced parentheses")
+		}
+	}
+	if depth != 0 {
+		return blocked("unbalanced parentheses")
+	}
+
+	first := 0
+	for first < len(statement) && statement[first].text == "(" {
+		first++
+	}
+	if first == len(statement) || statement[first].quoted ||
+		(statement[first].text != "select" && statement[first].text != "with") {
+		return blocked("only SELECT statements are allowed, found %s", describe(statement[first:]))
+	}
+
+	for i, tok := range statement {
+		if name := functionName(tok, dialect); denied[name] && i+1 < len(statement) &&
+			statement[i+1].text == "(" && !statement[i+1].quoted {
+			return blocked("function %s is not allowed", name)
+		}
+		if tok.quoted {
+			continue
+		}
+		if writeKeywords[tok.text] {
+			return blocked("%s is not allowed in a read-only query", strings.ToUpper(tok.text))
+		}
+		if tok.text == "for" && i+1 < len(statement) && !statement[i+1].quoted {
+			switch statement[i+1].text {
+			case "share", "key", "no":
+				return blocked("row locking clauses are not allowed in a read-only query")
+			}
+		}
+		// MySQL's older spelling of FOR SHARE
+		if tok.text == "lock" && i+1 < len(statement) && statement[i+1].text == "in" && !statement[i+1].quoted {
+			return blocked("row locking clauses are not allowed in a read-only query")
+		}
+	}
+
+	return nil
+}
+
+// StripTerminator removes a trailing semicolon, and anything after it, from
+// a statement that passed ValidateReadOnly so it can be embedded in another
+// query.
+func StripTerminator(sql, dialect string) string {
+	tokens, err := tokenize(sql, dialect)
+	if err != nil {
+		return sql
+	}
+	for i := len(tokens) - 1; i >= 0; i-- {
+		if tokens[i].text != ";" || tokens[i].quoted {
+			break
+		}
+		sql = sql[:tokens[i].pos]
+	}
+	return strings.TrimSpace(sql)
+}
+
+// functionName is the name a token calls a function by. Quoted identifiers
+// are case-sensitive in Postgres, so "pg_sleep" is pg_sleep but "PG_SLEEP" is
+// another function; the other dialects ignore case in function names.
+func functionName(tok token, dialect string) string {
+	if tok.quoted && dialect != models.DialectPostgres {
+		return strings.ToLower(tok.text)
+	}
+	return tok.text
+}
+
+func describe(tokens []token) string {
+	if len(tokens) == 0 {
+		return "nothing"
+	}
+	return strings.ToUpper(tokens[0].text)
+}
+
+// token is an unquoted word (lower-cased), a quoted identifier, or one of the
+// punctuation characters ; ( ). Literals, comments, numbers and operators are
+// dropped since they cannot change what kind of statement is being run.
+type token struct {
+	text   string
+	quoted bool
+	pos    int
+}
+
+func tokenize(sql, dialect string) ([]token, error) {
+	switch dialect {
+	case models.DialectMySQL:
+		return tokenizeMySQL(sql)
+	case models.DialectSQLite:
+		return tokenizeSQLite(sql)
+	}
+
+	var tokens []token
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		switch {
+		case c == '-' && i+1 < len(sql) && sql[i+1] == '-':
+			for i < len(sql) && sql[i] != '\n' {
+				i++
+			}
+		case c == '/' && i+1 < len(sql) && sql[i+1] == '*':
+			// block comments nest in Postgres
+			depth := 0
+			for {
+				if i+1 >= len(sql) {
+					return nil, blocked("unterminated comment")
+				}
+				if sql[i] == '/' && sql[i+1] == '*' {
+					depth++
+					i += 2
+				} else if sql[i] == '*' && sql[i+1] == '/' {
+					depth--
+					i += 2
+					if depth == 0 {
+						break
+					}
+				} else {
+					i++
+				}
+			}
+		case c == '\'':
+			end, err := skipQuoted(sql, i, '\'', false)
+			if err != nil {
+				return nil, err
+			}
+			i = end
+		case c == '"':
+			end, err := skipQuoted(sql, i, '"', false)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{text: sql[i+1 : end-1], quoted: true, pos: i})
+			i = end
+		case c == '$':
+			tag, ok := dollarTag(sql, i)
+			if !ok {
+				i++
+				continue
+			}
+			end := strings.Index(sql[i+len(tag):], tag)
+			if end < 0 {
+				return nil, blocked("unterminated dollar-quoted string")
+			}
+			i += len(tag) + end + len(tag)
+		case isIdentStart(c):
+			start := i
+			for i < len(sql) && isIdentChar(sql[i]) {
+				i++
+			}
+			word := strings.ToLower(sql[start:i])
+			// E'...' strings allow backslash escapes
+			if word == "e" && i < len(sql) && sql[i] == '\'' {
+				end, err := skipQuoted(sql, i, '\'', true)
+				if err != nil {
+					return nil, err
+				}
+				i = end
+				continue
+			}
+			tokens = append(tokens, token{text: word, pos: start})
+		case c == ';' || c == '(' || c == ')':
+			tokens = append(tokens, token{text: string(c), pos: i})
+			i++
+		default:
+			i++
+		}
+	}
+	return tokens, nil
+}
+
+// tokenizeMySQL follows MySQL's lexer with the default sql_mode, which the
+// database service enforces: both quote characters delimit strings with
+// backslash escapes, backticks quote identifiers, # starts a comment and --
+// only does when followed by a space. Block comments do not nest, and
+// executable comments are rejected since the server runs their contents.
+func tokenizeMySQL(sql string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		switch {
+		case c == '#' || (c == '-' && strings.HasPrefix(sql[i:], "--") && (i+2 == len(sql) || sql[i+2] <= ' ')):
+			for i < len(sql) && sql[i] != '\n' {
+				i++
+			}
+		case c == '/' && i+1 < len(sql) && sql[i+1] == '*':
+			if strings.HasPrefix(sql[i:], "/*!") || strings.HasPrefix(sql[i:], "/*M!") {
+				return nil, blocked("executable comments are not allowed")
+			}
+			end := strings.Index(sql[i+2:], "*/")
+			if end < 0 {
+				return nil, blocked("unterminated comment")
+			}
+			i += 2 + end + 2
+		case c == '\'' || c == '"':
+			end, err := skipQuoted(sql, i, c, true)
+			if err != nil {
+				return nil, err
+			}
+			i = end
+		case c == '`':
+			end, err := skipQuoted(sql, i, '`', false)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{text: sql[i+1 : end-1], quoted: true, pos: i})
+			i = end
+		case isIdentStart(c):
+			start := i
+			for i < len(sql) && isIdentChar(sql[i]) {
+				i++
+			}
+			tokens = append(tokens, token{text: strings.ToLower(sql[start:i]), pos: start})
+		case c == ';' || c == '(' || c == ')':
+			tokens = append(tokens, token{text: string(c), pos: i})
+			i++
+		default:
+			i++
+		}
+	}
+	return tokens, nil
+}
+
+// tokenizeSQLite follows SQLite's lexer: strings have no backslash escapes,
+// identifiers may be quoted with double quotes, backticks or brackets, and
+// block comments do not nest.
+func tokenizeSQLite(sql string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		switch {
+		case c == '-' && i+1 < len(sql) && sql[i+1] == '-':
+			for i < len(sql) && sql[i] != '\n' {
+				i++
+			}
+		case c == '/' && i+1 < len(sql) && sql[i+1] == '*':
+			end := strings.Index(sql[i+2:], "*/")
+			if end < 0 {
+				return nil, blocked("unterminated comment")
+			}
+			i += 2 + end + 2
+		case c == '\'':
+			end, err := skipQuoted(sql, i, '\'', false)
+			if err != nil {
+				return nil, err
+			}
+			i = end
+		case c == '"' || c == '`':
+			end, err := skipQuoted(sql, i, c, false)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{text: sql[i+1 : end-1], quoted: true, pos: i})
+			i = end
+		case c == '[':
+			end := strings.IndexByte(sql[i:], ']')
+			if end < 0 {
+				return nil, blocked("unterminated quoted identifier")
+			}
+			tokens = append(tokens, token{text: sql[i+1 : i+end], quoted: true, pos: i})
+			i += end + 1
+		case isIdentStart(c):
+			start := i
+			for i < len(sql) && isIdentChar(sql[i]) {
+				i++
+			}
+			tokens = append(tokens, token{text: strings.ToLower(sql[start:i]), pos: start})
+		case c == ';' || c == '(' || c == ')':
+			tokens = append(tokens, token{text: string(c), pos: i})
+			i++
+		default:
+			i++
+		}
+	}
+	return tokens, nil
+}
+
+// skipQuoted returns the index just past the quoted section starting at
+// start. A doubled quote character is an escaped quote.
+func skipQuoted(sql string, start int, quote byte, backslashEscapes bool) (int, error) {
+	for i := start + 1; i < len(sql); i++ {
+		switch {
+		case backslashEscapes && sql[i] == '\\':
+			i++
+		case sql[i] == quote:
+			if i+1 < len(sql) && sql[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1, nil
+		}
+	}
+	return 0, blocked("unterminated quoted string")
+}
+
+// dollarTag returns the $tag$ opening a dollar-quoted string at start, if
+// there is one. Positional parameters such as $1 are not dollar quotes.
+func dollarTag(sql string, start int) (string, bool) {
+	i := start + 1
+	if i < len(sql) && sql[i] == '$' {
+		return "$$", true
+	}
+	if i >= len(sql) || !isIdentStart(sql[i]) {
+		return "", false
+	}
+	for i < len(sql) && isIdentChar(sql[i]) && sql[i] != '$' {
+		i++
+	}
+	if i < len(sql) && sql[i] == '$' {
+		return sql[start : i+1], true
+	}
+	return "", false
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= 0x80
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || (c >= '0' && c <= '9') || c == '$'
+}
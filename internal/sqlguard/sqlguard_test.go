@@ -0,0 +1,60 @@
+package sqlguard
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/cr34t1ve/hoprun/pkg/models"
+)
+
+func TestValidateReadOnly(t *testing.T) {
+	tests := []struct {
+		name    string
+		sql     string
+		dialect string
+		allowed bool
+	}{
+		{"select", "SELECT id FROM users", models.DialectPostgres, true},
+		{"with", "WITH t AS (SELECT 1) SELECT * FROM t;", models.DialectPostgres, true},
+		{"keyword in string", "SELECT 'delete' AS word", models.DialectPostgres, true},
+		{"quoted column named like a keyword", `SELECT "into" FROM t`, models.DialectPostgres, true},
+		{"insert", "INSERT INTO t VALUES (1)", models.DialectPostgres, false},
+		{"data-modifying cte", "WITH d AS (DELETE FROM t RETURNING *) SELECT * FROM d", models.DialectPostgres, false},
+		{"two statements", "SELECT 1; SELECT 2", models.DialectPostgres, false},
+
+		{"denied function", "SELECT pg_sleep(600)", models.DialectPostgres, false},
+		{"denied function qualified", "SELECT pg_catalog.pg_sleep(600)", models.DialectPostgres, false},
+		{"denied function quoted", `SELECT "pg_sleep"(600)`, models.DialectPostgres, false},
+		{"quoted name of another function", `SELECT "PG_SLEEP"(600)`, models.DialectPostgres, true},
+		{"denied function quoted mysql", "SELECT `SLEEP`(600)", models.DialectMySQL, false},
+		{"denied function quoted sqlite", `SELECT "Load_Extension"('x')`, models.DialectSQLite, false},
+		{"column named like a denied function", `SELECT "pg_sleep" FROM t`, models.DialectPostgres, true},
+
+		{"query_to_xml", "SELECT query_to_xml('select pg_sleep(600)', true, true, '')", models.DialectPostgres, false},
+		{"query_to_xml_and_xmlschema", "SELECT query_to_xml_and_xmlschema('select 1', true, true, '')", models.DialectPostgres, false},
+		{"query_to_xmlschema", "SELECT query_to_xmlschema('select 1', true, true, '')", models.DialectPostgres, false},
+		{"cursor_to_xml", "SELECT cursor_to_xml('c', 1, true, true, '')", models.DialectPostgres, false},
+		{"ts_stat", "SELECT * FROM ts_stat('select pg_sleep(600)')", models.DialectPostgres, false},
+		{"duckdb query", "SELECT * FROM query('select 1')", models.DialectDuckDB, false},
+
+		{"unbalanced close", "SELECT 1) UNION SELECT pg_backend_pid() --", models.DialectPostgres, false},
+		{"unbalanced open", "SELECT (1", models.DialectPostgres, false},
+		{"parenthesis in string", "SELECT ')' AS p", models.DialectPostgres, true},
+		{"parenthesised select", "(SELECT 1)", models.DialectPostgres, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateReadOnly(tt.sql, tt.dialect)
+			if tt.allowed && err != nil {
+				t.Fatalf("ValidateReadOnly(%q) = %v, want allowed", tt.sql, err)
+			}
+			if !tt.allowed {
+				var guardErr *Error
+				if !errors.As(err, &guardErr) {
+					t.Fatalf("ValidateReadOnly(%q) = %v, want blocked", tt.sql, err)
+				}
+			}
+		})
+	}
+}
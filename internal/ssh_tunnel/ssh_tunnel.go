@@ -0,0 +1,377 @@
+package sshtunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/cr34t1ve/hoprun/pkg/models"
+)
+
+const (
+	// DefaultIdleTimeout is how long a tunnel with no open connections is
+	// kept before it is closed
+	DefaultIdleTimeout = 10 * time.Minute
+	dialTimeout        = 10 * time.Second
+	keepAliveInterval  = 30 * time.Second
+)
+
+// DialFunc has the signature of pgconn.DialFunc, so it can be set on a pgx
+// connection config to route database connections through a tunnel.
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// Manager keeps one SSH client per database connection that uses a jump
+// host. Every database connection to that database is forwarded over the
+// same SSH connection, which is reopened when it drops or when the
+// connection's settings change.
+type Manager interface {
+	// Dialer returns a dial function that reaches addresses through conn's
+	// jump host. conn must hold the decrypted private key.
+	Dialer(conn *models.DatabaseConnection) DialFunc
+	// Close closes the tunnel for a connection, if one is open. Database
+	// connections still using it keep it open until they are closed.
+	Close(connectionID int) error
+	// Run closes tunnels that have had no open connections for longer than
+	// the idle timeout until ctx is cancelled.
+	Run(ctx context.Context)
+}
+
+type tunnel struct {
+	mu        sync.Mutex
+	current   *handle
+	updatedAt time.Time
+	lastUsed  time.Time
+	// closed is set once the tunnel has been removed from the manager
+	closed bool
+}
+
+// handle is an SSH client and the number of database connections forwarded
+// over it. Once retired it is closed as soon as none are.
+type handle struct {
+	mu        sync.Mutex
+	client    *ssh.Client
+	active    int
+	retired   bool
+	closeOnce sync.Once
+	closeErr  error
+}
+
+type manager struct {
+	mu          sync.Mutex
+	tunnels     map[int]*tunnel
+	idleTimeout time.Duration
+}
+
+func NewManager(idleTimeout time.Duration) Manager {
+	if idleTimeout <= 0 {
+		idleTimeout = DefaultIdleTimeout
+	}
+	return &manager{tunnels: make(map[int]*tunnel), idleTimeout: idleTimeout}
+}
+
+// Validate checks a connection's jump host settings. The private key is
+// optional so updates can keep the stored key.
+func Validate(conn *models.DatabaseConnection) error {
+	if conn.SSHHost == "" {
+		return nil
+	}
+	if conn.SSHUser == "" {
+		return errors.New("ssh_user is required with ssh_host")
+	}
+	if conn.SSHPort != "" {
+		port, err := strconv.Atoi(conn.SSHPort)
+		if err != nil || port < 1 || port > 65535 {
+			return fmt.Errorf("invalid ssh_port %q", conn.SSHPort)
+		}
+	}
+	if conn.SSHPrivateKey != "" {
+		if _, err := ssh.ParsePrivateKey([]byte(conn.SSHPrivateKey)); err != nil {
+			return errors.New("invalid ssh_private_key: " + err.Error())
+		}
+	}
+	if _, err := parseHostKey(conn.SSHHostKey); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (m *manager) Dialer(conn *models.DatabaseConnection) DialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		t, h, err := m.client(conn)
+		if err != nil {
+			return nil, err
+		}
+		netConn, err := h.client.DialContext(ctx, network, addr)
+		if err != nil {
+			t.release(h)
+			// the database may simply be unreachable from the jump host;
+			// only drop the client when the jump host itself has gone
+			if !alive(h.client) {
+				t.drop(h)
+			}
+			return nil, err
+		}
+		return &tunnelConn{Conn: netConn, release: func() { t.release(h) }}, nil
+	}
+}
+
+// client returns the connected SSH client for conn, opening it if needed,
+// and counts the caller as an active user of it.
+func (m *manager) client(conn *models.DatabaseConnection) (*tunnel, *handle, error) {
+	for {
+		m.mu.Lock()
+		t, ok := m.tunnels[conn.ID]
+		if !ok {
+			t = &tunnel{}
+			m.tunnels[conn.ID] = t
+		}
+		m.mu.Unlock()
+
+		t.mu.Lock()
+		if t.closed {
+			// evicted or closed after it was looked up; a client opened on it
+			// would never be closed, so start over with a new tunnel
+			t.mu.Unlock()
+			continue
+		}
+		h, err := t.connect(conn)
+		t.mu.Unlock()
+		return t, h, err
+	}
+}
+
+// connect opens the tunnel's client if needed and counts the caller as an
+// active user. A client opened for an older version of the connection is
+// retired, so database connections still forwarded over it are not cut off.
+// The caller holds t.mu.
+func (t *tunnel) connect(conn *models.DatabaseConnection) (*handle, error) {
+	if t.current != nil && !t.updatedAt.Equal(conn.UpdatedAt) {
+		if t.current.retire() {
+			t.current.close()
+		}
+		t.current = nil
+	}
+	if t.current == nil {
+		client, err := dial(conn)
+		if err != nil {
+			return nil, err
+		}
+		t.current = &handle{client: client}
+		t.updatedAt = conn.UpdatedAt
+		go keepAlive(t, t.current)
+	}
+	t.current.acquire()
+	t.lastUsed = time.Now()
+	return t.current, nil
+}
+
+// release marks one user of h as done.
+func (t *tunnel) release(h *handle) {
+	t.mu.Lock()
+	t.lastUsed = time.Now()
+	t.mu.Unlock()
+	h.release()
+}
+
+// drop closes h and forgets it, so the next dial reconnects. Connections
+// still forwarded over it have lost the jump host anyway.
+func (t *tunnel) drop(h *handle) {
+	t.mu.Lock()
+	if t.current == h {
+		t.current = nil
+	}
+	t.mu.Unlock()
+	h.retire()
+	h.close()
+}
+
+// keepAlive pings the jump host so dead tunnels are noticed before the next
+// query. It stops once the client is closed.
+func keepAlive(t *tunnel, h *handle) {
+	ticker := time.NewTicker(keepAliveInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if !alive(h.client) {
+			t.drop(h)
+			return
+		}
+	}
+}
+
+func alive(client *ssh.Client) bool {
+	_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+	return err == nil
+}
+
+func (m *manager) Close(connectionID int) error {
+	m.mu.Lock()
+	var h *handle
+	if t, ok := m.tunnels[connectionID]; ok {
+		delete(m.tunnels, connectionID)
+		t.mu.Lock()
+		h = t.retire()
+		t.mu.Unlock()
+	}
+	m.mu.Unlock()
+	if h == nil {
+		return nil
+	}
+	return h.close()
+}
+
+func (m *manager) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.idleTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.evictIdle()
+		}
+	}
+}
+
+func (m *manager) evictIdle() {
+	cutoff := time.Now().Add(-m.idleTimeout)
+
+	// the tunnel is checked and retired under both locks, so it cannot be
+	// handed out or replaced in between
+	m.mu.Lock()
+	var idle []*handle
+	for id, t := range m.tunnels {
+		t.mu.Lock()
+		if !t.inUse() && t.lastUsed.Before(cutoff) {
+			delete(m.tunnels, id)
+			if h := t.retire(); h != nil {
+				idle = append(idle, h)
+			}
+		}
+		t.mu.Unlock()
+	}
+	m.mu.Unlock()
+
+	for _, h := range idle {
+		h.close()
+	}
+}
+
+// inUse reports whether any database connection is forwarded over the
+// tunnel's current client. The caller holds t.mu.
+func (t *tunnel) inUse() bool {
+	if t.current == nil {
+		return false
+	}
+	t.current.mu.Lock()
+	defer t.current.mu.Unlock()
+	return t.current.active > 0
+}
+
+// retire marks a tunnel that was removed from the manager as closed and
+// returns its client for the caller to close, unless it is still in use, in
+// which case the last release closes it. The caller holds t.mu.
+func (t *tunnel) retire() *handle {
+	t.closed = true
+	h := t.current
+	t.current = nil
+	if h == nil || !h.retire() {
+		return nil
+	}
+	return h
+}
+
+// acquire counts the caller as a user of the handle. The caller holds the
+// tunnel's mu, so a retired handle is never acquired.
+func (h *handle) acquire() {
+	h.mu.Lock()
+	h.active++
+	h.mu.Unlock()
+}
+
+func (h *handle) release() {
+	h.mu.Lock()
+	h.active--
+	closeNow := h.retired && h.active == 0
+	h.mu.Unlock()
+	if closeNow {
+		h.close()
+	}
+}
+
+// retire marks the handle as retired and reports whether the caller should
+// close it now, because nobody is using it. Otherwise the last release
+// closes it.
+func (h *handle) retire() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.retired = true
+	return h.active == 0
+}
+
+func (h *handle) close() error {
+	h.closeOnce.Do(func() { h.closeErr = h.client.Close() })
+	return h.closeErr
+}
+
+func dial(conn *models.DatabaseConnection) (*ssh.Client, error) {
+	signer, err := ssh.ParsePrivateKey([]byte(conn.SSHPrivateKey))
+	if err != nil {
+		return nil, errors.New("invalid ssh_private_key: " + err.Error())
+	}
+	hostKey, err := parseHostKey(conn.SSHHostKey)
+	if err != nil {
+		return nil, err
+	}
+
+	port := conn.SSHPort
+	if port == "" {
+		port = "22"
+	}
+	config := &ssh.ClientConfig{
+		User:            conn.SSHUser,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: ssh.FixedHostKey(hostKey),
+		Timeout:         dialTimeout,
+	}
+	client, err := ssh.Dial("tcp", net.JoinHostPort(conn.SSHHost, port), config)
+	if err != nil {
+		return nil, fmt.Errorf("ssh tunnel to %s: %w", conn.SSHHost, err)
+	}
+	return client, nil
+}
+
+// parseHostKey accepts a public key in authorized_keys format or a line of
+// ssh-keyscan output. Host keys are required: without one a tunnel could be
+// intercepted and the database password read in transit.
+func parseHostKey(value string) (ssh.PublicKey, error) {
+	if value == "" {
+		return nil, errors.New("ssh_host_key is required with ssh_host; get it with ssh-keyscan")
+	}
+	if _, _, key, _, _, err := ssh.ParseKnownHosts([]byte(value)); err == nil {
+		return key, nil
+	}
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(value))
+	if err != nil {
+		return nil, errors.New("invalid ssh_host_key: " + err.Error())
+	}
+	return key, nil
+}
+
+// tunnelConn reports back to its tunnel when the database connection using
+// it is closed.
+type tunnelConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *tunnelConn) Close() error {
+	c.once.Do(c.release)
+	return c.Conn.Close()
+}
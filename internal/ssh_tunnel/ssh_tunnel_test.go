@@ -0,0 +1,295 @@
+package sshtunnel
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/cr34t1ve/hoprun/pkg/models"
+)
+
+// testServer is a stand-in sshd that forwards direct-tcpip channels and
+// reports every SSH connection it accepts and sees closed.
+type testServer struct {
+	addr    string
+	hostKey string
+	opened  chan struct{}
+	closed  chan struct{}
+}
+
+func newTestServer(t *testing.T, clientKey ssh.PublicKey) *testServer {
+	t.Helper()
+	_, hostPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) != string(clientKey.Marshal()) {
+				return nil, io.EOF
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	s := &testServer{
+		addr:    listener.Addr().String(),
+		hostKey: string(ssh.MarshalAuthorizedKey(hostSigner.PublicKey())),
+		opened:  make(chan struct{}, 16),
+		closed:  make(chan struct{}, 16),
+	}
+	go func() {
+		for {
+			netConn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(netConn, config)
+		}
+	}()
+	return s
+}
+
+func (s *testServer) serve(netConn net.Conn, config *ssh.ServerConfig) {
+	serverConn, channels, requests, err := ssh.NewServerConn(netConn, config)
+	if err != nil {
+		netConn.Close()
+		return
+	}
+	s.opened <- struct{}{}
+	go ssh.DiscardRequests(requests)
+	go func() {
+		for newChannel := range channels {
+			go forward(newChannel)
+		}
+	}()
+	serverConn.Wait()
+	s.closed <- struct{}{}
+}
+
+func forward(newChannel ssh.NewChannel) {
+	if newChannel.ChannelType() != "direct-tcpip" {
+		newChannel.Reject(ssh.UnknownChannelType, "")
+		return
+	}
+	var target struct {
+		Host     string
+		Port     uint32
+		OrigHost string
+		OrigPort uint32
+	}
+	if err := ssh.Unmarshal(newChannel.ExtraData(), &target); err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	conn, err := net.Dial("tcp", net.JoinHostPort(target.Host, strconv.Itoa(int(target.Port))))
+	if err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(requests)
+	go func() {
+		io.Copy(channel, conn)
+		channel.CloseWrite()
+	}()
+	io.Copy(conn, channel)
+	conn.Close()
+}
+
+// newEchoServer stands in for the database behind the jump host.
+func newEchoServer(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func newTestConnection(t *testing.T) (*models.DatabaseConnection, ssh.PublicKey) {
+	t.Helper()
+	clientPub, clientPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(clientPriv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey, err := ssh.NewPublicKey(clientPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &models.DatabaseConnection{
+		ID:            1,
+		SSHUser:       "hoprun",
+		SSHPrivateKey: string(pem.EncodeToMemory(block)),
+		UpdatedAt:     time.Now(),
+	}, publicKey
+}
+
+func useServer(conn *models.DatabaseConnection, s *testServer) {
+	host, port, _ := net.SplitHostPort(s.addr)
+	conn.SSHHost, conn.SSHPort, conn.SSHHostKey = host, port, s.hostKey
+}
+
+func wait(t *testing.T, ch chan struct{}, what string) {
+	t.Helper()
+	select {
+	case <-ch:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for %s", what)
+	}
+}
+
+func expectNone(t *testing.T, ch chan struct{}, what string) {
+	t.Helper()
+	select {
+	case <-ch:
+		t.Fatalf("unexpected %s", what)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func echo(t *testing.T, conn net.Conn, message string) {
+	t.Helper()
+	if _, err := conn.Write([]byte(message)); err != nil {
+		t.Fatalf("write through tunnel: %v", err)
+	}
+	buf := make([]byte, len(message))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("read through tunnel: %v", err)
+	}
+	if string(buf) != message {
+		t.Fatalf("echo = %q, want %q", buf, message)
+	}
+}
+
+func TestDialerRejectsUnknownHostKey(t *testing.T) {
+	conn, clientKey := newTestConnection(t)
+	server := newTestServer(t, clientKey)
+	other := newTestServer(t, clientKey)
+	useServer(conn, server)
+	conn.SSHHostKey = other.hostKey
+
+	m := NewManager(time.Minute)
+	_, err := m.Dialer(conn)(context.Background(), "tcp", newEchoServer(t))
+	if err == nil {
+		t.Fatal("dial with the wrong host key succeeded")
+	}
+	expectNone(t, server.opened, "SSH connection")
+}
+
+func TestDialerReconnectsAfterSettingsChange(t *testing.T) {
+	conn, clientKey := newTestConnection(t)
+	server := newTestServer(t, clientKey)
+	useServer(conn, server)
+	database := newEchoServer(t)
+
+	m := NewManager(time.Minute)
+	first, err := m.Dialer(conn)(context.Background(), "tcp", database)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	wait(t, server.opened, "first SSH connection")
+	echo(t, first, "before")
+
+	// the same client is reused while the settings are unchanged
+	reused, err := m.Dialer(conn)(context.Background(), "tcp", database)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reused.Close()
+	expectNone(t, server.opened, "second SSH connection for unchanged settings")
+
+	updated := *conn
+	updated.UpdatedAt = conn.UpdatedAt.Add(time.Second)
+	second, err := m.Dialer(&updated)(context.Background(), "tcp", database)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	wait(t, server.opened, "SSH connection for the new settings")
+	echo(t, second, "after")
+
+	// the old client is retired, not closed, while it still carries a
+	// database connection
+	expectNone(t, server.closed, "close of the retired client while in use")
+	echo(t, first, "still open")
+
+	first.Close()
+	wait(t, server.closed, "close of the retired client")
+	expectNone(t, server.closed, "close of the current client")
+}
+
+func TestEvictIdleClosesUnusedTunnels(t *testing.T) {
+	conn, clientKey := newTestConnection(t)
+	server := newTestServer(t, clientKey)
+	useServer(conn, server)
+	database := newEchoServer(t)
+
+	m := NewManager(time.Millisecond).(*manager)
+	netConn, err := m.Dialer(conn)(context.Background(), "tcp", database)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wait(t, server.opened, "SSH connection")
+
+	// a tunnel in use is never evicted, however long ago it was opened
+	time.Sleep(5 * time.Millisecond)
+	m.evictIdle()
+	expectNone(t, server.closed, "close of a tunnel in use")
+	echo(t, netConn, "busy")
+
+	netConn.Close()
+	time.Sleep(5 * time.Millisecond)
+	m.evictIdle()
+	wait(t, server.closed, "close of the idle tunnel")
+
+	// the next dial opens a new tunnel
+	netConn, err = m.Dialer(conn)(context.Background(), "tcp", database)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer netConn.Close()
+	wait(t, server.opened, "SSH connection after eviction")
+}
@@ -0,0 +1,244 @@
+package sso
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// loginTTL is how long a user has to finish signing in at the provider
+	loginTTL = 10 * time.Minute
+	// maxPendingLogins caps the sign-ins kept in memory, since anyone can
+	// start one; when full, the oldest is dropped
+	maxPendingLogins = 10000
+)
+
+// ErrInvalidLogin is returned when a sign-in cannot be completed: its state
+// is unknown or expired, the code is rejected, or the ID token does not
+// verify.
+var ErrInvalidLogin = errors.New("invalid or expired sign-in")
+
+// Config describes the OpenID Connect provider users sign in with and how
+// HopRun is registered there.
+type Config struct {
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	// SecureCookie marks the cookie tying a sign-in to its browser Secure,
+	// so it is only sent over HTTPS
+	SecureCookie bool
+}
+
+// ConfigFromEnv reads OIDC_ISSUER_URL, OIDC_CLIENT_ID, OIDC_CLIENT_SECRET,
+// OIDC_REDIRECT_URL, OIDC_SCOPES and OIDC_COOKIE_SECURE. It returns nil when
+// OIDC_ISSUER_URL is unset, meaning single sign-on is disabled. Cookies are
+// Secure unless the redirect URL is plain HTTP on localhost; HopRun is
+// usually behind a proxy that terminates TLS, so the request itself cannot
+// tell.
+func ConfigFromEnv() (*Config, error) {
+	config := &Config{
+		IssuerURL:    os.Getenv("OIDC_ISSUER_URL"),
+		ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+		Scopes:       strings.Fields(os.Getenv("OIDC_SCOPES")),
+	}
+	if config.IssuerURL == "" {
+		return nil, nil
+	}
+	if config.ClientID == "" || config.RedirectURL == "" {
+		return nil, errors.New("OIDC_CLIENT_ID and OIDC_REDIRECT_URL must be set with OIDC_ISSUER_URL")
+	}
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{oidc.ScopeOpenID, "email", "profile"}
+	}
+
+	redirectURL, err := url.Parse(config.RedirectURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid OIDC_REDIRECT_URL: %w", err)
+	}
+	config.SecureCookie = redirectURL.Scheme != "http" || !isLocalhost(redirectURL.Hostname())
+	if value := os.Getenv("OIDC_COOKIE_SECURE"); value != "" {
+		if config.SecureCookie, err = strconv.ParseBool(value); err != nil {
+			return nil, fmt.Errorf("invalid OIDC_COOKIE_SECURE: %w", err)
+		}
+	}
+	return config, nil
+}
+
+func isLocalhost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Identity is who the provider says signed in.
+type Identity struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+}
+
+// Service runs the authorization code flow with PKCE against the provider.
+// Sign-ins in progress are kept in memory, so the callback has to reach the
+// instance that started the sign-in.
+type Service interface {
+	// AuthCodeURL starts a sign-in and returns its state along with the
+	// provider URL to send the user to. loginHint, if set, is passed on to
+	// the provider as the account to sign in with.
+	AuthCodeURL(loginHint string) (state, url string, err error)
+	// Exchange completes the sign-in started with state: it redeems code,
+	// verifies the ID token's signature against the provider's keys, its
+	// issuer, audience, expiry and nonce, and returns the identity in it.
+	// Each state can be used once.
+	Exchange(ctx context.Context, state, code string) (*Identity, error)
+	// SecureCookie reports whether the state cookie should be Secure.
+	SecureCookie() bool
+}
+
+type pendingLogin struct {
+	nonce     string
+	verifier  string
+	expiresAt time.Time
+}
+
+type service struct {
+	oauthConfig  oauth2.Config
+	verifier     *oidc.IDTokenVerifier
+	secureCookie bool
+
+	mu      sync.Mutex
+	pending map[string]pendingLogin
+	// order holds the states in the order they were started, which is also
+	// the order they expire in. States already exchanged stay in it until
+	// they reach the front.
+	order []string
+}
+
+// NewService fetches the provider's discovery document. The provider's
+// signing keys are fetched when first needed and again when it rotates them.
+func NewService(ctx context.Context, config *Config) (Service, error) {
+	provider, err := oidc.NewProvider(ctx, config.IssuerURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &service{
+		oauthConfig: oauth2.Config{
+			ClientID:     config.ClientID,
+			ClientSecret: config.ClientSecret,
+			Endpoint:     provider.Endpoint(),
+			RedirectURL:  config.RedirectURL,
+			Scopes:       config.Scopes,
+		},
+		verifier:     provider.Verifier(&oidc.Config{ClientID: config.ClientID}),
+		secureCookie: config.SecureCookie,
+		pending:      make(map[string]pendingLogin),
+	}, nil
+}
+
+func (s *service) SecureCookie() bool {
+	return s.secureCookie
+}
+
+func (s *service) AuthCodeURL(loginHint string) (string, string, error) {
+	state, err := randomString()
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomString()
+	if err != nil {
+		return "", "", err
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	s.mu.Lock()
+	now := time.Now()
+	for len(s.order) > 0 {
+		oldest, ok := s.pending[s.order[0]]
+		if ok && !now.After(oldest.expiresAt) && len(s.order) < maxPendingLogins {
+			break
+		}
+		delete(s.pending, s.order[0])
+		s.order = s.order[1:]
+	}
+	s.pending[state] = pendingLogin{nonce: nonce, verifier: verifier, expiresAt: now.Add(loginTTL)}
+	s.order = append(s.order, state)
+	s.mu.Unlock()
+
+	opts := []oauth2.AuthCodeOption{oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier)}
+	if loginHint != "" {
+		opts = append(opts, oauth2.SetAuthURLParam("login_hint", loginHint))
+	}
+	return state, s.oauthConfig.AuthCodeURL(state, opts...), nil
+}
+
+func (s *service) Exchange(ctx context.Context, state, code string) (*Identity, error) {
+	s.mu.Lock()
+	login, ok := s.pending[state]
+	delete(s.pending, state)
+	s.mu.Unlock()
+	if !ok || time.Now().After(login.expiresAt) {
+		return nil, ErrInvalidLogin
+	}
+
+	token, err := s.oauthConfig.Exchange(ctx, code, oauth2.VerifierOption(login.verifier))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidLogin, err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, fmt.Errorf("%w: the provider returned no ID token", ErrInvalidLogin)
+	}
+	idToken, err := s.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidLogin, err)
+	}
+	if idToken.Nonce != login.nonce {
+		return nil, fmt.Errorf("%w: ID token nonce does not match", ErrInvalidLogin)
+	}
+
+	var claims struct {
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidLogin, err)
+	}
+	if claims.Email == "" {
+		return nil, fmt.Errorf("%w: the ID token has no email claim", ErrInvalidLogin)
+	}
+
+	return &Identity{
+		Issuer:        idToken.Issuer,
+		Subject:       idToken.Subject,
+		Email:         strings.ToLower(claims.Email),
+		EmailVerified: claims.EmailVerified,
+	}, nil
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,271 @@
+package sso
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/cr34t1ve/hoprun/internal/sso/mockidp"
+)
+
+const (
+	testClientID     = "hoprun"
+	testClientSecret = "secret"
+	testRedirectURL  = "http://localhost:8080/oidc/callback"
+)
+
+// newTestService starts the mock provider and returns a service signing in
+// with it, along with the provider's issuer URL.
+func newTestService(t *testing.T) (*service, string) {
+	t.Helper()
+	var idp *mockidp.Provider
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		idp.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	var err error
+	idp, err = mockidp.New(server.URL, testClientID, testClientSecret, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewService(context.Background(), &Config{
+		IssuerURL:    server.URL,
+		ClientID:     testClientID,
+		ClientSecret: testClientSecret,
+		RedirectURL:  testRedirectURL,
+		Scopes:       []string{"openid", "email"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s.(*service), server.URL
+}
+
+// authorize follows authURL at the provider, optionally changing its
+// parameters first, and returns the code the provider redirects back with.
+func authorize(t *testing.T, authURL string, change func(params url.Values)) string {
+	t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if change != nil {
+		params := u.Query()
+		change(params)
+		u.RawQuery = params.Encode()
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(u.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize returned %s", resp.Status)
+	}
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := location.Query().Get("code")
+	if code == "" {
+		t.Fatalf("no code in redirect to %s", location)
+	}
+	return code
+}
+
+func TestExchange(t *testing.T) {
+	s, issuer := newTestService(t)
+
+	state, authURL, err := s.AuthCodeURL("Ada@Example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := u.Query()
+	if params.Get("state") != state || params.Get("nonce") == "" || params.Get("login_hint") != "Ada@Example.com" {
+		t.Fatalf("auth URL %s is missing state, nonce or login_hint", authURL)
+	}
+	if params.Get("code_challenge") == "" || params.Get("code_challenge_method") != "S256" {
+		t.Fatalf("auth URL %s does not use S256 PKCE", authURL)
+	}
+
+	identity, err := s.Exchange(context.Background(), state, authorize(t, authURL, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Issuer != issuer || identity.Subject == "" {
+		t.Errorf("identity = %+v, want a subject at %s", identity, issuer)
+	}
+	if identity.Email != "ada@example.com" || !identity.EmailVerified {
+		t.Errorf("identity = %+v, want the verified, lower-cased login_hint", identity)
+	}
+}
+
+func TestExchangeRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		// change alters the authorization request sent to the provider
+		change func(params url.Values)
+		// prepare runs on the service before the code is exchanged
+		prepare func(s *service, state string)
+	}{
+		{
+			// the provider checks the verifier HopRun sends against the
+			// challenge it was shown, so a code issued for another
+			// challenge cannot be redeemed
+			name:   "pkce verifier mismatch",
+			change: func(params url.Values) { params.Set("code_challenge", "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM") },
+		},
+		{
+			name:   "nonce mismatch",
+			change: func(params url.Values) { params.Set("nonce", "replayed") },
+		},
+		{
+			name: "expired state",
+			prepare: func(s *service, state string) {
+				s.mu.Lock()
+				login := s.pending[state]
+				login.expiresAt = time.Now().Add(-time.Second)
+				s.pending[state] = login
+				s.mu.Unlock()
+			},
+		},
+		{
+			name: "unknown state",
+			prepare: func(s *service, state string) {
+				s.mu.Lock()
+				delete(s.pending, state)
+				s.mu.Unlock()
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := newTestService(t)
+			state, authURL, err := s.AuthCodeURL("")
+			if err != nil {
+				t.Fatal(err)
+			}
+			code := authorize(t, authURL, tt.change)
+			if tt.prepare != nil {
+				tt.prepare(s, state)
+			}
+			if identity, err := s.Exchange(context.Background(), state, code); !errors.Is(err, ErrInvalidLogin) {
+				t.Fatalf("Exchange() = %+v, %v; want ErrInvalidLogin", identity, err)
+			}
+		})
+	}
+}
+
+func TestExchangeStateReuse(t *testing.T) {
+	s, _ := newTestService(t)
+
+	state, authURL, err := s.AuthCodeURL("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Exchange(context.Background(), state, authorize(t, authURL, nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	// a second code for the same sign-in does not make the state valid again
+	code := authorize(t, authURL, nil)
+	if _, err := s.Exchange(context.Background(), state, code); !errors.Is(err, ErrInvalidLogin) {
+		t.Fatalf("second Exchange() = %v, want ErrInvalidLogin", err)
+	}
+}
+
+func TestExchangeFailureUsesState(t *testing.T) {
+	s, _ := newTestService(t)
+
+	state, authURL, err := s.AuthCodeURL("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Exchange(context.Background(), state, "wrong code"); !errors.Is(err, ErrInvalidLogin) {
+		t.Fatalf("Exchange() = %v, want ErrInvalidLogin", err)
+	}
+	// a failed attempt cannot be retried with a valid code
+	if _, err := s.Exchange(context.Background(), state, authorize(t, authURL, nil)); !errors.Is(err, ErrInvalidLogin) {
+		t.Fatalf("Exchange() after a failure = %v, want ErrInvalidLogin", err)
+	}
+}
+
+func TestAuthCodeURLEvictsOldest(t *testing.T) {
+	s, _ := newTestService(t)
+
+	first, _, err := s.AuthCodeURL("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _, err := s.AuthCodeURL("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 2; i < maxPendingLogins; i++ {
+		if _, _, err := s.AuthCodeURL(""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.mu.Lock()
+	_, firstKept := s.pending[first]
+	count := len(s.pending)
+	s.mu.Unlock()
+	if !firstKept || count != maxPendingLogins {
+		t.Fatalf("%d sign-ins pending, first kept %v; want all %d kept", count, firstKept, maxPendingLogins)
+	}
+
+	// one more drops the oldest and only the oldest
+	last, _, err := s.AuthCodeURL("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.mu.Lock()
+	_, firstKept = s.pending[first]
+	_, secondKept := s.pending[second]
+	_, lastKept := s.pending[last]
+	count = len(s.pending)
+	s.mu.Unlock()
+	if firstKept || !secondKept || !lastKept || count != maxPendingLogins {
+		t.Fatalf("%d sign-ins pending, first kept %v, second kept %v, last kept %v; want the first dropped", count, firstKept, secondKept, lastKept)
+	}
+	if _, err := s.Exchange(context.Background(), first, "code"); !errors.Is(err, ErrInvalidLogin) {
+		t.Fatalf("Exchange() of an evicted sign-in = %v, want ErrInvalidLogin", err)
+	}
+}
+
+func TestAuthCodeURLDropsExpired(t *testing.T) {
+	s, _ := newTestService(t)
+
+	expired, _, err := s.AuthCodeURL("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.mu.Lock()
+	login := s.pending[expired]
+	login.expiresAt = time.Now().Add(-time.Second)
+	s.pending[expired] = login
+	s.mu.Unlock()
+
+	if _, _, err := s.AuthCodeURL(""); err != nil {
+		t.Fatal(err)
+	}
+	s.mu.Lock()
+	_, kept := s.pending[expired]
+	s.mu.Unlock()
+	if kept {
+		t.Fatal("expired sign-in still pending")
+	}
+}
@@ -0,0 +1,426 @@
+package team
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/cr34t1ve/hoprun/pkg/models"
+)
+
+// InvitationTTL is how long an invitation can be accepted for.
+const InvitationTTL = 7 * 24 * time.Hour
+
+var (
+	// ErrLastOwner is returned when a change would leave an organization
+	// without an owner.
+	ErrLastOwner = errors.New("an organization needs at least one owner")
+	// ErrInvalidInvitation is returned for invitation tokens that are
+	// unknown, expired or already used.
+	ErrInvalidInvitation = errors.New("invalid or expired invitation")
+	// ErrInvitationEmail is returned when an invitation is accepted by a user
+	// other than the one it was sent to.
+	ErrInvitationEmail = errors.New("invitation was sent to a different email address")
+	// ErrNotOrganizationMember is returned when a user who is not in a
+	// project's organization is added to the project.
+	ErrNotOrganizationMember = errors.New("join the project's organization first")
+)
+
+type Service interface {
+	// CreateOrganization creates an organization owned by ownerID.
+	CreateOrganization(ctx context.Context, name string, ownerID int) (*models.Organization, error)
+	ListOrganizations(ctx context.Context, userID int) (*[]models.Organization, error)
+	// OrganizationRole returns the user's role in an organization, or "" if
+	// they are not a member.
+	OrganizationRole(ctx context.Context, userID, organizationID int) (string, error)
+	ListOrganizationMembers(ctx context.Context, organizationID int) (*[]models.OrganizationMember, error)
+	SetOrganizationRole(ctx context.Context, organizationID, userID int, role string) error
+	// RemoveOrganizationMember also removes the user from the organization's
+	// projects.
+	RemoveOrganizationMember(ctx context.Context, organizationID, userID int) error
+
+	// ProjectRole returns the user's effective role in a project: owner for
+	// its creator, otherwise the higher of their member role and the role
+	// their organization role grants. Projects in an organization are only
+	// open to its current members. It returns "" for users with no access.
+	ProjectRole(ctx context.Context, userID int, project *models.Project) (string, error)
+	// MemberRole returns the role of a project member row, or "" if the user
+	// has none.
+	MemberRole(ctx context.Context, projectID, userID int) (string, error)
+	ListProjectMembers(ctx context.Context, projectID int) (*[]models.ProjectMember, error)
+	SetProjectRole(ctx context.Context, projectID, userID int, role string) error
+	RemoveProjectMember(ctx context.Context, projectID, userID int) error
+
+	// CreateInvitation stores an invitation and returns it with its token,
+	// which is not stored and cannot be shown again.
+	CreateInvitation(ctx context.Context, invitation *models.Invitation) (*models.Invitation, string, error)
+	GetInvitation(ctx context.Context, invitationID int) (*models.Invitation, error)
+	// ListInvitations returns the pending invitations to an organization or
+	// a project; exactly one of the IDs is set.
+	ListInvitations(ctx context.Context, organizationID, projectID *int) (*[]models.Invitation, error)
+	DeleteInvitation(ctx context.Context, invitationID int) error
+	// AcceptInvitation makes userID a member with the invitation's role. A
+	// user who already has a higher role keeps it.
+	AcceptInvitation(ctx context.Context, token string, userID int) (*models.Invitation, error)
+}
+
+type service struct {
+	db *gorm.DB
+}
+
+func NewService(db *gorm.DB) Service {
+	return &service{db: db}
+}
+
+func (s *service) CreateOrganization(ctx context.Context, name string, ownerID int) (*models.Organization, error) {
+	organization := &models.Organization{Name: name}
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(organization).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.OrganizationMember{
+			OrganizationID: organization.ID,
+			UserID:         ownerID,
+			Role:           models.OrgRoleOwner,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return organization, nil
+}
+
+func (s *service) ListOrganizations(ctx context.Context, userID int) (*[]models.Organization, error) {
+	var organizations []models.Organization
+	results := s.db.WithContext(ctx).
+		Where("id IN (?)", s.db.Model(&models.OrganizationMember{}).Select("organization_id").Where("user_id = ?", userID)).
+		Order("id").Find(&organizations)
+	if results.Error != nil {
+		return nil, results.Error
+	}
+	return &organizations, nil
+}
+
+func (s *service) OrganizationRole(ctx context.Context, userID, organizationID int) (string, error) {
+	var members []models.OrganizationMember
+	err := s.db.WithContext(ctx).
+		Where("organization_id = ? AND user_id = ?", organizationID, userID).
+		Limit(1).Find(&members).Error
+	if err != nil || len(members) == 0 {
+		return "", err
+	}
+	return members[0].Role, nil
+}
+
+func (s *service) ListOrganizationMembers(ctx context.Context, organizationID int) (*[]models.OrganizationMember, error) {
+	var members []models.OrganizationMember
+	results := s.db.WithContext(ctx).Model(&models.OrganizationMember{}).
+		Select("organization_members.*, users.email").
+		Joins("JOIN users ON users.id = organization_members.user_id").
+		Where("organization_members.organization_id = ?", organizationID).
+		Order("organization_members.created_at").Find(&members)
+	if results.Error != nil {
+		return nil, results.Error
+	}
+	return &members, nil
+}
+
+// SetOrganizationRole changes an existing member's role.
+func (s *service) SetOrganizationRole(ctx context.Context, organizationID, userID int, role string) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if role != models.OrgRoleOwner {
+			if err := checkOtherOwner(tx, organizationID, userID); err != nil {
+				return err
+			}
+		}
+		result := tx.Model(&models.OrganizationMember{}).
+			Where("organization_id = ? AND user_id = ?", organizationID, userID).
+			Update("role", role)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+}
+
+func (s *service) RemoveOrganizationMember(ctx context.Context, organizationID, userID int) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := checkOtherOwner(tx, organizationID, userID); err != nil {
+			return err
+		}
+		result := tx.Where("organization_id = ? AND user_id = ?", organizationID, userID).
+			Delete(&models.OrganizationMember{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return tx.Where("user_id = ? AND project_id IN (?)", userID,
+			tx.Model(&models.Project{}).Select("id").Where("organization_id = ?", organizationID)).
+			Delete(&models.ProjectMember{}).Error
+	})
+}
+
+// checkOtherOwner returns ErrLastOwner when userID is the organization's only
+// owner. The owners are locked so concurrent demotions cannot both pass.
+func checkOtherOwner(tx *gorm.DB, organizationID, userID int) error {
+	var owners []models.OrganizationMember
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("organization_id = ? AND role = ?", organizationID, models.OrgRoleOwner).
+		Find(&owners).Error
+	if err != nil {
+		return err
+	}
+	for _, owner := range owners {
+		if owner.UserID != userID {
+			return nil
+		}
+	}
+	if len(owners) == 0 {
+		return nil
+	}
+	return ErrLastOwner
+}
+
+func (s *service) ProjectRole(ctx context.Context, userID int, project *models.Project) (string, error) {
+	// a creator or member who has left the organization keeps no access to
+	// its projects
+	orgRole := ""
+	if project.OrganizationID != nil {
+		var err error
+		orgRole, err = s.OrganizationRole(ctx, userID, *project.OrganizationID)
+		if err != nil || orgRole == "" {
+			return "", err
+		}
+	}
+	if project.UserID == userID {
+		return models.RoleOwner, nil
+	}
+
+	role, err := s.MemberRole(ctx, project.ID, userID)
+	if err != nil {
+		return "", err
+	}
+	if project.OrganizationID == nil {
+		return role, nil
+	}
+
+	granted := ""
+	switch orgRole {
+	case models.OrgRoleOwner, models.OrgRoleAdmin:
+		granted = models.RoleAdmin
+	case models.OrgRoleMember:
+		granted = models.RoleViewer
+	}
+	if !models.RoleAtLeast(role, granted) {
+		role = granted
+	}
+	return role, nil
+}
+
+func (s *service) MemberRole(ctx context.Context, projectID, userID int) (string, error) {
+	var members []models.ProjectMember
+	err := s.db.WithContext(ctx).
+		Where("project_id = ? AND user_id = ?", projectID, userID).
+		Limit(1).Find(&members).Error
+	if err != nil || len(members) == 0 {
+		return "", err
+	}
+	return members[0].Role, nil
+}
+
+func (s *service) ListProjectMembers(ctx context.Context, projectID int) (*[]models.ProjectMember, error) {
+	var members []models.ProjectMember
+	results := s.db.WithContext(ctx).Model(&models.ProjectMember{}).
+		Select("project_members.*, users.email").
+		Joins("JOIN users ON users.id = project_members.user_id").
+		Where("project_members.project_id = ?", projectID).
+		Order("project_members.created_at").Find(&members)
+	if results.Error != nil {
+		return nil, results.Error
+	}
+	return &members, nil
+}
+
+// SetProjectRole changes an existing member's role.
+func (s *service) SetProjectRole(ctx context.Context, projectID, userID int, role string) error {
+	result := s.db.WithContext(ctx).Model(&models.ProjectMember{}).
+		Where("project_id = ? AND user_id = ?", projectID, userID).
+		Update("role", role)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (s *service) RemoveProjectMember(ctx context.Context, projectID, userID int) error {
+	result := s.db.WithContext(ctx).
+		Where("project_id = ? AND user_id = ?", projectID, userID).
+		Delete(&models.ProjectMember{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (s *service) CreateInvitation(ctx context.Context, invitation *models.Invitation) (*models.Invitation, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	invitation.Email = strings.ToLower(strings.TrimSpace(invitation.Email))
+	invitation.TokenHash = hashToken(token)
+	invitation.ExpiresAt = time.Now().Add(InvitationTTL)
+	invitation.AcceptedAt = nil
+	if err := s.db.WithContext(ctx).Create(invitation).Error; err != nil {
+		return nil, "", err
+	}
+	return invitation, token, nil
+}
+
+func (s *service) GetInvitation(ctx context.Context, invitationID int) (*models.Invitation, error) {
+	var invitation models.Invitation
+	if err := s.db.WithContext(ctx).First(&invitation, invitationID).Error; err != nil {
+		return nil, err
+	}
+	return &invitation, nil
+}
+
+func (s *service) ListInvitations(ctx context.Context, organizationID, projectID *int) (*[]models.Invitation, error) {
+	query := s.db.WithContext(ctx).Where("accepted_at IS NULL AND expires_at > ?", time.Now())
+	if organizationID != nil {
+		query = query.Where("organization_id = ?", *organizationID)
+	} else {
+		query = query.Where("project_id = ?", *projectID)
+	}
+
+	var invitations []models.Invitation
+	if err := query.Order("id").Find(&invitations).Error; err != nil {
+		return nil, err
+	}
+	return &invitations, nil
+}
+
+func (s *service) DeleteInvitation(ctx context.Context, invitationID int) error {
+	result := s.db.WithContext(ctx).Delete(&models.Invitation{}, invitationID)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (s *service) AcceptInvitation(ctx context.Context, token string, userID int) (*models.Invitation, error) {
+	var invitation models.Invitation
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ? AND accepted_at IS NULL AND expires_at > ?", hashToken(token), time.Now()).
+			First(&invitation).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrInvalidInvitation
+			}
+			return err
+		}
+
+		var user models.User
+		if err := tx.First(&user, userID).Error; err != nil {
+			return err
+		}
+		if !strings.EqualFold(user.Email, invitation.Email) {
+			return ErrInvitationEmail
+		}
+
+		if invitation.OrganizationID != nil {
+			err = addOrganizationMember(tx, *invitation.OrganizationID, userID, invitation.Role)
+		} else {
+			err = addProjectMember(tx, *invitation.ProjectID, userID, invitation.Role)
+		}
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		invitation.AcceptedAt = &now
+		return tx.Model(&invitation).Update("accepted_at", now).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &invitation, nil
+}
+
+func addOrganizationMember(tx *gorm.DB, organizationID, userID int, role string) error {
+	var existing []models.OrganizationMember
+	err := tx.Where("organization_id = ? AND user_id = ?", organizationID, userID).Limit(1).Find(&existing).Error
+	if err != nil {
+		return err
+	}
+	if len(existing) == 0 {
+		return tx.Create(&models.OrganizationMember{OrganizationID: organizationID, UserID: userID, Role: role}).Error
+	}
+	if models.OrgRoleAtLeast(existing[0].Role, role) {
+		return nil
+	}
+	return tx.Model(&existing[0]).Update("role", role).Error
+}
+
+func addProjectMember(tx *gorm.DB, projectID, userID int, role string) error {
+	var project models.Project
+	if err := tx.First(&project, projectID).Error; err != nil {
+		return err
+	}
+	if project.OrganizationID != nil {
+		var members []models.OrganizationMember
+		err := tx.Where("organization_id = ? AND user_id = ?", *project.OrganizationID, userID).Limit(1).Find(&members).Error
+		if err != nil {
+			return err
+		}
+		if len(members) == 0 {
+			return ErrNotOrganizationMember
+		}
+	}
+	if project.UserID == userID {
+		return nil
+	}
+
+	var existing []models.ProjectMember
+	err := tx.Where("project_id = ? AND user_id = ?", projectID, userID).Limit(1).Find(&existing).Error
+	if err != nil {
+		return err
+	}
+	if len(existing) == 0 {
+		return tx.Create(&models.ProjectMember{ProjectID: projectID, UserID: userID, Role: role}).Error
+	}
+	if models.RoleAtLeast(existing[0].Role, role) {
+		return nil
+	}
+	return tx.Model(&existing[0]).Update("role", role).Error
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,44 @@
+package models
+
+import "time"
+
+// APIKey lets a service call HopRun on behalf of one project without a user
+// session. Only the SHA-256 of the key is stored; Prefix is its first
+// characters, kept so keys can be told apart in listings.
+type APIKey struct {
+	ID        int    `json:"id"`
+	ProjectID int    `json:"project_id" gorm:"index"`
+	Name      string `json:"name"`
+	Prefix    string `json:"prefix"`
+	KeyHash   string `json:"-" gorm:"uniqueIndex"`
+	// Scopes limit the routes the key can call, see the APIKeyScope
+	// constants
+	Scopes []string `json:"scopes" gorm:"serializer:json"`
+	// CreatedBy is the user who created the key; the key acts as them
+	CreatedBy  int        `json:"created_by"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+const (
+	// APIKeyScopeQuery allows running natural language queries
+	APIKeyScopeQuery = "query"
+	// APIKeyScopeReadMetadata allows listing the project's connections,
+	// context documents, pool and schema cache statistics
+	APIKeyScopeReadMetadata = "read_metadata"
+	// APIKeyScopeAdmin allows everything the other scopes do and managing
+	// the project's connections and context documents
+	APIKeyScopeAdmin = "admin"
+)
+
+// HasScope reports whether the key grants scope. Admin keys grant every
+// scope.
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope || s == APIKeyScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
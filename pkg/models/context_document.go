@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+// ContextDocument is a markdown document of business rules and definitions
+// attached to a project and added to every prompt for that project.
+type ContextDocument struct {
+	ID        int       `json:"id"`
+	ProjectID int       `json:"project_id"`
+	Title     string    `json:"title"`
+	Content   string    `json:"content"`
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ContextDocumentVersion is an immutable snapshot written each time a context
+// document is created or updated. Versions outlive their document, so they
+// carry its project for access checks after it is deleted.
+type ContextDocumentVersion struct {
+	ID                int       `json:"id"`
+	ProjectID         int       `json:"project_id" gorm:"index"`
+	ContextDocumentID int       `json:"context_document_id" gorm:"uniqueIndex:idx_context_document_version"`
+	Version           int       `json:"version" gorm:"uniqueIndex:idx_context_document_version"`
+	Title             string    `json:"title"`
+	Content           string    `json:"content"`
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+// ContextDocumentRef identifies the version of a context document that was
+// used to generate a query.
+type ContextDocumentRef struct {
+	ContextDocumentID int `json:"context_document_id"`
+	Version           int `json:"version"`
+}
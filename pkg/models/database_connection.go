@@ -1,14 +1,115 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 type DatabaseConnection struct {
-	ID         int       `json:"id"`
-	ProjectID  int       `json:"projecct_id"`
-	DBName     string    `json:"db_name"`
-	DBUser     string    `json:"db_user"`
-	DBPassword string    `json:"db_password"`
-	DBHost     string    `json:"db_host"`
-	DBPort     string    `json:"db_port" gorm:"default:5432"`
-	CreatedAt  time.Time `json:"created_at"`
+	ID        int `json:"id"`
+	ProjectID int `json:"project_id" gorm:"uniqueIndex:idx_connection_project_name"`
+	// Name identifies the connection within its project, e.g. "primary" or
+	// "reporting"
+	Name string `json:"name" gorm:"uniqueIndex:idx_connection_project_name"`
+	// Dialect is the kind of database: postgres, mysql (which also covers
+	// MariaDB), or the file-backed sqlite and duckdb
+	Dialect string `json:"dialect" gorm:"default:postgres"`
+	// FilePath locates a sqlite or duckdb database, relative to the
+	// directory HopRun serves database files from
+	FilePath string `json:"file_path"`
+	DBName   string `json:"db_name"`
+	DBUser   string `json:"db_user"`
+	// DBPassword is encrypted with the row's data key and never serialized
+	DBPassword string `json:"-"`
+	DBHost     string `json:"db_host"`
+	DBPort     string `json:"db_port" gorm:"default:5432"`
+	// SSLMode takes the libpq values, disable through verify-full.
+	// SSLRootCert and SSLCert hold PEM certificates; SSLKey is the client
+	// key, encrypted like the password and never serialized
+	SSLMode         string `json:"sslmode" gorm:"default:prefer"`
+	SSLRootCert     string `json:"sslrootcert"`
+	SSLCert         string `json:"sslcert"`
+	SSLKey          string `json:"-"`
+	ApplicationName string `json:"application_name"`
+	// Params are extra libpq connection parameters such as connect_timeout
+	// or target_session_attrs
+	Params map[string]string `json:"params" gorm:"serializer:json"`
+	// SSHHost is an optional jump host the database is reached through; the
+	// database host and port are resolved from there. SSHPrivateKey is
+	// encrypted like the password and never serialized. SSHHostKey is the
+	// jump host's public key, as printed by ssh-keyscan
+	SSHHost       string `json:"ssh_host"`
+	SSHPort       string `json:"ssh_port"`
+	SSHUser       string `json:"ssh_user"`
+	SSHPrivateKey string `json:"-"`
+	SSHHostKey    string `json:"ssh_host_key"`
+	// IncludeSchemas limits introspection to the listed schemas; all
+	// non-system schemas are introspected when it is empty
+	IncludeSchemas []string `json:"include_schemas" gorm:"serializer:json"`
+	ExcludeSchemas []string `json:"exclude_schemas" gorm:"serializer:json"`
+	// SampleColumnValues adds common values of low-cardinality text columns
+	// to the prompt; they are real data, so it is off unless opted in
+	SampleColumnValues bool `json:"sample_column_values"`
+	// StatementTimeoutMs and MaxRows bound each user query; zero uses the
+	// server defaults
+	StatementTimeoutMs int `json:"statement_timeout_ms"`
+	MaxRows            int `json:"max_rows"`
+	// MaxOpenConns and MaxIdleConns size the pool HopRun keeps for this
+	// database; zero uses the server defaults
+	MaxOpenConns int       `json:"max_open_conns"`
+	MaxIdleConns int       `json:"max_idle_conns"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	// Status, LastCheckedAt and LastError record the most recent health check
+	Status        string     `json:"status" gorm:"default:unknown"`
+	LastCheckedAt *time.Time `json:"last_checked_at"`
+	LastError     string     `json:"last_error"`
+	// KeyID names the keyring key that wraps DataKey, the per-row key the
+	// credentials are encrypted with
+	KeyID   string `json:"-"`
+	DataKey string `json:"-"`
+}
+
+// MarshalJSON also writes the project ID under projecct_id, the misspelt key
+// responses carried before it was renamed, so clients have a release to move
+// to project_id. The duplicate is due to be dropped in the next release.
+func (c DatabaseConnection) MarshalJSON() ([]byte, error) {
+	type connection DatabaseConnection
+	return json.Marshal(struct {
+		connection
+		LegacyProjectID int `json:"projecct_id"`
+	}{connection(c), c.ProjectID})
+}
+
+const (
+	DialectPostgres = "postgres"
+	DialectMySQL    = "mysql"
+	DialectSQLite   = "sqlite"
+	DialectDuckDB   = "duckdb"
+)
+
+// IsFileDialect reports whether dialect is a database read from a local file
+// rather than reached over the network.
+func IsFileDialect(dialect string) bool {
+	return dialect == DialectSQLite || dialect == DialectDuckDB
+}
+
+const (
+	ConnectionStatusUnknown = "unknown"
+	ConnectionStatusOK      = "ok"
+	ConnectionStatusError   = "error"
+)
+
+// ConnectionCheck is the result of testing a database connection.
+type ConnectionCheck struct {
+	ConnectionID   int       `json:"connection_id"`
+	Status         string    `json:"status"`
+	Error          string    `json:"error,omitempty"`
+	ServerVersion  string    `json:"server_version,omitempty"`
+	CurrentUser    string    `json:"current_user,omitempty"`
+	CanReadCatalog bool      `json:"can_read_catalog"`
+	ReadOnly       bool      `json:"read_only"`
+	Warnings       []string  `json:"warnings,omitempty"`
+	LatencyMs      int64     `json:"latency_ms"`
+	CheckedAt      time.Time `json:"checked_at"`
 }
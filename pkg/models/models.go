@@ -4,4 +4,38 @@ type QueryInput struct {
 	ProjectID     int    `json:"project_id"`
 	Query         string `json:"query"`
 	Visualization string `json:"visualization"`
+	// ConnectionID or Connection (a connection name) pick the database to
+	// query; without either the project's default connection is used
+	ConnectionID int    `json:"connection_id"`
+	Connection   string `json:"connection"`
+	// ConfirmationToken runs a query that was held back by the cost guard
+	// instead of generating a new one
+	ConfirmationToken string `json:"confirmation_token"`
+}
+
+type QueryResponse struct {
+	ConnectionID int      `json:"connection_id"`
+	SQL          string   `json:"sql"`
+	Tables       []string `json:"tables"`
+	// Context lists the versions of the project's context documents that
+	// were included in the prompt
+	Context []ContextDocumentRef `json:"context"`
+	// Attempts holds every SQL statement that was tried, in order; the last
+	// one produced Results, or Error when all of them failed
+	Attempts []QueryAttempt `json:"attempts"`
+	Results  interface{}    `json:"results,omitempty"`
+	// Truncated is set when the result set was cut off at the connection's
+	// row cap
+	Truncated bool `json:"truncated"`
+	// Plan summarises the EXPLAIN output of the final query. LimitApplied is
+	// set when the cost guard lowered the row limit to make it affordable
+	Plan              *QueryPlan `json:"plan,omitempty"`
+	LimitApplied      int        `json:"limit_applied,omitempty"`
+	ConfirmationToken string     `json:"confirmation_token,omitempty"`
+	Error             string     `json:"error,omitempty"`
+}
+
+type QueryAttempt struct {
+	SQL   string `json:"sql"`
+	Error string `json:"error,omitempty"`
 }
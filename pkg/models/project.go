@@ -3,8 +3,36 @@ package models
 import "time"
 
 type Project struct {
-	ID        int       `json:"id" gorm:"primaryKey"`
-	Name      string    `json:"name"`
-	UserID    int       `json:"user_id"`
-	CreatedAt time.Time `json:"created_at"`
+	ID   int    `json:"id" gorm:"primaryKey"`
+	Name string `json:"name"`
+	// UserID is the project's owner. Other users reach the project as its
+	// members or through its organization
+	UserID         int  `json:"user_id"`
+	OrganizationID *int `json:"organization_id" gorm:"index"`
+	// SchemaFormat selects how the database schema is rendered in the
+	// prompt: text, ddl or json
+	SchemaFormat string `json:"schema_format" gorm:"default:text"`
+	// PromptMaxTables and PromptTokenBudget bound how much of the schema is
+	// sent with each question; zero uses the server defaults
+	PromptMaxTables   int `json:"prompt_max_tables"`
+	PromptTokenBudget int `json:"prompt_token_budget"`
+	// LLM settings override the deployment defaults; the provider must be
+	// one configured on the server
+	LLMProvider    string   `json:"llm_provider"`
+	LLMModel       string   `json:"llm_model"`
+	LLMTemperature *float32 `json:"llm_temperature"`
+	LLMMaxTokens   int      `json:"llm_max_tokens"`
+	// RepairAttempts is how many times a failing query is sent back to the
+	// model with its database error; zero disables the repair loop
+	RepairAttempts int `json:"repair_attempts"`
+	// MaxQueryCost and MaxQueryRows are EXPLAIN thresholds for generated
+	// queries; zero disables a check. CostGuardAction decides what happens
+	// when one is exceeded: refuse, confirm, or limit to CostGuardLimit rows
+	MaxQueryCost    float64 `json:"max_query_cost"`
+	MaxQueryRows    float64 `json:"max_query_rows"`
+	CostGuardAction string  `json:"cost_guard_action" gorm:"default:refuse"`
+	CostGuardLimit  int     `json:"cost_guard_limit"`
+	// DefaultConnectionID is queried when a request names no connection
+	DefaultConnectionID *int      `json:"default_connection_id"`
+	CreatedAt           time.Time `json:"created_at"`
 }
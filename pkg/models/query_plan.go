@@ -0,0 +1,17 @@
+package models
+
+import "encoding/json"
+
+// QueryPlan summarises the planner's EXPLAIN output for a generated query.
+type QueryPlan struct {
+	NodeType      string  `json:"node_type"`
+	TotalCost     float64 `json:"total_cost"`
+	EstimatedRows float64 `json:"estimated_rows"`
+	// MaxNodeRows is the largest row estimate of any node in the plan, which
+	// exposes large scans hidden under a LIMIT or an aggregate
+	MaxNodeRows float64  `json:"max_node_rows"`
+	SeqScans    []string `json:"seq_scans,omitempty"`
+	// Plan is the full EXPLAIN output, included when the plan needs the
+	// user's confirmation
+	Plan json.RawMessage `json:"plan,omitempty"`
+}
@@ -0,0 +1,51 @@
+package models
+
+const (
+	TableKindTable            = "table"
+	TableKindView             = "view"
+	TableKindMaterializedView = "materialized view"
+	TableKindForeignTable     = "foreign table"
+)
+
+type Schema struct {
+	Tables []Table `json:"tables"`
+}
+
+type Table struct {
+	Schema      string       `json:"schema"`
+	Name        string       `json:"name"`
+	Kind        string       `json:"kind"`
+	Comment     string       `json:"comment,omitempty"`
+	Columns     []Column     `json:"columns"`
+	PrimaryKey  []string     `json:"primary_key,omitempty"`
+	UniqueKeys  [][]string   `json:"unique_keys,omitempty"`
+	ForeignKeys []ForeignKey `json:"foreign_keys,omitempty"`
+}
+
+type Column struct {
+	Name     string `json:"name"`
+	DataType string `json:"data_type"`
+	Nullable bool   `json:"nullable"`
+	Comment  string `json:"comment,omitempty"`
+	// EnumValues holds the labels of enum-typed columns; SampleValues holds
+	// common values of low-cardinality text columns when sampling is enabled
+	EnumValues   []string `json:"enum_values,omitempty"`
+	SampleValues []string `json:"sample_values,omitempty"`
+}
+
+type ForeignKey struct {
+	Columns           []string `json:"columns"`
+	ReferencedSchema  string   `json:"referenced_schema"`
+	ReferencedTable   string   `json:"referenced_table"`
+	ReferencedColumns []string `json:"referenced_columns"`
+}
+
+// QualifiedName returns the table name prefixed with its schema.
+func (t Table) QualifiedName() string {
+	return t.Schema + "." + t.Name
+}
+
+// ReferencedName returns the qualified name of the referenced table.
+func (fk ForeignKey) ReferencedName() string {
+	return fk.ReferencedSchema + "." + fk.ReferencedTable
+}
@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// Session is one sign-in of a user, kept alive by a rotating refresh token.
+// Only the SHA-256 of the current refresh token is stored. AccessTokenID is
+// the jti of the last access token issued from the session; older access
+// tokens, and every token of a revoked session, are rejected.
+type Session struct {
+	ID               int        `json:"id"`
+	UserID           int        `json:"user_id" gorm:"index"`
+	RefreshTokenHash string     `json:"-" gorm:"uniqueIndex"`
+	AccessTokenID    string     `json:"-" gorm:"uniqueIndex"`
+	ExpiresAt        time.Time  `json:"expires_at"`
+	RevokedAt        *time.Time `json:"revoked_at"`
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+}
+
+// RotatedRefreshToken is a refresh token a session has already exchanged.
+// Presenting one again means it was copied, so the session is revoked.
+type RotatedRefreshToken struct {
+	ID        int       `json:"id"`
+	SessionID int       `json:"session_id" gorm:"index"`
+	TokenHash string    `json:"-" gorm:"uniqueIndex"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,99 @@
+package models
+
+import "time"
+
+// Organization is a team of users that projects can be shared with.
+type Organization struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// OrganizationMember gives a user a role in an organization, one of the
+// OrgRole constants.
+type OrganizationMember struct {
+	OrganizationID int       `json:"organization_id" gorm:"primaryKey;autoIncrement:false"`
+	UserID         int       `json:"user_id" gorm:"primaryKey;autoIncrement:false;index"`
+	Role           string    `json:"role"`
+	Email          string    `json:"email" gorm:"->;-:migration"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// ProjectMember gives a user a role in a project, one of admin, editor or
+// viewer. The project's owner is its UserID and has no member row.
+type ProjectMember struct {
+	ProjectID int       `json:"project_id" gorm:"primaryKey;autoIncrement:false"`
+	UserID    int       `json:"user_id" gorm:"primaryKey;autoIncrement:false;index"`
+	Role      string    `json:"role"`
+	Email     string    `json:"email" gorm:"->;-:migration"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Invitation asks whoever signs in with Email to join an organization or a
+// project with Role. Only the SHA-256 of the invitation token is stored.
+type Invitation struct {
+	ID             int        `json:"id"`
+	OrganizationID *int       `json:"organization_id" gorm:"index"`
+	ProjectID      *int       `json:"project_id" gorm:"index"`
+	Email          string     `json:"email"`
+	Role           string     `json:"role"`
+	TokenHash      string     `json:"-" gorm:"uniqueIndex"`
+	InvitedBy      int        `json:"invited_by"`
+	ExpiresAt      time.Time  `json:"expires_at"`
+	AcceptedAt     *time.Time `json:"accepted_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// Project roles, from most to least privileged. Owners and admins manage
+// connections, API keys and members; editors manage context documents and
+// test connections; viewers can query and read the project but do not see
+// connection settings.
+const (
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleEditor = "editor"
+	RoleViewer = "viewer"
+)
+
+// Organization roles. Organization owners and admins are admins of every
+// project in the organization; members are viewers.
+const (
+	OrgRoleOwner  = "owner"
+	OrgRoleAdmin  = "admin"
+	OrgRoleMember = "member"
+)
+
+var roleRanks = map[string]int{
+	RoleViewer: 1,
+	RoleEditor: 2,
+	RoleAdmin:  3,
+	RoleOwner:  4,
+}
+
+var orgRoleRanks = map[string]int{
+	OrgRoleMember: 1,
+	OrgRoleAdmin:  2,
+	OrgRoleOwner:  3,
+}
+
+// RoleAtLeast reports whether project role has at least the privileges of
+// min. An empty role, meaning no access, satisfies nothing.
+func RoleAtLeast(role, min string) bool {
+	return roleRanks[role] > 0 && roleRanks[role] >= roleRanks[min]
+}
+
+// OrgRoleAtLeast is RoleAtLeast for organization roles.
+func OrgRoleAtLeast(role, min string) bool {
+	return orgRoleRanks[role] > 0 && orgRoleRanks[role] >= orgRoleRanks[min]
+}
+
+// IsValidRole reports whether role can be given to a project member. The
+// owner role cannot; it belongs to the project's creator.
+func IsValidRole(role string) bool {
+	return role == RoleAdmin || role == RoleEditor || role == RoleViewer
+}
+
+// IsValidOrgRole reports whether role is an organization role.
+func IsValidOrgRole(role string) bool {
+	return orgRoleRanks[role] > 0
+}
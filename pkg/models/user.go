@@ -3,8 +3,12 @@ package models
 import "time"
 
 type User struct {
-	ID           int       `json:"id"`
-	Email        string    `json:"email"`
-	PasswordHash string    `json:"-"`
-	CreatedAt    time.Time `json:"created_at"`
+	ID           int    `json:"id"`
+	Email        string `json:"email"`
+	PasswordHash string `json:"-"`
+	// OIDCIssuer and OIDCSubject identify the single sign-on account the user
+	// signs in with. Users created by single sign-on have no PasswordHash.
+	OIDCIssuer  *string   `json:"-" gorm:"column:oidc_issuer;uniqueIndex:idx_users_oidc"`
+	OIDCSubject *string   `json:"-" gorm:"column:oidc_subject;uniqueIndex:idx_users_oidc"`
+	CreatedAt   time.Time `json:"created_at"`
 }